@@ -0,0 +1,143 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package adr implements the LoRaWAN adaptive data rate algorithm that network servers
+// use to assign a data rate and transmit power to devices.
+package adr
+
+import (
+	"math"
+
+	"github.com/bullettime/lora-coverage/region"
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultHistorySize        = 20
+	DefaultInstallationMargin = 10
+	// stepSize is the SNR (dB) that one data rate or TXPower step costs.
+	stepSize = 3
+)
+
+// Config are the parameters of the algorithm.
+type Config struct {
+	// HistorySize is the number of uplinks of which the maximum SNR is taken, no
+	// data rate is assigned before that many uplinks were received.
+	HistorySize int
+	// InstallationMargin is the SNR (dB) that is kept as margin.
+	InstallationMargin float64
+}
+
+// DefaultConfig is the configuration most network servers use.
+var DefaultConfig = Config{
+	HistorySize:        DefaultHistorySize,
+	InstallationMargin: DefaultInstallationMargin,
+}
+
+// Setting is a data rate and TXPower index assigned to a device.
+type Setting struct {
+	DataRate     int
+	TXPowerIndex int
+}
+
+// Device keeps the SNR history of a device.
+type Device struct {
+	config  Config
+	plan    *region.Plan
+	history []float64
+}
+
+func NewDevice(config Config, plan *region.Plan) *Device {
+	return &Device{
+		config: config,
+		plan:   plan,
+	}
+}
+
+// Add adds the SNR of an uplink, for uplinks received by more than one gateway this
+// is the best SNR.
+func (d *Device) Add(snr float64) {
+	d.history = append(d.history, snr)
+	if d.config.HistorySize > 0 && len(d.history) > d.config.HistorySize {
+		d.history = d.history[len(d.history)-d.config.HistorySize:]
+	}
+}
+
+// Ready tells whether the history is long enough to assign a setting, never for an
+// invalid history size.
+func (d *Device) Ready() bool {
+	return d.config.HistorySize > 0 && len(d.history) >= d.config.HistorySize
+}
+
+// MaxSNR returns the highest SNR in the history.
+func (d *Device) MaxSNR() float64 {
+	max := math.Inf(-1)
+	for _, snr := range d.history {
+		max = math.Max(max, snr)
+	}
+	return max
+}
+
+// Recommend returns the setting the network server would assign to the device that
+// currently uses the given setting. The setting is returned unchanged when the
+// history is not long enough yet.
+func (d *Device) Recommend(current Setting) (Setting, error) {
+	if d.config.HistorySize < 1 {
+		return current, errors.Errorf("invalid history size: %d", d.config.HistorySize)
+	}
+	if !d.Ready() {
+		return current, nil
+	}
+
+	dr, err := d.plan.DataRate(current.DataRate)
+	if err != nil {
+		return current, err
+	}
+	if dr.Modulation != region.LoRa {
+		return current, errors.Errorf("no adr for data rate %d (%s)", current.DataRate, dr)
+	}
+
+	margin := d.MaxSNR() - dr.RequiredSNR() - d.config.InstallationMargin
+	steps := int(math.Floor(margin / stepSize))
+
+	setting := current
+
+	// first increase the data rate, then lower the power
+	for steps > 0 && setting.DataRate < d.plan.MaxADRDataRate {
+		setting.DataRate++
+		steps--
+	}
+
+	for steps > 0 && setting.TXPowerIndex < d.plan.MaxTXPowerIndex {
+		setting.TXPowerIndex++
+		steps--
+	}
+
+	// a negative margin only increases the power, the device lowers its data rate
+	// itself when it stops receiving acknowledgements
+	for steps < 0 && setting.TXPowerIndex > 0 {
+		setting.TXPowerIndex--
+		steps++
+	}
+
+	return setting, nil
+}
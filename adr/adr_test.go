@@ -0,0 +1,101 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package adr
+
+import (
+	"testing"
+
+	"github.com/bullettime/lora-coverage/region"
+)
+
+func TestRecommend(t *testing.T) {
+	plan, err := region.Get("EU868")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := Config{HistorySize: 3, InstallationMargin: 10}
+
+	tests := []struct {
+		name     string
+		snr      []float64
+		current  Setting
+		expected Setting
+	}{
+		{"not ready", []float64{7.5, 7.5}, Setting{0, 0}, Setting{0, 0}},
+		{"data rate", []float64{-3, 7.5, 2}, Setting{0, 0}, Setting{5, 0}},
+		{"power", []float64{7.5, 7.5, 7.5}, Setting{5, 1}, Setting{5, 2}},
+		{"max power index", []float64{10, 10, 10}, Setting{5, 7}, Setting{5, 7}},
+		{"negative margin", []float64{-10, -11, -12}, Setting{5, 3}, Setting{5, 0}},
+		{"history window", []float64{10, -10, -11, -12}, Setting{5, 3}, Setting{5, 0}},
+	}
+
+	for _, test := range tests {
+		device := NewDevice(config, plan)
+		for _, snr := range test.snr {
+			device.Add(snr)
+		}
+
+		setting, err := device.Recommend(test.current)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if setting != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.expected, setting)
+		}
+	}
+}
+
+func TestRecommendFSK(t *testing.T) {
+	plan, err := region.Get("EU868")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	device := NewDevice(Config{HistorySize: 1}, plan)
+	device.Add(10)
+
+	if _, err := device.Recommend(Setting{DataRate: 7}); err == nil {
+		t.Error("expected an error for fsk")
+	}
+}
+
+func TestInvalidHistorySize(t *testing.T) {
+	plan, err := region.Get("EU868")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int{0, -1} {
+		device := NewDevice(Config{HistorySize: size}, plan)
+		device.Add(10)
+		device.Add(12)
+
+		if device.Ready() {
+			t.Errorf("history size %d: expected not to be ready", size)
+		}
+		if _, err := device.Recommend(Setting{DataRate: 0}); err == nil {
+			t.Errorf("history size %d: expected an error", size)
+		}
+	}
+}
@@ -21,23 +21,29 @@
 package cmd
 
 import (
-	"bufio"
-	"encoding/json"
+	"bytes"
+	"context"
 	"os"
+	"time"
 
 	"github.com/apex/log"
+	"github.com/bullettime/lora-coverage/coverage"
 	"github.com/bullettime/lora-coverage/db"
+	"github.com/bullettime/lora-coverage/gwmp"
 	"github.com/bullettime/lora-coverage/model"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
-type logMessage struct {
-	Fields    json.RawMessage `json:"fields"`
-	Level     string          `json:"level"`
-	TimeStamp string          `json:"timestamp"`
-	Message   string          `json:"message"`
-}
+var (
+	follow         bool
+	followInterval time.Duration
+	maxErrors      int
+	quarantineFile string
+	pcapFile       bool
+	pcapPort       int
+)
 
 // addCmd represents the add command
 var addCmd = &cobra.Command{
@@ -47,10 +53,24 @@ var addCmd = &cobra.Command{
 
 This command takes one argument:
 	- file name from the json file [eg. lora-log.json]
-It will select the rx packets and add this data to a new or the existing database.`,
+It will select the rx packets and add this data to a new or the existing database.
+The downlinks (PULL_RESP: TXPK) and their TX_ACK are stored as well, see the downlink
+command.
+
+With --follow the file is tailed like 'tail -F': new lines are added as they are written,
+rotation and truncation of the file are detected and the processed byte offset is stored
+in the database, so a restart resumes where the previous run stopped.
+
+Lines that can not be parsed are logged and, with --quarantine, written to a separate file.
+With --max-errors the command aborts once more than the given number of lines failed.
+
+With --pcap the argument is a pcap or pcapng capture of the Semtech UDP traffic instead
+(eg. tcpdump -w backhaul.pcap udp port 1700). The PUSH_DATA packets sent to --port are
+decoded and their rx packets are added, the capture time is used for packets without a
+time field.`,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		database, err := db.Connect()
+		database, err := db.Open(viper.GetString("database.dbfile"))
 		if err != nil {
 			log.WithError(err).WithField("database", viper.GetString("database.dbfile")).Fatal("connecting to database")
 		}
@@ -60,9 +80,21 @@ It will select the rx packets and add this data to a new or the existing databas
 			log.WithError(err).Fatal("initializing database")
 		}
 
-		dbModel := model.New(database)
+		dbModel := model.New(database, modelConfig())
 
-		addDataFromGatewayLogger(args[0], dbModel)
+		tracker, err := newErrorTracker(maxErrors, quarantineFile)
+		if err != nil {
+			log.WithError(err).Fatal("creating error tracker")
+		}
+		defer tracker.close()
+
+		if pcapFile {
+			addDataFromCapture(args[0], dbModel, uint16(pcapPort), tracker)
+		} else if follow {
+			followGatewayLogger(args[0], dbModel, followInterval, tracker)
+		} else {
+			addDataFromGatewayLogger(args[0], dbModel, tracker)
+		}
 	},
 }
 
@@ -78,9 +110,15 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// addCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	addCmd.Flags().BoolVarP(&follow, "follow", "f", false, "keep reading the file as it grows (like tail -F)")
+	addCmd.Flags().DurationVar(&followInterval, "interval", time.Second, "poll interval for new data when following")
+	addCmd.Flags().IntVar(&maxErrors, "max-errors", 0, "abort after this many unparseable lines (0 is no limit)")
+	addCmd.Flags().StringVar(&quarantineFile, "quarantine", "", "file to write unparseable lines to")
+	addCmd.Flags().BoolVar(&pcapFile, "pcap", false, "the file is a pcap/pcapng capture of semtech udp traffic")
+	addCmd.Flags().IntVar(&pcapPort, "port", gwmp.DefaultPort, "udp port of the packet forwarder traffic in the capture")
 }
 
-func addDataFromGatewayLogger(fileName string, dbModel *model.Model) {
+func addDataFromGatewayLogger(fileName string, dbModel *model.Model, tracker *errorTracker) {
 	ctx := log.WithField("data-file", fileName)
 
 	jsonFile, err := os.Open(fileName)
@@ -89,45 +127,30 @@ func addDataFromGatewayLogger(fileName string, dbModel *model.Model) {
 	}
 	defer jsonFile.Close()
 
-	var message logMessage
-
-	lineScanner := bufio.NewScanner(jsonFile)
-	for lineScanner.Scan() {
-		err := json.Unmarshal(lineScanner.Bytes(), &message)
-		if err != nil {
-			log.WithError(err).WithField("line", string(lineScanner.Bytes())).Error("unmarshalling line")
-		} else {
-			if message.Message == "PUSH_DATA: RXPK" {
-				var coverage model.Coverage
-
-				if err := coverage.Unmarshal(message.Fields); err != nil {
-					ctx := log.WithField("fields", string(message.Fields))
-					switch err {
-					case model.InvalidCrcError:
-						ctx.Debug(model.InvalidCrcError.Error())
-					case model.InvalidMicError:
-						ctx.Debug(model.InvalidMicError.Error())
-					case model.InvalidMacPayloadError:
-						ctx.Debug(model.InvalidMacPayloadError.Error())
-					case model.InvalidFramePayloadError:
-						ctx.Debug(model.InvalidFramePayloadError.Error())
-					case model.InvalidPayloadError:
-						ctx.Warn("invalid payload (no location data and/or power)")
-						addCoverageRow(dbModel, &coverage)
-					default:
-						ctx.WithError(err).Error("error unmarshalling fields")
-					}
-				} else {
-					addCoverageRow(dbModel, &coverage)
-				}
-			}
-		}
+	stats, err := coverage.Ingest(context.Background(), jsonFile, coverage.Options{
+		Model: dbModel,
+		OnError: func(lineNumber int, line []byte, err error) error {
+			ctx.WithError(err).WithFields(log.Fields{"line-number": lineNumber, "line": string(line)}).
+				Error("processing line")
+			return errors.Wrap(tracker.add(line), "too many errors")
+		},
+	})
+	if err != nil {
+		ctx.WithError(err).WithField("line-number", stats.Lines).Fatal("adding json file")
 	}
+
+	ctx.WithFields(log.Fields{"lines": stats.Lines, "errors": tracker.count}).Info("done")
 }
 
-func addCoverageRow(dbModel *model.Model, row *model.Coverage) {
-	err := dbModel.AddCoverageRow(row)
-	if err != nil {
-		log.WithError(err).Warn("Did you already scan this file?")
+// processLogLine adds a single line and keeps track of the lines that failed. An
+// error is returned when too many lines failed.
+func processLogLine(line []byte, dbModel *model.Model, tracker *errorTracker, ctx log.Interface) error {
+	line = bytes.TrimRight(line, "\r\n")
+
+	if err := coverage.AddLine(dbModel, line); err != nil {
+		ctx.WithError(err).WithField("line", string(line)).Error("processing line")
+		return errors.Wrap(tracker.add(line), "too many errors")
 	}
+
+	return nil
 }
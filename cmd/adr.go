@@ -0,0 +1,251 @@
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-coverage/adr"
+	"github.com/bullettime/lora-coverage/db"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/bullettime/lora-coverage/region"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	adrHistory int
+	adrMargin  float64
+	adrCell    float64
+)
+
+// adrCmd represents the adr command
+var adrCmd = &cobra.Command{
+	Use:   "adr [device]",
+	Short: "Simulate ADR on the collected data",
+	Long: `lora-coverage adr replays the SNR history of every device through the LoRaWAN ADR algorithm.
+
+This command takes an optional argument:
+	- device address (in hex) [eg. 26011b2c], all devices when omitted
+For every uplink the best SNR of all gateways is added to the history of the device. Once
+the history holds --history uplinks, the maximum SNR minus the demodulation floor of the
+data rate and the --margin gives the number of 3 dB steps: first the data rate is raised,
+then the power is lowered. The data rate and power that ADR would have assigned are
+compared with the ones the device used, together with the airtime that would have been
+saved, per device and per area of --cell degrees.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if adrHistory < 1 {
+			log.WithField("history", adrHistory).Fatal("history must be at least 1")
+		}
+
+		plan, err := region.Get(viper.GetString("lora.region"))
+		if err != nil {
+			log.WithError(err).Fatal("getting region")
+		}
+
+		database, err := db.Open(viper.GetString("database.dbfile"))
+		if err != nil {
+			log.WithError(err).WithField("database", viper.GetString("database.dbfile")).Fatal("connecting to database")
+		}
+		defer database.Disconnect()
+
+		if err := database.Init(); err != nil {
+			log.WithError(err).Fatal("initializing database")
+		}
+
+		dbModel := model.New(database, modelConfig())
+
+		rows, err := dbModel.GetCoverageRows("", "")
+		if err != nil {
+			log.WithError(err).Fatal("getting coverage rows")
+		}
+
+		var device string
+		if len(args) > 0 {
+			device = strings.ToLower(args[0])
+		}
+
+		config := adr.Config{
+			HistorySize:        adrHistory,
+			InstallationMargin: adrMargin,
+		}
+
+		simulateADR(os.Stdout, groupFrames(rows, device), plan, config, adrCell)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(adrCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// adrCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// adrCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	adrCmd.Flags().IntVar(&adrHistory, "history", adr.DefaultHistorySize, "number of uplinks of which the max snr is taken")
+	adrCmd.Flags().Float64Var(&adrMargin, "margin", adr.DefaultInstallationMargin, "installation margin in dB")
+	adrCmd.Flags().Float64Var(&adrCell, "cell", 0.01, "size of the areas in degrees")
+}
+
+// airtime sums the airtime used and the airtime ADR would have used.
+type airtime struct {
+	uplinks int
+	used    time.Duration
+	adr     time.Duration
+}
+
+func (a *airtime) add(used, adr time.Duration) {
+	a.uplinks++
+	a.used += used
+	a.adr += adr
+}
+
+func (a *airtime) String() string {
+	saved := 0.0
+	if a.used > 0 {
+		saved = 100 * float64(a.used-a.adr) / float64(a.used)
+	}
+	return fmt.Sprintf("%d uplinks, airtime %s, with ADR %s (%.0f%% saved)", a.uplinks,
+		a.used.Round(time.Millisecond), a.adr.Round(time.Millisecond), saved)
+}
+
+type adrResult struct {
+	device    string
+	used      adr.Setting
+	usedPower int8
+	assigned  adr.Setting
+	ready     bool
+	maxSNR    float64
+	airtime   airtime
+}
+
+func simulateADR(w io.Writer, frames []*frame, plan *region.Plan, config adr.Config, cell float64) {
+	var results []*adrResult
+	var result *adrResult
+	var device *adr.Device
+	areas := make(map[[2]int64]*airtime)
+
+	for _, f := range frames {
+		if result == nil || result.device != f.device {
+			result = &adrResult{device: f.device}
+			results = append(results, result)
+			device = adr.NewDevice(config, plan)
+		}
+
+		dr, err := f.dataRate.RegionDataRate()
+		if err != nil {
+			continue
+		}
+		index := plan.DataRateIndex(dr)
+		if index < 0 {
+			log.WithFields(log.Fields{"device": f.device, "datarate": dr}).Debug("data rate not in region")
+			continue
+		}
+
+		device.Add(f.snr)
+
+		used := adr.Setting{DataRate: index}
+		if f.power != model.UnknownPower {
+			used.TXPowerIndex = plan.TXPowerIndex(float64(f.power))
+		}
+
+		assigned, err := device.Recommend(used)
+		if err != nil {
+			log.WithError(err).WithField("device", f.device).Debug("simulating adr")
+		}
+
+		usedAirtime := f.timeOnAir(dr)
+		adrAirtime := usedAirtime
+		if device.Ready() {
+			if assignedDR, err := plan.DataRate(assigned.DataRate); err == nil {
+				adrAirtime = f.timeOnAir(assignedDR)
+			}
+		}
+
+		result.used, result.usedPower, result.assigned = used, f.power, assigned
+		result.ready, result.maxSNR = device.Ready(), device.MaxSNR()
+		result.airtime.add(usedAirtime, adrAirtime)
+
+		if (f.latitude != 0 || f.longitude != 0) && cell > 0 {
+			key := [2]int64{int64(math.Floor(f.latitude / cell)), int64(math.Floor(f.longitude / cell))}
+			area, ok := areas[key]
+			if !ok {
+				area = &airtime{}
+				areas[key] = area
+			}
+			area.add(usedAirtime, adrAirtime)
+		}
+	}
+
+	for _, r := range results {
+		if r.airtime.uplinks == 0 {
+			continue
+		}
+
+		usedDR, _ := plan.DataRate(r.used.DataRate)
+		power := "unknown power"
+		if r.usedPower != model.UnknownPower {
+			power = fmt.Sprintf("%d dBm", r.usedPower)
+		}
+		fmt.Fprintf(w, "device %s: used %s (DR%d) at %s", r.device, usedDR, r.used.DataRate, power)
+
+		if r.ready {
+			assignedDR, _ := plan.DataRate(r.assigned.DataRate)
+			fmt.Fprintf(w, ", ADR would assign %s (DR%d) at %.0f dBm, max SNR %.1f dB\n", assignedDR,
+				r.assigned.DataRate, plan.TXPower(r.assigned.TXPowerIndex), r.maxSNR)
+		} else {
+			fmt.Fprintf(w, ", not enough uplinks for ADR (%d of %d)\n", r.airtime.uplinks, config.HistorySize)
+		}
+		fmt.Fprintf(w, "  %s\n", &r.airtime)
+	}
+
+	if len(areas) == 0 {
+		return
+	}
+
+	var keys [][2]int64
+	for key := range areas {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+
+	fmt.Fprintln(w)
+	for _, key := range keys {
+		fmt.Fprintf(w, "area %.4f, %.4f: %s\n", (float64(key[0])+0.5)*cell, (float64(key[1])+0.5)*cell, areas[key])
+	}
+}
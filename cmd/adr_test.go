@@ -0,0 +1,94 @@
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bullettime/lora-coverage/adr"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/bullettime/lora-coverage/region"
+)
+
+// adrFrames returns uplinks at SF12 and 14 dBm with the given SNRs, one per minute.
+func adrFrames(device string, snrs ...float64) []*frame {
+	var frames []*frame
+	for i, snr := range snrs {
+		frames = append(frames, &frame{device: device, time: fixtureTime.Add(time.Duration(i) * time.Minute),
+			dataRate: model.DataRate{LoRa: "SF12BW125"}, frequency: 868.1, size: 20, codingRate: "4/5",
+			power: 14, snr: snr, gateways: 1, latitude: 50.9643, longitude: 1.088})
+	}
+	return frames
+}
+
+func TestSimulateADR(t *testing.T) {
+	plan, err := region.Get("EU868")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := adr.Config{HistorySize: 3, InstallationMargin: 10}
+
+	tests := []struct {
+		name   string
+		frames []*frame
+		output []string
+	}{
+		{
+			name:   "before the history is full",
+			frames: adrFrames("26011b2c", 10, 10),
+			output: []string{"device 26011b2c: used SF12BW125 (DR0) at 14 dBm, not enough uplinks for ADR (2 of 3)\n"},
+		},
+		{
+			name:   "recommendation after the history",
+			frames: adrFrames("26011b2c", -5, 5, 0),
+			output: []string{"device 26011b2c: used SF12BW125 (DR0) at 14 dBm, ADR would assign SF7BW125 (DR5) at 14 dBm, max SNR 5.0 dB\n",
+				"  3 uplinks, airtime 3.957s, with ADR 2.694s (32% saved)\n",
+				"area 50.9650, 1.0850: 3 uplinks, airtime 3.957s, with ADR 2.694s (32% saved)\n"},
+		},
+		{
+			name:   "power after the data rate",
+			frames: adrFrames("26011b2c", 0, 0, 16),
+			output: []string{"ADR would assign SF7BW125 (DR5) at 8 dBm, max SNR 16.0 dB\n"},
+		},
+		{
+			name:   "history per device",
+			frames: append(adrFrames("26011b2c", 10, 10, 10), adrFrames("26011b2d", 10)...),
+			output: []string{"device 26011b2c: used SF12BW125 (DR0) at 14 dBm, ADR would assign",
+				"device 26011b2d: used SF12BW125 (DR0) at 14 dBm, not enough uplinks for ADR (1 of 3)\n"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			simulateADR(&buf, test.frames, plan, config, 0.01)
+			for _, line := range test.output {
+				if !strings.Contains(buf.String(), line) {
+					t.Errorf("expected %q in the output:\n%s", line, buf.String())
+				}
+			}
+		})
+	}
+}
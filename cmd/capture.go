@@ -0,0 +1,97 @@
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/hex"
+	"io"
+	"os"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-coverage/coverage"
+	"github.com/bullettime/lora-coverage/gwmp"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/bullettime/lora-coverage/pcap"
+)
+
+// addDataFromCapture adds the rx packets of the PUSH_DATA datagrams sent to the given
+// port in a pcap or pcapng file. Datagrams that can not be decoded are hex encoded
+// in the quarantine file.
+func addDataFromCapture(fileName string, dbModel *model.Model, port uint16, tracker *errorTracker) {
+	ctx := log.WithField("capture-file", fileName)
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		ctx.WithError(err).Fatal("opening capture file (arg)")
+	}
+	defer file.Close()
+
+	reader, err := pcap.NewReader(file)
+	if err != nil {
+		ctx.WithError(err).Fatal("reading capture file")
+	}
+
+	udpReader := pcap.NewUDPReader(reader)
+	datagrams, packets := 0, 0
+
+	for {
+		datagram, err := udpReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			ctx.WithError(err).WithField("datagrams", datagrams).Fatal("reading capture file")
+		}
+
+		if datagram.DestinationPort != port {
+			continue
+		}
+		datagrams++
+
+		dctx := ctx.WithFields(log.Fields{
+			"time":   datagram.Time,
+			"source": datagram.Source,
+		})
+
+		pushData, err := gwmp.ParsePushData(datagram.Payload)
+		if err == gwmp.NotPushDataError {
+			continue
+		}
+		if err != nil {
+			dctx.WithError(err).Error("decoding datagram")
+			if err := tracker.add([]byte(hex.EncodeToString(datagram.Payload))); err != nil {
+				dctx.WithError(err).Fatal("too many errors")
+			}
+			continue
+		}
+
+		for _, rxpk := range pushData.RxPackets {
+			packets++
+			packet := rxpk.RxPacket(pushData.GatewayMac, datagram.Time)
+			pctx := dctx.WithField("gateway", pushData.GatewayMac)
+			if err := coverage.AddRxPacket(dbModel, packet); err != nil {
+				pctx.WithError(err).Error("processing rx packet")
+			}
+		}
+	}
+
+	ctx.WithFields(log.Fields{"datagrams": datagrams, "rx-packets": packets, "errors": tracker.count}).Info("done")
+}
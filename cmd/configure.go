@@ -24,8 +24,10 @@ import (
 	"encoding/hex"
 
 	"os"
+	"strings"
 
 	"github.com/apex/log"
+	"github.com/bullettime/lora-coverage/region"
 	"github.com/segmentio/go-prompt"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -44,6 +46,7 @@ type databaseConfig struct {
 type loraConfig struct {
 	NwkSKey string `yaml:"nwkskey"`
 	AppSKey string `yaml:"appskey"`
+	Region  string `yaml:"region"`
 }
 
 // configureCmd represents the configure command
@@ -58,6 +61,7 @@ Various different values for settings that are needed to use this tool are asked
 			newDBFile  string
 			newNwkSKey string
 			newAppSKey string
+			newRegion  string
 		)
 
 		newDBFile = prompt.String("database location [eg. coverage.db]")
@@ -81,6 +85,17 @@ Various different values for settings that are needed to use this tool are asked
 			}
 		}
 
+		for newRegion == "" {
+			newRegion = prompt.String("region [eg. EU868]")
+			if len(newRegion) == 0 {
+				newRegion = viper.GetString("lora.region")
+			}
+			if _, err := region.Get(newRegion); err != nil {
+				log.WithError(err).Errorf("unknown region, use one of %s", strings.Join(region.Names(), ", "))
+				newRegion = ""
+			}
+		}
+
 		newConfig := &yamlConfig{
 			Database: databaseConfig{
 				DBFile: newDBFile,
@@ -88,6 +103,7 @@ Various different values for settings that are needed to use this tool are asked
 			Lora: loraConfig{
 				NwkSKey: newNwkSKey,
 				AppSKey: newAppSKey,
+				Region:  strings.ToUpper(newRegion),
 			},
 		}
 
@@ -21,72 +21,83 @@
 package cmd
 
 import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
 
 	"github.com/apex/log"
-	"github.com/brocaar/lorawan"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
+var (
+	decodeFile   string
+	decodeInput  string
+	decodeOutput string
+	decodeKeys   *keyFlags
+)
+
+var hexPayload = regexp.MustCompile(`^([0-9a-fA-F]{2})+$`)
+
 // decodeCmd represents the decode command
 var decodeCmd = &cobra.Command{
-	Use:   "decode",
+	Use:   "decode [payload...]",
 	Short: "Decode LoRaWAN payload",
-	Long: `lora-coverage decode will decode the data from a LoRaWAN payload with the configured keys.
-
-This command expects one argument, the payload in base64 string format.`,
-	Args: cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		data := args[0]
-		log.WithField("data", data).Info("decoding")
-
-		var phy lorawan.PHYPayload
-		if err := phy.UnmarshalText([]byte(data)); err != nil {
-			log.WithError(err).Fatal("unmarshalling data")
-		}
+	Long: `lora-coverage decode will decode the data from LoRaWAN payloads with the configured keys.
 
-		var nwkSKey lorawan.AES128Key
-		if err := nwkSKey.UnmarshalText([]byte(viper.GetString("lora.nwkskey"))); err != nil {
-			log.WithError(err).Fatal("creating network session key")
-		}
+This command takes the payloads (PHYPayload) as arguments, or one per line from --file
+or, without arguments, from standard input. They are base64 or hex strings, with --input
+auto hex strings are recognized by their characters.
 
-		ok, err := phy.ValidateMIC(nwkSKey)
-		if err != nil {
-			log.WithError(err).Fatal("validating mic")
-		}
-		if !ok {
-			log.Fatal("invalid mic")
-		}
+The keys of a device are taken from the key store in the config file, they can be
+overridden for all payloads with the key flags. A payload that can not be decoded is
+reported and the others are still decoded.
 
-		phyJSON, err := json.MarshalIndent(phy, "", "  ")
-		if err != nil {
-			log.WithError(err).Fatal("marshalling json")
+With --output json every payload is printed as one json object with its frame header,
+MAC commands, decrypted payload and the location and power in it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		inputs := args
+		if len(inputs) == 0 || decodeFile != "" {
+			lines, err := readPayloads(decodeFile)
+			if err != nil {
+				log.WithError(err).Fatal("reading payloads")
+			}
+			inputs = append(inputs, lines...)
 		}
 
-		fmt.Printf("LoRaWAN Packet:\n%s\n", phyJSON)
+		encoder := json.NewEncoder(os.Stdout)
+		failed := 0
 
-		var appSKey lorawan.AES128Key
-		if err := appSKey.UnmarshalText([]byte(viper.GetString("lora.appskey"))); err != nil {
-			log.WithError(err).Fatal("creating app session key")
-		}
+		for i, input := range inputs {
+			frame := decodeFrame(input)
+			if frame.Error != "" {
+				failed++
+				log.WithField("data", input).WithField("error", frame.Error).Error("decoding")
+			}
 
-		if err := phy.DecryptFRMPayload(appSKey); err != nil {
-			log.WithError(err).Fatal("decrypting payload")
+			if decodeOutput == "json" {
+				if err := encoder.Encode(frame); err != nil {
+					log.WithError(err).Fatal("marshalling json")
+				}
+			} else {
+				if i > 0 {
+					fmt.Println()
+				}
+				frame.print(os.Stdout)
+			}
 		}
 
-		macPL, ok := phy.MACPayload.(*lorawan.MACPayload)
-		if !ok {
-			log.Fatal("*MACPayload expected")
+		if failed > 0 {
+			log.WithField("failed", failed).WithField("payloads", len(inputs)).Error("not all payloads could be decoded")
+			os.Exit(1)
 		}
-
-		pl, ok := macPL.FRMPayload[0].(*lorawan.DataPayload)
-		if !ok {
-			log.Fatal("*DataPayload expected")
-		}
-
-		fmt.Printf("Payload: %X\n", pl.Bytes)
 	},
 }
 
@@ -102,4 +113,208 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// decodeCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	decodeCmd.Flags().StringVarP(&decodeFile, "file", "f", "", "file with one payload per line (- for standard input)")
+	decodeCmd.Flags().StringVar(&decodeInput, "input", "auto", "encoding of the payloads: auto, base64 or hex")
+	decodeCmd.Flags().StringVarP(&decodeOutput, "output", "o", "text", "output format: text or json")
+	decodeKeys = addKeyFlags(decodeCmd)
+}
+
+// readPayloads reads one payload per line, empty lines and lines starting with # are
+// skipped.
+func readPayloads(fileName string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if fileName != "" && fileName != "-" {
+		file, err := os.Open(fileName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error opening %s", fileName)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	var payloads []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		payloads = append(payloads, line)
+	}
+
+	return payloads, errors.Wrap(scanner.Err(), "error reading payloads")
+}
+
+// parsePHYPayload decodes a base64 or hex payload.
+func parsePHYPayload(input string) ([]byte, error) {
+	switch decodeInput {
+	case "hex":
+		return hex.DecodeString(input)
+	case "base64":
+		return base64.StdEncoding.DecodeString(input)
+	case "auto":
+		if hexPayload.MatchString(input) {
+			return hex.DecodeString(input)
+		}
+		return base64.StdEncoding.DecodeString(input)
+	}
+
+	return nil, errors.Errorf("unknown input encoding: %s", decodeInput)
+}
+
+type decodedFCtrl struct {
+	ADR       bool  `json:"adr"`
+	ADRACKReq bool  `json:"adrAckReq"`
+	ACK       bool  `json:"ack"`
+	FPending  bool  `json:"fPending"`
+	FOptsLen  uint8 `json:"fOptsLen"`
+}
+
+type decodedMACCommand struct {
+	CID          uint8  `json:"cid"`
+	Name         string `json:"name"`
+	Payload      string `json:"payload,omitempty"`
+	Margin       *int   `json:"margin,omitempty"`
+	GatewayCount *int   `json:"gatewayCount,omitempty"`
+	Battery      *int   `json:"battery,omitempty"`
+}
+
+// decodedFrame is the result of decoding one payload.
+type decodedFrame struct {
+	Input       string              `json:"input"`
+	MType       string              `json:"mType,omitempty"`
+	DevAddr     string              `json:"devAddr,omitempty"`
+	FCtrl       *decodedFCtrl       `json:"fCtrl,omitempty"`
+	FCnt        *uint32             `json:"fCnt,omitempty"`
+	FPort       *uint8              `json:"fPort,omitempty"`
+	FOpts       string              `json:"fOpts,omitempty"`
+	MACCommands []decodedMACCommand `json:"macCommands,omitempty"`
+	MIC         string              `json:"mic,omitempty"`
+	MICValid    bool                `json:"micValid"`
+	Payload     string              `json:"payload,omitempty"`
+	Latitude    *float64            `json:"latitude,omitempty"`
+	Longitude   *float64            `json:"longitude,omitempty"`
+	Power       *int8               `json:"power,omitempty"`
+	Error       string              `json:"error,omitempty"`
+}
+
+// decodeFrame decodes a payload, the error of the frame is set when it could not be
+// decoded completely.
+func decodeFrame(input string) *decodedFrame {
+	frame := &decodedFrame{Input: input}
+
+	phy, err := parsePHYPayload(input)
+	if err != nil {
+		frame.Error = errors.Wrap(err, "invalid payload encoding").Error()
+		return frame
+	}
+
+	packet := &model.RxPacket{Crc: 1, Size: uint16(len(phy)), Data: base64.StdEncoding.EncodeToString(phy)}
+
+	header, err := packet.FrameHeader()
+	if err != nil {
+		frame.Error = err.Error()
+		return frame
+	}
+
+	var c model.Coverage
+	c.SetFrameHeader(header)
+	frame.DevAddr = header.DevAddr
+	frame.setHeader(&c)
+
+	packet.Plan = configPlan()
+	packet.Keys, err = decodeKeys.deviceKeys(header.DevAddr)
+	if err != nil {
+		frame.Error = err.Error()
+		return frame
+	}
+
+	err = c.FromRxPacket(packet)
+	switch err {
+	case nil, model.InvalidPayloadError:
+	case model.InvalidFramePayloadError:
+		frame.MICValid = true
+		frame.Error = "frame has no application payload"
+		return frame
+	default:
+		frame.Error = err.Error()
+		return frame
+	}
+
+	frame.setHeader(&c)
+	frame.MICValid = true
+	frame.Payload = strings.ToUpper(c.Payload)
+
+	if c.LocationSource != "" {
+		frame.Latitude, frame.Longitude = &c.Latitude, &c.Longitude
+	}
+	if c.Power != model.UnknownPower {
+		frame.Power = &c.Power
+	}
+	if err == model.InvalidPayloadError {
+		frame.Error = "invalid payload (no location data and/or power)"
+	}
+
+	return frame
+}
+
+func (f *decodedFrame) setHeader(c *model.Coverage) {
+	f.MType = c.MType
+	f.FCtrl = &decodedFCtrl{
+		ADR:       c.FCtrl.ADR,
+		ADRACKReq: c.FCtrl.ADRACKReq,
+		ACK:       c.FCtrl.ACK,
+		FPending:  c.FCtrl.FPending,
+		FOptsLen:  c.FCtrl.FOptsLen,
+	}
+	f.FCnt = c.FCnt
+	f.FPort = c.FPort
+	f.FOpts = c.FOpts
+	f.MIC = c.MIC
+
+	f.MACCommands = nil
+	for _, command := range c.MACCommands {
+		f.MACCommands = append(f.MACCommands, decodedMACCommand{
+			CID:          command.CID,
+			Name:         command.Name,
+			Payload:      hex.EncodeToString(command.Payload),
+			Margin:       command.Margin,
+			GatewayCount: command.GatewayCount,
+			Battery:      command.Battery,
+		})
+	}
+}
+
+func (f *decodedFrame) print(w io.Writer) {
+	fmt.Fprintf(w, "Frame: %s\n", f.Input)
+	if f.MType != "" {
+		fmt.Fprintf(w, "MType: %s\nDevAddr: %s\n", f.MType, f.DevAddr)
+		fmt.Fprintf(w, "FCtrl: ADR=%t ADRACKReq=%t ACK=%t FPending=%t FOptsLen=%d\n", f.FCtrl.ADR, f.FCtrl.ADRACKReq,
+			f.FCtrl.ACK, f.FCtrl.FPending, f.FCtrl.FOptsLen)
+		fmt.Fprintf(w, "FCnt: %d\n", *f.FCnt)
+		if f.FPort != nil {
+			fmt.Fprintf(w, "FPort: %d\n", *f.FPort)
+		}
+		for _, command := range f.MACCommands {
+			fmt.Fprintf(w, "MAC command: %s", command.Name)
+			if command.Payload != "" {
+				fmt.Fprintf(w, " (%s)", command.Payload)
+			}
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "MIC: %s (valid: %t)\n", f.MIC, f.MICValid)
+	}
+	if f.Payload != "" {
+		fmt.Fprintf(w, "Payload: %s\n", f.Payload)
+	}
+	if f.Latitude != nil {
+		fmt.Fprintf(w, "Location: %.4f, %.4f\n", *f.Latitude, *f.Longitude)
+	}
+	if f.Power != nil {
+		fmt.Fprintf(w, "Power: %d dBm\n", *f.Power)
+	}
+	if f.Error != "" {
+		fmt.Fprintf(w, "Error: %s\n", f.Error)
+	}
 }
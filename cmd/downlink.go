@@ -0,0 +1,265 @@
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-coverage/db"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/paulmach/go.geojson"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	downlinkWindow   time.Duration
+	downlinkOutput   string
+	downlinkCallback string
+)
+
+// ack states of a confirmed uplink
+const (
+	ackReceived = "acked"
+	ackMissing  = "unacked"
+	ackUnknown  = "unknown"
+)
+
+// downlinkCmd represents the downlink command
+var downlinkCmd = &cobra.Command{
+	Use:   "downlink [device]",
+	Short: "Show which confirmed uplinks were acknowledged",
+	Long: `lora-coverage downlink shows the downlink coverage: which confirmed uplinks were
+acknowledged.
+
+This command takes an optional argument:
+	- device address (in hex) [eg. 26011b2c], all devices when omitted
+A confirmed uplink is acked when the next uplink of the device has the ACK bit set, so
+the device received the downlink, unacked when it has not and unknown when there is no
+next uplink. The downlinks (PULL_RESP: TXPK) sent to the device within --window after
+the uplink are shown with their TX_ACK, which tells whether the gateway could send them.
+
+With --output the located confirmed uplinks are written to a geo jsonp file with the
+properties ack (acked, unacked or unknown), downlink, tx_ack and gateways.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		database, err := db.Open(viper.GetString("database.dbfile"))
+		if err != nil {
+			log.WithError(err).WithField("database", viper.GetString("database.dbfile")).Fatal("connecting to database")
+		}
+		defer database.Disconnect()
+
+		if err := database.Init(); err != nil {
+			log.WithError(err).Fatal("initializing database")
+		}
+
+		dbModel := model.New(database, modelConfig())
+
+		var device string
+		if len(args) > 0 {
+			device = strings.ToLower(args[0])
+		}
+
+		rows, err := dbModel.GetCoverageRows("", "")
+		if err != nil {
+			log.WithError(err).Fatal("getting coverage rows")
+		}
+
+		downlinks, err := dbModel.GetDownlinks(device)
+		if err != nil {
+			log.WithError(err).Fatal("getting downlinks")
+		}
+
+		results := matchAcks(groupFrames(rows, device), downlinks, downlinkWindow)
+		printAcks(os.Stdout, results)
+
+		if downlinkOutput != "" {
+			featureCollection := geojson.NewFeatureCollection()
+			for _, point := range ackPoints(results) {
+				featureCollection.AddFeature(point)
+			}
+
+			rawJson, err := featureCollection.MarshalJSON()
+			if err != nil {
+				log.WithError(err).Fatal("marshalling json")
+			}
+
+			jsonP := fmt.Sprintf("%s(%s);", downlinkCallback, rawJson)
+			if err := ioutil.WriteFile(downlinkOutput, []byte(jsonP), 0644); err != nil {
+				log.WithError(err).WithField("output", downlinkOutput).Fatal("writing geo json file")
+			}
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(downlinkCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// downlinkCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// downlinkCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	downlinkCmd.Flags().DurationVar(&downlinkWindow, "window", 3*time.Second, "time after an uplink in which its downlink is sent")
+	downlinkCmd.Flags().StringVarP(&downlinkOutput, "output", "o", "", "name of the geo jsonp output file")
+	downlinkCmd.Flags().StringVarP(&downlinkCallback, "callback", "c", "eqfeed_callback", "name of the callback function")
+}
+
+// ackResult is a confirmed uplink with the downlink that acknowledged it.
+type ackResult struct {
+	uplink   *frame
+	ack      string
+	downlink *model.Downlink
+}
+
+// matchAcks looks up the next uplink and the downlink of every confirmed uplink, the
+// frames have to be sorted by device and time (see groupFrames).
+func matchAcks(frames []*frame, downlinks []*model.Downlink, window time.Duration) []*ackResult {
+	byDevice := make(map[string][]*model.Downlink)
+	for _, d := range downlinks {
+		if d.ACK {
+			byDevice[d.DeviceAddr] = append(byDevice[d.DeviceAddr], d)
+		}
+	}
+
+	var results []*ackResult
+
+	for i, f := range frames {
+		if f.mType != model.MTypeConfirmedDataUp {
+			continue
+		}
+
+		result := &ackResult{uplink: f, ack: ackUnknown}
+
+		if i+1 < len(frames) && frames[i+1].device == f.device {
+			if frames[i+1].ack {
+				result.ack = ackReceived
+			} else {
+				result.ack = ackMissing
+			}
+		}
+
+		for _, d := range byDevice[f.device] {
+			if !d.Time.Before(f.time) && d.Time.Sub(f.time) <= window {
+				result.downlink = d
+				break
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+func printAcks(w io.Writer, results []*ackResult) {
+	type summary struct {
+		confirmed, acked, unacked, unknown, downlinks int
+	}
+
+	summaries := make(map[string]*summary)
+	var devices []string
+
+	for _, r := range results {
+		f := r.uplink
+
+		s, ok := summaries[f.device]
+		if !ok {
+			s = &summary{}
+			summaries[f.device] = s
+			devices = append(devices, f.device)
+		}
+
+		s.confirmed++
+		switch r.ack {
+		case ackReceived:
+			s.acked++
+		case ackMissing:
+			s.unacked++
+		default:
+			s.unknown++
+		}
+
+		fCnt := "-"
+		if f.fCnt != nil {
+			fCnt = fmt.Sprint(*f.fCnt)
+		}
+
+		downlink := "no downlink"
+		if r.downlink != nil {
+			s.downlinks++
+			txAck := r.downlink.TxAck
+			if txAck == "" {
+				txAck = "no tx ack"
+			}
+			downlink = fmt.Sprintf("downlink %s via %s (%s)", r.downlink.Time.Sub(f.time).Round(time.Millisecond),
+				r.downlink.GatewayMac, txAck)
+		}
+
+		fmt.Fprintf(w, "%s %s fcnt %s: %d gateways, %s, %s", f.device, f.time.UTC().Format(time.RFC3339), fCnt,
+			f.gateways, r.ack, downlink)
+		if f.latitude != 0 || f.longitude != 0 {
+			fmt.Fprintf(w, " at %.4f,%.4f", f.latitude, f.longitude)
+		}
+		fmt.Fprintln(w)
+	}
+
+	for _, device := range devices {
+		s := summaries[device]
+		fmt.Fprintf(w, "device %s: %d confirmed uplinks, %d acked, %d unacked, %d unknown, %d downlinks\n",
+			device, s.confirmed, s.acked, s.unacked, s.unknown, s.downlinks)
+	}
+}
+
+// ackPoints returns the located confirmed uplinks as points.
+func ackPoints(results []*ackResult) []*geojson.Feature {
+	var points []*geojson.Feature
+
+	for _, r := range results {
+		f := r.uplink
+		if f.latitude == 0 && f.longitude == 0 {
+			continue
+		}
+
+		feature := geojson.NewPointFeature([]float64{f.latitude, f.longitude})
+		feature.SetProperty("device", f.device)
+		feature.SetProperty("ack", r.ack)
+		feature.SetProperty("gateways", f.gateways)
+		feature.SetProperty("downlink", r.downlink != nil)
+		if r.downlink != nil && r.downlink.TxAck != "" {
+			feature.SetProperty("tx_ack", r.downlink.TxAck)
+		}
+
+		points = append(points, feature)
+	}
+
+	return points
+}
@@ -0,0 +1,129 @@
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bullettime/lora-coverage/model"
+)
+
+// ackFrame returns an uplink of the device, offset from the fixture time.
+func ackFrame(device string, offset time.Duration, fCnt uint32, confirmed, ack bool) *frame {
+	mType := model.MTypeUnconfirmedDataUp
+	if confirmed {
+		mType = model.MTypeConfirmedDataUp
+	}
+	return &frame{device: device, time: fixtureTime.Add(offset), mType: mType, fCnt: &fCnt, ack: ack, gateways: 1}
+}
+
+func TestMatchAcks(t *testing.T) {
+	gateway := model.MacAddress{0xaa, 0x55, 0x5a, 0, 0, 0, 1, 1}
+	downlink := func(device string, offset time.Duration, ack bool) *model.Downlink {
+		return &model.Downlink{GatewayMac: gateway, DeviceAddr: device, Time: fixtureTime.Add(offset), ACK: ack,
+			TxAck: "NONE"}
+	}
+
+	tests := []struct {
+		name      string
+		frames    []*frame
+		downlinks []*model.Downlink
+		acks      []string
+		matched   []bool
+		output    []string
+	}{
+		{
+			name: "acked",
+			frames: []*frame{ackFrame("26011b2c", 0, 10, true, false),
+				ackFrame("26011b2c", time.Minute, 11, false, true)},
+			downlinks: []*model.Downlink{downlink("26011b2c", time.Second, true)},
+			acks:      []string{ackReceived},
+			matched:   []bool{true},
+			output: []string{"26011b2c 2018-04-05T22:00:00Z fcnt 10: 1 gateways, acked, downlink 1s via aa555a0000000101 (NONE)\n",
+				"device 26011b2c: 1 confirmed uplinks, 1 acked, 0 unacked, 0 unknown, 1 downlinks\n"},
+		},
+		{
+			name: "unacked and unknown",
+			frames: []*frame{ackFrame("26011b2c", 0, 10, true, false),
+				ackFrame("26011b2c", time.Minute, 11, true, false)},
+			downlinks: []*model.Downlink{downlink("26011b2c", 5*time.Second, true),
+				downlink("26011b2d", time.Minute+time.Second, true), downlink("26011b2c", time.Minute+time.Second, false)},
+			acks:    []string{ackMissing, ackUnknown},
+			matched: []bool{false, false},
+			output:  []string{"device 26011b2c: 2 confirmed uplinks, 0 acked, 1 unacked, 1 unknown, 0 downlinks\n"},
+		},
+		{
+			name: "fcnt rollover",
+			frames: []*frame{ackFrame("26011b2c", 0, 0xffff, true, false),
+				ackFrame("26011b2c", time.Minute, 0x10000, true, true),
+				ackFrame("26011b2c", 2*time.Minute, 0x10001, false, true)},
+			downlinks: []*model.Downlink{downlink("26011b2c", time.Second, true),
+				downlink("26011b2c", time.Minute+time.Second, true)},
+			acks:    []string{ackReceived, ackReceived},
+			matched: []bool{true, true},
+			output: []string{"fcnt 65535: 1 gateways, acked", "fcnt 65536: 1 gateways, acked",
+				"device 26011b2c: 2 confirmed uplinks, 2 acked, 0 unacked, 0 unknown, 2 downlinks\n"},
+		},
+		{
+			name: "16 bit fcnt rollover",
+			frames: []*frame{ackFrame("26011b2c", 0, 0xffff, true, false),
+				ackFrame("26011b2c", time.Minute, 0, false, true)},
+			downlinks: []*model.Downlink{downlink("26011b2c", time.Second, true)},
+			acks:      []string{ackReceived},
+			matched:   []bool{true},
+			output:    []string{"fcnt 65535: 1 gateways, acked"},
+		},
+		{
+			name: "next uplink of another device",
+			frames: []*frame{ackFrame("26011b2c", 0, 10, true, false),
+				ackFrame("26011b2d", time.Minute, 11, false, true)},
+			acks:    []string{ackUnknown},
+			matched: []bool{false},
+			output:  []string{"fcnt 10: 1 gateways, unknown, no downlink\n"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			results := matchAcks(test.frames, test.downlinks, 3*time.Second)
+			if len(results) != len(test.acks) {
+				t.Fatalf("expected %d confirmed uplinks, got %d", len(test.acks), len(results))
+			}
+			for i, r := range results {
+				if r.ack != test.acks[i] || (r.downlink != nil) != test.matched[i] {
+					t.Errorf("uplink %d: expected %s and downlink %v, got %s and %v", i, test.acks[i],
+						test.matched[i], r.ack, r.downlink)
+				}
+			}
+
+			var buf bytes.Buffer
+			printAcks(&buf, results)
+			for _, line := range test.output {
+				if !strings.Contains(buf.String(), line) {
+					t.Errorf("expected %q in the output:\n%s", line, buf.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-coverage/db"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var duplicatesTolerance time.Duration
+
+// duplicatesCmd represents the duplicates command
+var duplicatesCmd = &cobra.Command{
+	Use:   "duplicates [device]",
+	Short: "Show duplicated and replayed frames",
+	Long: `lora-coverage duplicates shows the frames that are in the database more than once.
+
+This command takes an optional argument:
+	- device address (in hex) [eg. 26011b2c], all devices when omitted
+Frames are identified by their device, frame counter and MIC. A frame that a gateway
+received more than once within --tolerance is a duplicate, eg. a log that was imported
+twice with a skewed clock. New duplicates are rejected when they are added, this shows
+the ones that are already in the database. A frame that is received again after more
+than --tolerance is a replay.
+
+The tolerance defaults to database.duplicatetolerance of the config file (5m).`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		database, err := db.Open(viper.GetString("database.dbfile"))
+		if err != nil {
+			log.WithError(err).WithField("database", viper.GetString("database.dbfile")).Fatal("connecting to database")
+		}
+		defer database.Disconnect()
+
+		if err := database.Init(); err != nil {
+			log.WithError(err).Fatal("initializing database")
+		}
+
+		dbModel := model.New(database, modelConfig())
+
+		rows, err := dbModel.GetCoverageRows("", "")
+		if err != nil {
+			log.WithError(err).Fatal("getting coverage rows")
+		}
+
+		var device string
+		if len(args) > 0 {
+			device = strings.ToLower(args[0])
+		}
+
+		duplicates, replays := printDuplicates(os.Stdout, rows, device, duplicateTolerance(cmd))
+		if duplicates > 0 || replays > 0 {
+			log.WithFields(log.Fields{"duplicates": duplicates, "replays": replays}).Warn("frames found more than once")
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(duplicatesCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// duplicatesCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// duplicatesCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	duplicatesCmd.Flags().DurationVar(&duplicatesTolerance, "tolerance", 5*time.Minute, "maximum clock skew between copies of a frame")
+}
+
+// duplicateTolerance returns --tolerance when it was given, otherwise the tolerance of
+// the configuration that is also used when rows are added.
+func duplicateTolerance(cmd *cobra.Command) time.Duration {
+	if cmd.Flags().Changed("tolerance") {
+		return duplicatesTolerance
+	}
+	return viper.GetDuration("database.duplicatetolerance")
+}
+
+// printDuplicates prints the duplicated rows and the replayed frames and returns how
+// many of them were found. Rows without frame counter or MIC are skipped.
+func printDuplicates(w io.Writer, rows []*model.Coverage, device string, tolerance time.Duration) (int, int) {
+	frames := make(map[string][]*model.Coverage)
+	var keys []string
+
+	for _, row := range rows {
+		if row.FCnt == nil || row.MIC == "" || (device != "" && row.DeviceAddr.String() != device) {
+			continue
+		}
+
+		key := fmt.Sprintf("%s fcnt %d mic %s", row.DeviceAddr, *row.FCnt, row.MIC)
+		if _, ok := frames[key]; !ok {
+			keys = append(keys, key)
+		}
+		frames[key] = append(frames[key], row)
+	}
+
+	sort.Strings(keys)
+
+	duplicates, replays := 0, 0
+
+	for _, key := range keys {
+		frame := frames[key]
+		sort.SliceStable(frame, func(i, j int) bool {
+			return time.Time(frame[i].Time).Before(time.Time(frame[j].Time))
+		})
+
+		// receptions more than the tolerance apart are different transmissions
+		var transmissions [][]*model.Coverage
+		for i, row := range frame {
+			if i == 0 || time.Time(row.Time).Sub(time.Time(frame[i-1].Time)) > tolerance {
+				transmissions = append(transmissions, nil)
+			}
+			transmissions[len(transmissions)-1] = append(transmissions[len(transmissions)-1], row)
+		}
+
+		if len(transmissions) > 1 {
+			replays++
+			var times []string
+			for _, t := range transmissions {
+				times = append(times, fmt.Sprintf("%s (%d rows)", formatTime(t[0].Time), len(t)))
+			}
+			fmt.Fprintf(w, "%s: replayed, received at %s\n", key, strings.Join(times, ", "))
+		}
+
+		for _, t := range transmissions {
+			byGateway := make(map[string][]*model.Coverage)
+			var gateways []string
+			for _, row := range t {
+				gateway := row.GatewayMac.String()
+				if _, ok := byGateway[gateway]; !ok {
+					gateways = append(gateways, gateway)
+				}
+				byGateway[gateway] = append(byGateway[gateway], row)
+			}
+
+			for _, gateway := range gateways {
+				copies := byGateway[gateway]
+				if len(copies) < 2 {
+					continue
+				}
+
+				duplicates += len(copies) - 1
+				skew := time.Time(copies[len(copies)-1].Time).Sub(time.Time(copies[0].Time))
+				fmt.Fprintf(w, "%s: gateway %s has %d copies from %s, clock skew %s\n", key, gateway, len(copies),
+					formatTime(copies[0].Time), skew)
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "%d frames, %d duplicated rows, %d replayed frames\n", len(keys), duplicates, replays)
+
+	return duplicates, replays
+}
+
+func formatTime(t model.CompactTime) string {
+	return time.Time(t).UTC().Format(time.RFC3339)
+}
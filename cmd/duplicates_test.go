@@ -0,0 +1,160 @@
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brocaar/lorawan"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+// duplicateRow returns an uplink of frame 7 of the fixture device.
+func duplicateRow(gateway byte, offset time.Duration, mic string) *model.Coverage {
+	fCnt := uint32(7)
+	return &model.Coverage{GatewayMac: model.MacAddress{0xaa, 0x55, 0x5a, 0, 0, 0, 1, gateway},
+		DeviceAddr: lorawan.DevAddr{0x26, 0x01, 0x1b, 0x2c}, FCnt: &fCnt, MIC: mic, Region: "EU868",
+		Frequency: 868.1, DataRate: model.DataRate{LoRa: "SF7BW125"},
+		Time: model.CompactTime(fixtureTime.Add(offset))}
+}
+
+func TestPrintDuplicates(t *testing.T) {
+	tests := []struct {
+		name       string
+		rows       []*model.Coverage
+		device     string
+		duplicates int
+		replays    int
+		output     []string
+	}{
+		{
+			name:       "copy inside the tolerance",
+			rows:       []*model.Coverage{duplicateRow(1, 0, "01020304"), duplicateRow(1, 2*time.Minute, "01020304")},
+			duplicates: 1,
+			output:     []string{"26011b2c fcnt 7 mic 01020304: gateway aa555a0000000101 has 2 copies from 2018-04-05T22:00:00Z, clock skew 2m0s"},
+		},
+		{
+			name:    "replay outside the tolerance",
+			rows:    []*model.Coverage{duplicateRow(1, 0, "01020304"), duplicateRow(2, time.Second, "01020304"), duplicateRow(1, time.Hour, "01020304")},
+			replays: 1,
+			output:  []string{"26011b2c fcnt 7 mic 01020304: replayed, received at 2018-04-05T22:00:00Z (2 rows), 2018-04-05T23:00:00Z (1 rows)"},
+		},
+		{
+			name:   "other gateway",
+			rows:   []*model.Coverage{duplicateRow(1, 0, "01020304"), duplicateRow(2, time.Minute, "01020304")},
+			output: []string{"1 frames, 0 duplicated rows, 0 replayed frames"},
+		},
+		{
+			name:   "no mic",
+			rows:   []*model.Coverage{duplicateRow(1, 0, ""), duplicateRow(1, time.Minute, "")},
+			output: []string{"0 frames, 0 duplicated rows, 0 replayed frames"},
+		},
+		{
+			name:   "other device",
+			rows:   []*model.Coverage{duplicateRow(1, 0, "01020304"), duplicateRow(1, time.Minute, "01020304")},
+			device: "26011b2d",
+			output: []string{"0 frames, 0 duplicated rows, 0 replayed frames"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			duplicates, replays := printDuplicates(&buf, test.rows, test.device, 5*time.Minute)
+			if duplicates != test.duplicates || replays != test.replays {
+				t.Errorf("expected %d duplicates and %d replays, got %d and %d", test.duplicates, test.replays,
+					duplicates, replays)
+			}
+			for _, line := range test.output {
+				if !strings.Contains(buf.String(), line+"\n") {
+					t.Errorf("expected %q in the output:\n%s", line, buf.String())
+				}
+			}
+		})
+	}
+}
+
+func TestAddDuplicates(t *testing.T) {
+	_, dbModel, tearDown := e2eSetup(t)
+	defer tearDown()
+
+	tests := []struct {
+		name      string
+		row       *model.Coverage
+		duplicate bool
+	}{
+		{"first", duplicateRow(1, 0, "01020304"), false},
+		{"copy inside the tolerance", duplicateRow(1, 3*time.Minute, "01020304"), true},
+		{"other gateway", duplicateRow(2, 3*time.Minute, "01020304"), false},
+		{"replay outside the tolerance", duplicateRow(1, time.Hour, "01020304"), false},
+		{"no mic", duplicateRow(1, time.Minute, ""), false},
+	}
+
+	for _, test := range tests {
+		err := dbModel.AddCoverageRow(test.row)
+		if duplicate := errors.Cause(err) == model.DuplicateRowError; duplicate != test.duplicate {
+			t.Errorf("%s: expected duplicate %v, got %v", test.name, test.duplicate, err)
+		} else if !duplicate && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+	}
+
+	rows, err := dbModel.GetCoverageRows("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("expected 4 rows, got %d", len(rows))
+	}
+
+	var buf bytes.Buffer
+	tolerance := viper.GetDuration("database.duplicatetolerance")
+	if duplicates, replays := printDuplicates(&buf, rows, "", tolerance); duplicates != 0 || replays != 1 {
+		t.Errorf("expected only the replay, got %d duplicates and %d replays:\n%s", duplicates, replays, buf.String())
+	}
+}
+
+func TestDuplicateTolerance(t *testing.T) {
+	defer func(tolerance time.Duration) { viper.Set("database.duplicatetolerance", tolerance) }(viper.GetDuration("database.duplicatetolerance"))
+	viper.Set("database.duplicatetolerance", 10*time.Minute)
+
+	flag := duplicatesCmd.Flags().Lookup("tolerance")
+	defer func() {
+		flag.Value.Set(flag.DefValue)
+		flag.Changed = false
+	}()
+
+	if tolerance := duplicateTolerance(duplicatesCmd); tolerance != 10*time.Minute {
+		t.Errorf("expected the tolerance of the configuration, got %s", tolerance)
+	}
+
+	if err := duplicatesCmd.Flags().Set("tolerance", "30s"); err != nil {
+		t.Fatal(err)
+	}
+	if tolerance := duplicateTolerance(duplicatesCmd); tolerance != 30*time.Second {
+		t.Errorf("expected --tolerance to override the configuration, got %s", tolerance)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-coverage/db"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/bullettime/lora-coverage/region"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var dutyCycleWindow time.Duration
+
+// dutycycleCmd represents the dutycycle command
+var dutycycleCmd = &cobra.Command{
+	Use:   "dutycycle [device]",
+	Short: "Show the airtime and duty-cycle usage of the devices",
+	Long: `lora-coverage dutycycle shows the airtime of every device per duty-cycle sub-band.
+
+This command takes an optional argument:
+	- device address (in hex) [eg. 26011b2c], all devices when omitted
+The duty-cycle is the highest airtime in any sliding window of --window, uplinks received
+by more than one gateway are only counted once. Sub-bands where the limit of the region
+(eg. 1% in most EU868 sub-bands) was exceeded are flagged as VIOLATION.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if dutyCycleWindow <= 0 {
+			log.WithField("window", dutyCycleWindow).Fatal("window must be positive")
+		}
+
+		plan, err := region.Get(viper.GetString("lora.region"))
+		if err != nil {
+			log.WithError(err).Fatal("getting region")
+		}
+
+		database, err := db.Open(viper.GetString("database.dbfile"))
+		if err != nil {
+			log.WithError(err).WithField("database", viper.GetString("database.dbfile")).Fatal("connecting to database")
+		}
+		defer database.Disconnect()
+
+		if err := database.Init(); err != nil {
+			log.WithError(err).Fatal("initializing database")
+		}
+
+		dbModel := model.New(database, modelConfig())
+
+		rows, err := dbModel.GetCoverageRows("", "")
+		if err != nil {
+			log.WithError(err).Fatal("getting coverage rows")
+		}
+
+		var device string
+		if len(args) > 0 {
+			device = strings.ToLower(args[0])
+		}
+
+		violations := printDutyCycle(os.Stdout, groupFrames(rows, device), plan, dutyCycleWindow)
+		if violations > 0 {
+			log.WithField("violations", violations).Warn("duty-cycle limit exceeded")
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(dutycycleCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// dutycycleCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// dutycycleCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	dutycycleCmd.Flags().DurationVar(&dutyCycleWindow, "window", time.Hour, "length of the sliding window")
+}
+
+// bandUsage is the airtime of a device in a sub-band.
+type bandUsage struct {
+	device  string
+	band    region.SubBand
+	limit   bool
+	times   []time.Time
+	airtime []time.Duration
+	total   time.Duration
+}
+
+// maxWindow returns the highest airtime in a window of the given length and the start
+// of that window.
+func (u *bandUsage) maxWindow(window time.Duration) (time.Duration, time.Time) {
+	var max, sum time.Duration
+	var start time.Time
+
+	first := 0
+	for last := range u.times {
+		sum += u.airtime[last]
+		for first < last && u.times[last].Sub(u.times[first]) >= window {
+			sum -= u.airtime[first]
+			first++
+		}
+		if sum > max {
+			max, start = sum, u.times[first]
+		}
+	}
+
+	return max, start
+}
+
+// printDutyCycle prints the usage per device and sub-band and returns the number of
+// violations.
+func printDutyCycle(w io.Writer, frames []*frame, plan *region.Plan, window time.Duration) int {
+	usages := make(map[string]*bandUsage)
+	var keys []string
+
+	for _, f := range frames {
+		airtime := f.airtime
+		if airtime == 0 {
+			dr, err := f.dataRate.RegionDataRate()
+			if err != nil {
+				continue
+			}
+			airtime = f.timeOnAir(dr)
+		}
+
+		band, limit := plan.SubBand(int(math.Round(f.frequency * 1e6)))
+		if !limit {
+			band = region.SubBand{Name: "all"}
+		}
+
+		key := f.device + " " + band.Name
+		usage, ok := usages[key]
+		if !ok {
+			usage = &bandUsage{device: f.device, band: band, limit: limit}
+			usages[key] = usage
+			keys = append(keys, key)
+		}
+
+		usage.times = append(usage.times, f.time)
+		usage.airtime = append(usage.airtime, airtime)
+		usage.total += airtime
+	}
+
+	sort.Strings(keys)
+
+	violations := 0
+	for _, key := range keys {
+		usage := usages[key]
+		max, start := usage.maxWindow(window)
+		dutyCycle := float64(max) / float64(window)
+
+		fmt.Fprintf(w, "device %s sub-band %s", usage.device, usage.band.Name)
+		if usage.limit {
+			fmt.Fprintf(w, " (%.3f-%.3f MHz, limit %g%%)", float64(usage.band.MinFrequency)/1e6,
+				float64(usage.band.MaxFrequency)/1e6, usage.band.DutyCycle*100)
+		}
+		fmt.Fprintf(w, ": %d uplinks, airtime %s, max %s (%.3f%%) in %s from %s", len(usage.times),
+			usage.total.Round(time.Millisecond), max.Round(time.Millisecond), dutyCycle*100, window,
+			start.UTC().Format(time.RFC3339))
+
+		if usage.limit && dutyCycle > usage.band.DutyCycle {
+			violations++
+			fmt.Fprint(w, " VIOLATION")
+		}
+		fmt.Fprintln(w)
+	}
+
+	return violations
+}
@@ -0,0 +1,96 @@
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/bullettime/lora-coverage/region"
+)
+
+// dutyCycleFrames returns uplinks of 200 ms on 868.1 MHz at the given offsets.
+func dutyCycleFrames(device string, offsets ...time.Duration) []*frame {
+	var frames []*frame
+	for _, offset := range offsets {
+		frames = append(frames, &frame{device: device, time: fixtureTime.Add(offset),
+			dataRate: model.DataRate{LoRa: "SF9BW125"}, frequency: 868.1, airtime: 200 * time.Millisecond})
+	}
+	return frames
+}
+
+func TestPrintDutyCycle(t *testing.T) {
+	plan, err := region.Get("EU868")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		frames     []*frame
+		violations int
+		output     []string
+	}{
+		{
+			name:   "below the limit",
+			frames: dutyCycleFrames("26011b2c", 0, 10*time.Second, 20*time.Second),
+			output: []string{"device 26011b2c sub-band g1 (868.000-868.600 MHz, limit 1%): 3 uplinks, airtime 600ms, " +
+				"max 600ms (1.000%) in 1m0s from 2018-04-05T22:00:00Z\n"},
+		},
+		{
+			name:       "window violation",
+			frames:     dutyCycleFrames("26011b2c", 0, 10*time.Second, 20*time.Second, 30*time.Second),
+			violations: 1,
+			output: []string{"device 26011b2c sub-band g1 (868.000-868.600 MHz, limit 1%): 4 uplinks, airtime 800ms, " +
+				"max 800ms (1.333%) in 1m0s from 2018-04-05T22:00:00Z VIOLATION\n"},
+		},
+		{
+			name:   "sliding window",
+			frames: dutyCycleFrames("26011b2c", 0, 30*time.Second, time.Minute, 90*time.Second),
+			output: []string{"4 uplinks, airtime 800ms, max 400ms (0.667%) in 1m0s from 2018-04-05T22:00:00Z\n"},
+		},
+		{
+			name: "per device",
+			frames: append(dutyCycleFrames("26011b2c", 0, 10*time.Second),
+				dutyCycleFrames("26011b2d", 0, 10*time.Second, 20*time.Second, 30*time.Second)...),
+			violations: 1,
+			output: []string{"device 26011b2c sub-band g1 (868.000-868.600 MHz, limit 1%): 2 uplinks",
+				"device 26011b2d sub-band g1 (868.000-868.600 MHz, limit 1%): 4 uplinks"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if violations := printDutyCycle(&buf, test.frames, plan, time.Minute); violations != test.violations {
+				t.Errorf("expected %d violations, got %d", test.violations, violations)
+			}
+			for _, line := range test.output {
+				if !strings.Contains(buf.String(), line) {
+					t.Errorf("expected %q in the output:\n%s", line, buf.String())
+				}
+			}
+		})
+	}
+}
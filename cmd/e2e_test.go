@@ -0,0 +1,286 @@
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brocaar/lorawan"
+	"github.com/bullettime/lora-coverage/db"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/spf13/viper"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// fixtureKey is the key of the device in test.json, see make fixture.
+const fixtureKey = "000102030405060708090a0b0c0d0e0f"
+
+var fixtureTime = time.Date(2018, 4, 5, 22, 0, 0, 0, time.UTC)
+
+// e2eSetup points the configuration to a new database in a temporary directory.
+func e2eSetup(t *testing.T) (string, *model.Model, func()) {
+	dir, err := ioutil.TempDir("", "lora-coverage")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	viper.Set("database.dbfile", filepath.Join(dir, "coverage.db"))
+	viper.Set("lora.nwkskey", fixtureKey)
+	viper.Set("lora.appskey", fixtureKey)
+
+	database, err := db.Open(viper.GetString("database.dbfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, model.New(database, modelConfig()), func() {
+		database.Disconnect()
+		os.RemoveAll(dir)
+		viper.Set("database.dbfile", "")
+		viper.Set("lora.nwkskey", "")
+		viper.Set("lora.appskey", "")
+	}
+}
+
+// errorFixture returns the lora-logger lines of the frames that are rejected by add,
+// one for every branch, and a line that can not be parsed.
+func errorFixture(t *testing.T) []string {
+	var key lorawan.AES128Key
+	if err := key.UnmarshalText([]byte(fixtureKey)); err != nil {
+		t.Fatal(err)
+	}
+	keys := &model.DeviceKeys{Version: model.LoRaWAN10, FNwkSIntKey: key, SNwkSIntKey: key, NwkSEncKey: key,
+		AppSKey: key}
+	wrongKeys := *keys
+	wrongKeys.FNwkSIntKey = lorawan.AES128Key{}
+
+	location, err := model.EncodePayload(50.9643, 1.088, 14)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	joinRequest := make([]byte, 23)
+	if _, err := rand.Read(joinRequest[1:]); err != nil {
+		t.Fatal(err)
+	}
+
+	devAddr := lorawan.DevAddr{0x26, 0x01, 0x1b, 0x2d}
+	frames := []struct {
+		phy []byte
+		crc int8
+	}{
+		// crc error
+		{encodeFixture(t, &model.UplinkFrame{DevAddr: devAddr, FCnt: 1, FPort: 1, Payload: location}, keys), -1},
+		// invalid mic
+		{encodeFixture(t, &model.UplinkFrame{DevAddr: devAddr, FCnt: 2, FPort: 1, Payload: location}, &wrongKeys), 1},
+		// invalid mac payload
+		{joinRequest, 1},
+		// invalid frame payload: a LinkCheckReq on port 0
+		{encodeFixture(t, &model.UplinkFrame{DevAddr: devAddr, FCnt: 3, FPort: 0, Payload: []byte{0x02}}, keys), 1},
+		// invalid payload: not a location, the row is stored without it
+		{encodeFixture(t, &model.UplinkFrame{DevAddr: devAddr, FCnt: 4, FPort: 1, Payload: []byte{1, 2, 3}}, keys), 1},
+	}
+
+	var lines []string
+	for i, frame := range frames {
+		packet := model.RxPacket{
+			GatewayMac: model.MacAddress{0xaa, 0x55, 0x5a, 0, 0, 0, 1, 1},
+			Time:       model.CompactTime(fixtureTime.Add(time.Duration(i) * time.Second)),
+			Frequency:  868.1,
+			Crc:        frame.crc,
+			Modulation: "LORA",
+			DataR:      model.DataRate{LoRa: "SF7BW125"},
+			CodingRate: "4/5",
+			RSSI:       -100,
+			SNR:        5,
+			Size:       uint16(len(frame.phy)),
+			Data:       base64.StdEncoding.EncodeToString(frame.phy),
+		}
+
+		line, err := packet.LogLine()
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(line))
+	}
+
+	return append(lines, `{"level":"info","message":"PUSH_DATA: RXPK","fields":{"data":`)
+}
+
+func encodeFixture(t *testing.T, frame *model.UplinkFrame, keys *model.DeviceKeys) []byte {
+	phy, err := model.EncodeUplink(frame, keys, model.MICContext{TxDR: -1, TxCh: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return phy
+}
+
+// countLines returns the number of lines of a lora-logger file and the number of
+// lines with a crc error.
+func countLines(t *testing.T, fileName string) (int, int) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	lines, crcErrors := 0, 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines++
+		if strings.Contains(scanner.Text(), `"crc":-1`) {
+			crcErrors++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return lines, crcErrors
+}
+
+func countRows(t *testing.T, database *sql.DB, table string) int {
+	var count int
+	if err := database.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+// checkGolden compares a file with its golden file in testdata, with -update the
+// golden file is written instead.
+func checkGolden(t *testing.T, fileName, golden string) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden = filepath.Join("testdata", golden)
+	if *update {
+		if err := ioutil.WriteFile(golden, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("%s differs from %s:\n%s", fileName, golden, data)
+	}
+}
+
+func TestAddAndExport(t *testing.T) {
+	dir, dbModel, tearDown := e2eSetup(t)
+	defer tearDown()
+
+	// the simulated drive test and the rejected frames
+	fixture := filepath.Join(dir, "lora-log.json")
+	data, err := ioutil.ReadFile("../test.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, strings.Join(errorFixture(t), "\n")+"\n"...)
+	if err := ioutil.WriteFile(fixture, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	quarantine := filepath.Join(dir, "quarantine.json")
+	tracker, err := newErrorTracker(0, quarantine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addDataFromGatewayLogger(fixture, dbModel, tracker)
+	tracker.close()
+
+	if tracker.count != 1 {
+		t.Errorf("expected 1 unparseable line, got %d", tracker.count)
+	}
+	if data, _ := ioutil.ReadFile(quarantine); !bytes.HasPrefix(data, []byte(`{"level":"info","message":"PUSH_DATA: RXPK","fields":{"data":`)) {
+		t.Errorf("wrong quarantine file: %s", data)
+	}
+
+	database, err := sql.Open("sqlite3", viper.GetString("database.dbfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	lines, crcErrors := countLines(t, "../test.json")
+
+	// every valid frame of the drive test and the frame with an invalid payload
+	if count := countRows(t, database, "coverage"); count != lines-crcErrors+1 {
+		t.Errorf("expected %d coverage rows, got %d", lines-crcErrors+1, count)
+	}
+	var payloadRows int
+	if err := database.QueryRow("SELECT COUNT(*) FROM coverage WHERE payload = '010203' AND lat IS NULL").Scan(&payloadRows); err != nil {
+		t.Fatal(err)
+	}
+	if payloadRows != 1 {
+		t.Errorf("expected the row with an invalid payload without location, got %d", payloadRows)
+	}
+
+	// the rejected frames are only kept as reception or crc error
+	if count := countRows(t, database, "crc_error"); count != crcErrors+1 {
+		t.Errorf("expected %d crc errors, got %d", crcErrors+1, count)
+	}
+	if count := countRows(t, database, "reception"); count != lines-crcErrors+4 {
+		t.Errorf("expected %d receptions, got %d", lines-crcErrors+4, count)
+	}
+
+	// adding the file again adds nothing
+	tracker = &errorTracker{}
+	addDataFromGatewayLogger(fixture, dbModel, tracker)
+	if count := countRows(t, database, "coverage"); count != lines-crcErrors+1 {
+		t.Errorf("expected no new coverage rows, got %d", count)
+	}
+
+	for _, export := range []struct {
+		gateway, layer, golden string
+	}{
+		{"aa555a0000000101", "rssi", "geojson_rssi_0101.golden"},
+		{"aa555a0000000102", "rssi", "geojson_rssi_0102.golden"},
+		{"aa555a0000000101", "margin", "geojson_margin_0101.golden"},
+	} {
+		output = filepath.Join(dir, export.golden)
+		layer = export.layer
+		geojsonCmd.Run(geojsonCmd, []string{export.gateway, "SF7BW125"})
+
+		checkGolden(t, output, export.golden)
+	}
+	output, layer = "data_geo.json", "rssi"
+}
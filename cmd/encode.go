@@ -0,0 +1,214 @@
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	encodeDevAddr    string
+	encodeFCnt       uint32
+	encodeFPort      uint8
+	encodeConfirmed  bool
+	encodeADR        bool
+	encodeACK        bool
+	encodeConfFCnt   uint16
+	encodeLatitude   float64
+	encodeLongitude  float64
+	encodePower      int8
+	encodePayload    string
+	encodeOutput     string
+	encodeGateway    string
+	encodeTime       string
+	encodeFrequency  float64
+	encodeDataRate   string
+	encodeCodingRate string
+	encodeRSSI       int16
+	encodeSNR        float64
+	encodeKeys       *keyFlags
+)
+
+// encodeCmd represents the encode command
+var encodeCmd = &cobra.Command{
+	Use:   "encode",
+	Short: "Encode a LoRaWAN uplink",
+	Long: `lora-coverage encode builds an encrypted LoRaWAN uplink with a valid MIC, the reverse of decode.
+
+The payload is the location (--lat, --lon) and, when --power is given, the transmit power
+as sent by the coverage application, or any payload with --payload (hex). The keys of the
+device are taken from the key store in the config file unless they are given as flags.
+
+The uplink is printed as base64 or hex (--output), or with --output rxpk as a lora-logger
+PUSH_DATA: RXPK line with the reception metadata of the flags, which can be added with
+the add command. The MIC of a LoRaWAN 1.1 uplink depends on the data rate and channel,
+so they are taken from these flags for every output.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		line, err := encodeUplink(cmd)
+		if err != nil {
+			log.WithError(err).Fatal("encoding uplink")
+		}
+
+		fmt.Println(line)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(encodeCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// encodeCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// encodeCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	encodeCmd.Flags().StringVar(&encodeDevAddr, "devaddr", "", "device address (in hex) [eg. 26011b2c]")
+	encodeCmd.Flags().Uint32Var(&encodeFCnt, "fcnt", 0, "frame counter")
+	encodeCmd.Flags().Uint8Var(&encodeFPort, "fport", 1, "frame port")
+	encodeCmd.Flags().BoolVar(&encodeConfirmed, "confirmed", false, "confirmed data up")
+	encodeCmd.Flags().BoolVar(&encodeADR, "adr", false, "set the ADR bit")
+	encodeCmd.Flags().BoolVar(&encodeACK, "ack", false, "set the ACK bit")
+	encodeCmd.Flags().Uint16Var(&encodeConfFCnt, "conffcnt", 0, "frame counter of the acknowledged downlink (LoRaWAN 1.1)")
+	encodeCmd.Flags().Float64Var(&encodeLatitude, "lat", 0, "latitude of the location")
+	encodeCmd.Flags().Float64Var(&encodeLongitude, "lon", 0, "longitude of the location")
+	encodeCmd.Flags().Int8Var(&encodePower, "power", 0, "transmit power in dBm (not sent when omitted)")
+	encodeCmd.Flags().StringVar(&encodePayload, "payload", "", "payload (hex) instead of the location and power")
+	encodeCmd.Flags().StringVarP(&encodeOutput, "output", "o", "base64", "output format: base64, hex or rxpk")
+	encodeCmd.Flags().StringVar(&encodeGateway, "gateway", "aa555a0000000000", "gateway mac of the rxpk")
+	encodeCmd.Flags().StringVar(&encodeTime, "time", "", "time of the rxpk (RFC3339), now when omitted")
+	encodeCmd.Flags().Float64Var(&encodeFrequency, "frequency", 868.1, "frequency in MHz")
+	encodeCmd.Flags().StringVar(&encodeDataRate, "datarate", "SF7BW125", "data rate (eg. SF7BW125 or 50000 for FSK)")
+	encodeCmd.Flags().StringVar(&encodeCodingRate, "codingrate", "4/5", "coding rate of the rxpk")
+	encodeCmd.Flags().Int16Var(&encodeRSSI, "rssi", -80, "rssi of the rxpk")
+	encodeCmd.Flags().Float64Var(&encodeSNR, "snr", 7, "snr of the rxpk")
+	encodeKeys = addKeyFlags(encodeCmd)
+}
+
+// encodeUplink returns the uplink of the flags in the output format.
+func encodeUplink(cmd *cobra.Command) (string, error) {
+	var frame model.UplinkFrame
+
+	if err := frame.DevAddr.UnmarshalText([]byte(encodeDevAddr)); err != nil {
+		return "", errors.Wrapf(err, "invalid device address: %s", encodeDevAddr)
+	}
+	frame.FCnt, frame.FPort = encodeFCnt, encodeFPort
+	frame.Confirmed, frame.ADR, frame.ACK = encodeConfirmed, encodeADR, encodeACK
+
+	var err error
+	if encodePayload != "" {
+		frame.Payload, err = hex.DecodeString(encodePayload)
+		if err != nil {
+			return "", errors.Wrap(err, "invalid payload")
+		}
+	} else {
+		power := model.UnknownPower
+		if cmd.Flags().Changed("power") {
+			power = encodePower
+		}
+		frame.Payload, err = model.EncodePayload(encodeLatitude, encodeLongitude, power)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	packet, err := encodeRxPacket()
+	if err != nil {
+		return "", err
+	}
+
+	keys, err := encodeKeys.deviceKeys(frame.DevAddr.String())
+	if err != nil {
+		return "", err
+	}
+
+	ctx := model.MICContext{FCnt: frame.FCnt}
+	if cmd.Flags().Changed("conffcnt") {
+		ctx.ConfFCnt = &encodeConfFCnt
+	}
+	ctx.TxDR, ctx.TxCh = packet.Channel()
+
+	phy, err := model.EncodeUplink(&frame, keys, ctx)
+	if err != nil {
+		return "", err
+	}
+
+	packet.Size = uint16(len(phy))
+	packet.Data = base64.StdEncoding.EncodeToString(phy)
+
+	switch encodeOutput {
+	case "base64":
+		return packet.Data, nil
+	case "hex":
+		return hex.EncodeToString(phy), nil
+	case "rxpk":
+		line, err := packet.LogLine()
+		return string(line), err
+	}
+
+	return "", errors.Errorf("unknown output format: %s", encodeOutput)
+}
+
+// encodeRxPacket returns the rx packet with the reception metadata of the flags.
+func encodeRxPacket() (*model.RxPacket, error) {
+	packet := &model.RxPacket{
+		Plan:       configPlan(),
+		Frequency:  encodeFrequency,
+		Crc:        1,
+		Modulation: model.ModulationLoRa,
+		CodingRate: encodeCodingRate,
+		RSSI:       encodeRSSI,
+		SNR:        encodeSNR,
+	}
+
+	packet.DataR.UnmarshalText([]byte(strings.ToUpper(encodeDataRate)))
+	if packet.DataR.FSK > 0 {
+		packet.Modulation, packet.CodingRate, packet.SNR = model.ModulationFSK, "", 0
+	}
+
+	if err := packet.GatewayMac.UnmarshalText([]byte(encodeGateway)); err != nil {
+		return nil, errors.Wrapf(err, "invalid gateway mac: %s", encodeGateway)
+	}
+
+	t := time.Now()
+	if encodeTime != "" {
+		var err error
+		t, err = time.Parse(time.RFC3339Nano, encodeTime)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid time: %s", encodeTime)
+		}
+	}
+	packet.Time = model.CompactTime(t)
+
+	return packet, nil
+}
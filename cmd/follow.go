@@ -0,0 +1,320 @@
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// fingerprintSize is the maximum number of leading bytes of a file that are hashed
+	// to recognise it again after a restart.
+	fingerprintSize = 1024
+	// offsetSaveLines is the number of lines after which the offset is saved while the
+	// follower is still catching up with the file.
+	offsetSaveLines = 1000
+)
+
+// stoppedError is returned when a signal is received while waiting for the file.
+var stoppedError = errors.New("stopped following")
+
+// follower tails a lora-logger json file and survives rotation and truncation.
+type follower struct {
+	fileName string
+	dbModel  *model.Model
+	interval time.Duration
+	tracker  *errorTracker
+
+	file    *os.File
+	info    os.FileInfo
+	reader  *bufio.Reader
+	offset  int64
+	partial []byte
+	rotated bool
+	lines   int
+	// saved is the offset that was last stored in the database, -1 if none.
+	saved int64
+}
+
+func followGatewayLogger(fileName string, dbModel *model.Model, interval time.Duration, tracker *errorTracker) {
+	ctx := log.WithField("data-file", fileName)
+
+	absFileName, err := filepath.Abs(fileName)
+	if err != nil {
+		ctx.WithError(err).Fatal("resolving json file path")
+	}
+
+	f := &follower{
+		fileName: absFileName,
+		dbModel:  dbModel,
+		interval: interval,
+		tracker:  tracker,
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	if err := f.open(true, stop); err == stoppedError {
+		ctx.Info("stopped following")
+		return
+	} else if err != nil {
+		ctx.WithError(err).Fatal("opening json file (arg)")
+	}
+
+	ctx.WithField("offset", f.offset).Info("following")
+
+	err = f.follow(stop, ctx)
+	// the offset is saved before exiting, also when following failed
+	f.close()
+	if err != nil {
+		ctx.WithError(err).WithField("offset", f.offset).Fatal("following json file")
+	}
+
+	ctx.WithField("offset", f.offset).Info("stopped following")
+}
+
+// follow processes the lines of the file until a signal is received on stop or an
+// error occurs. The offset is not advanced past a line that returned an error.
+func (f *follower) follow(stop <-chan os.Signal, ctx log.Interface) error {
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		line, err := f.reader.ReadBytes('\n')
+		f.partial = append(f.partial, line...)
+
+		if err == nil {
+			if err := processLogLine(f.partial, f.dbModel, f.tracker, ctx.WithField("offset", f.offset)); err != nil {
+				return err
+			}
+			f.offset += int64(len(f.partial))
+			f.partial = f.partial[:0]
+
+			f.lines++
+			if f.lines%offsetSaveLines == 0 {
+				f.saveOffset()
+			}
+			continue
+		}
+
+		if err != io.EOF {
+			return errors.Wrap(err, "error reading json file")
+		}
+
+		f.saveOffset()
+
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(f.interval):
+		}
+
+		if err := f.checkFile(stop); err == stoppedError {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "error reopening json file")
+		}
+	}
+}
+
+// open opens the followed file. When resume is set the offset stored in the database
+// is used if the file still matches the stored fingerprint, otherwise it starts at 0.
+// While the file does not exist it waits for it, stoppedError is returned when a
+// signal is received on stop in the meantime.
+func (f *follower) open(resume bool, stop <-chan os.Signal) error {
+	file, err := os.Open(f.fileName)
+	if os.IsNotExist(err) {
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+
+		for os.IsNotExist(err) {
+			log.WithField("data-file", f.fileName).Debug("waiting for file to appear")
+			select {
+			case <-stop:
+				return stoppedError
+			case <-ticker.C:
+			}
+			file, err = os.Open(f.fileName)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.info = info
+	f.offset = 0
+	f.saved = -1
+	f.partial = f.partial[:0]
+	f.rotated = false
+
+	if resume {
+		stored, err := f.dbModel.GetFileOffset(f.fileName)
+		if err != nil {
+			return err
+		}
+
+		if stored != nil && stored.Offset <= info.Size() {
+			fingerprint, err := f.fingerprint(stored.FingerprintSize)
+			if err != nil {
+				return err
+			}
+
+			if fingerprint == stored.Fingerprint {
+				f.offset = stored.Offset
+				f.saved = stored.Offset
+			} else {
+				log.WithField("data-file", f.fileName).Info("file changed since last run, starting from the beginning")
+			}
+		}
+	}
+
+	if _, err := f.file.Seek(f.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	if f.reader == nil {
+		f.reader = bufio.NewReader(f.file)
+	} else {
+		f.reader.Reset(f.file)
+	}
+
+	return nil
+}
+
+func (f *follower) close() {
+	if f.file != nil {
+		f.saveOffset()
+		f.file.Close()
+	}
+}
+
+// checkFile detects rotation (a new file at the same path) and truncation of the
+// followed file. A rotated file is only left after it has been read completely, an
+// unterminated last line is processed before switching.
+func (f *follower) checkFile(stop <-chan os.Signal) error {
+	ctx := log.WithField("data-file", f.fileName)
+
+	if f.rotated {
+		if len(f.partial) > 0 {
+			if err := processLogLine(f.partial, f.dbModel, f.tracker, ctx.WithField("offset", f.offset)); err != nil {
+				return err
+			}
+			f.offset += int64(len(f.partial))
+			f.partial = f.partial[:0]
+		}
+
+		ctx.Info("file rotated, reopening")
+		f.file.Close()
+		f.file = nil
+		return f.open(false, stop)
+	}
+
+	info, err := os.Stat(f.fileName)
+	if os.IsNotExist(err) {
+		// moved away and the new file is not there yet, keep reading the old one
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !os.SameFile(info, f.info) {
+		// read whatever is left in the old file before switching
+		f.rotated = true
+		return nil
+	}
+
+	if info.Size() < f.offset+int64(len(f.partial)) {
+		ctx.Info("file truncated, starting from the beginning")
+		f.offset = 0
+		f.partial = f.partial[:0]
+		if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		f.reader.Reset(f.file)
+	}
+
+	return nil
+}
+
+// saveOffset stores the offset in the database when it changed since it was last saved.
+func (f *follower) saveOffset() {
+	if f.offset == f.saved {
+		return
+	}
+
+	size := f.offset
+	if size > fingerprintSize {
+		size = fingerprintSize
+	}
+
+	fingerprint, err := f.fingerprint(size)
+	if err != nil {
+		log.WithError(err).WithField("data-file", f.fileName).Error("calculating file fingerprint")
+		return
+	}
+
+	offset := &model.FileOffset{
+		File:            f.fileName,
+		Offset:          f.offset,
+		Fingerprint:     fingerprint,
+		FingerprintSize: size,
+	}
+
+	if err := f.dbModel.SetFileOffset(offset); err != nil {
+		log.WithError(err).WithField("data-file", f.fileName).Error("saving file offset")
+		return
+	}
+	f.saved = f.offset
+}
+
+// fingerprint hashes the first size bytes of the followed file.
+func (f *follower) fingerprint(size int64) (string, error) {
+	hash := sha1.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(f.file, 0, size)); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
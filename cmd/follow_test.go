@@ -0,0 +1,220 @@
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-coverage/model"
+)
+
+// followFixture writes the first lines of the drive test followed by the extra lines
+// and returns a follower that opened the file.
+func followFixture(t *testing.T, dir string, dbModel *model.Model, tracker *errorTracker, extra ...string) (*follower, []byte) {
+	data, err := ioutil.ReadFile("../test.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	data = bytes.Join(lines[:10], nil)
+	for _, line := range extra {
+		data = append(data, line+"\n"...)
+	}
+
+	fileName := filepath.Join(dir, "lora-log.json")
+	if err := ioutil.WriteFile(fileName, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &follower{fileName: fileName, dbModel: dbModel, interval: time.Millisecond, tracker: tracker}
+	if err := f.open(true, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return f, bytes.Join(lines[:10], nil)
+}
+
+func TestFollowStop(t *testing.T) {
+	dir, dbModel, tearDown := e2eSetup(t)
+	defer tearDown()
+
+	f, _ := followFixture(t, dir, dbModel, &errorTracker{})
+	defer f.close()
+
+	// a signal is handled before the next line, not only at the end of the file
+	stop := make(chan os.Signal, 1)
+	stop <- os.Interrupt
+	if err := f.follow(stop, log.Log); err != nil {
+		t.Fatal(err)
+	}
+	if f.lines != 0 || f.offset != 0 {
+		t.Errorf("expected to stop before the first line, got %d lines at offset %d", f.lines, f.offset)
+	}
+}
+
+func TestFollowTooManyErrors(t *testing.T) {
+	dir, dbModel, tearDown := e2eSetup(t)
+	defer tearDown()
+
+	f, valid := followFixture(t, dir, dbModel, &errorTracker{max: 1}, "not json", "not json either")
+
+	err := f.follow(make(chan os.Signal), log.Log)
+	if err == nil {
+		t.Fatal("expected too many errors")
+	}
+	f.close()
+
+	// the line that exceeded the maximum is not skipped when following again
+	expected := int64(len(valid) + len("not json\n"))
+	stored, err := dbModel.GetFileOffset(f.fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored == nil || stored.Offset != expected {
+		t.Errorf("expected offset %d to be saved, got %+v", expected, stored)
+	}
+}
+
+func TestFollowSaveOffset(t *testing.T) {
+	dir, dbModel, tearDown := e2eSetup(t)
+	defer tearDown()
+
+	f, valid := followFixture(t, dir, dbModel, &errorTracker{})
+	defer f.close()
+
+	getOffset := func() int64 {
+		stored, err := dbModel.GetFileOffset(f.fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored == nil {
+			return -1
+		}
+		return stored.Offset
+	}
+
+	f.offset = int64(len(valid))
+	f.saveOffset()
+	if offset := getOffset(); offset != f.offset {
+		t.Fatalf("expected offset %d, got %d", f.offset, offset)
+	}
+
+	// an unchanged offset is not written again
+	if err := dbModel.SetFileOffset(&model.FileOffset{File: f.fileName, Offset: 1}); err != nil {
+		t.Fatal(err)
+	}
+	f.saveOffset()
+	if offset := getOffset(); offset != 1 {
+		t.Errorf("expected the unchanged offset not to be saved, got %d", offset)
+	}
+
+	f.offset = 0
+	f.saveOffset()
+	if offset := getOffset(); offset != 0 {
+		t.Errorf("expected offset 0, got %d", offset)
+	}
+}
+
+func TestFollowStopWhileMissing(t *testing.T) {
+	dir, dbModel, tearDown := e2eSetup(t)
+	defer tearDown()
+
+	stop := make(chan os.Signal, 1)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		stop <- os.Interrupt
+	}()
+
+	f := &follower{fileName: filepath.Join(dir, "missing.json"), dbModel: dbModel, interval: time.Millisecond,
+		tracker: &errorTracker{}}
+	if err := f.open(true, stop); err != stoppedError {
+		t.Fatalf("expected to stop while waiting for the file, got %v", err)
+	}
+
+	// the file was rotated away and the new one does not appear
+	f, _ = followFixture(t, dir, dbModel, &errorTracker{})
+	defer f.close()
+	if err := os.Remove(f.fileName); err != nil {
+		t.Fatal(err)
+	}
+	f.rotated = true
+
+	stop <- os.Interrupt
+	if err := f.checkFile(stop); err != stoppedError {
+		t.Fatalf("expected to stop while waiting for the rotated file, got %v", err)
+	}
+}
+
+func TestFollowRotatedPartialLine(t *testing.T) {
+	data, err := ioutil.ReadFile("../test.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := bytes.SplitAfter(data, []byte("\n"))[0]
+
+	tests := []struct {
+		name    string
+		partial []byte
+		rows    int
+		errors  int
+	}{
+		{"complete", bytes.TrimRight(first, "\n"), 1, 0},
+		{"truncated", first[:20], 0, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, dbModel, tearDown := e2eSetup(t)
+			defer tearDown()
+
+			tracker := &errorTracker{}
+			f, _ := followFixture(t, dir, dbModel, tracker)
+			defer f.close()
+
+			// the old file ends with a line without newline and a new file appeared
+			f.partial = append(f.partial, test.partial...)
+			f.rotated = true
+
+			if err := f.checkFile(nil); err != nil {
+				t.Fatal(err)
+			}
+			if len(f.partial) != 0 || f.offset != 0 {
+				t.Errorf("expected to follow the new file from the start, got offset %d", f.offset)
+			}
+
+			rows, err := dbModel.GetCoverageRows("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != test.rows || tracker.count != test.errors {
+				t.Errorf("expected %d rows and %d errors, got %d rows and %d errors", test.rows, test.errors,
+					len(rows), tracker.count)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/bullettime/lora-coverage/region"
+)
+
+// frameWindow is the time in which rows of different gateways with the same payload
+// are taken to be the same uplink.
+const frameWindow = 2 * time.Second
+
+// frame is an uplink as received by one or more gateways.
+type frame struct {
+	device     string
+	time       time.Time
+	dataRate   model.DataRate
+	frequency  float64
+	size       uint16
+	airtime    time.Duration
+	codingRate string
+	payload    string
+	power      int8
+	snr        float64
+	gateways   int
+	latitude   float64
+	longitude  float64
+	mType      string
+	fCnt       *uint32
+	ack        bool
+}
+
+// timeOnAir returns the time on air of the frame at a data rate.
+func (f *frame) timeOnAir(dr region.DataRate) time.Duration {
+	return dr.TimeOnAir(int(f.size), model.FrameSettings(f.codingRate))
+}
+
+// groupFrames merges the rows of the same uplink received by different gateways, the
+// frames are sorted by device and time.
+func groupFrames(rows []*model.Coverage, device string) []*frame {
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.DeviceAddr != b.DeviceAddr {
+			return a.DeviceAddr.String() < b.DeviceAddr.String()
+		}
+		return time.Time(a.Time).Before(time.Time(b.Time))
+	})
+
+	var frames []*frame
+	var last *frame
+
+	for _, row := range rows {
+		addr := row.DeviceAddr.String()
+		if device != "" && addr != device {
+			continue
+		}
+
+		t := time.Time(row.Time)
+		if last != nil && last.device == addr && last.payload == row.Payload && last.dataRate == row.DataRate &&
+			last.frequency == row.Frequency && t.Sub(last.time) <= frameWindow {
+			last.gateways++
+			last.snr = math.Max(last.snr, row.SNR)
+			if last.latitude == 0 && last.longitude == 0 {
+				last.latitude, last.longitude = row.Latitude, row.Longitude
+			}
+			continue
+		}
+
+		last = &frame{
+			device:     addr,
+			time:       t,
+			dataRate:   row.DataRate,
+			frequency:  row.Frequency,
+			size:       row.Size,
+			airtime:    row.Airtime,
+			codingRate: row.CodingRate,
+			payload:    row.Payload,
+			power:      row.Power,
+			snr:        row.SNR,
+			gateways:   1,
+			latitude:   row.Latitude,
+			longitude:  row.Longitude,
+			mType:      row.MType,
+			fCnt:       row.FCnt,
+			ack:        row.FCtrl.ACK,
+		}
+		frames = append(frames, last)
+	}
+
+	return frames
+}
@@ -29,11 +29,13 @@ import (
 	"github.com/bullettime/lora-coverage/db"
 	"io/ioutil"
 	"fmt"
+	"math"
 )
 
 var (
 	callback = "eqfeed_callback"
 	output = "data_geo.json"
+	layer = "rssi"
 )
 
 // geojsonCmd represents the geojson command
@@ -44,11 +46,15 @@ var geojsonCmd = &cobra.Command{
 
 This command takes two arguments:
 	1. gatewac mac (in hex) [eg. 008000000000b88d]
-	2. datarate [eg. SF7BW125]
-The arguments have to be entered in that order.`,
+	2. datarate [eg. SF7BW125, or the bit rate for FSK: 50000]
+The arguments have to be entered in that order.
+
+With --layer margin the points get the link margin (see the margin command) instead of
+only the rssi: margin, snr_margin, rssi_margin and, below SF7, faster_margin which is
+the link margin the uplink would have had at the next faster data rate.`,
 	Args: cobra.ExactArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
-		database, err := db.Connect()
+		database, err := db.Open(viper.GetString("database.dbfile"))
 		if err != nil {
 			log.WithError(err).WithField("database", viper.GetString("database.dbfile")).Fatal("connecting to database")
 		}
@@ -58,9 +64,17 @@ The arguments have to be entered in that order.`,
 			log.WithError(err).Fatal("initializing database")
 		}
 
-		dbModel := model.New(database)
+		dbModel := model.New(database, modelConfig())
 
-		points, err := dbModel.GetGeoJSonPoints(args[0], args[1])
+		var points []*geojson.Feature
+		switch layer {
+		case "rssi":
+			points, err = dbModel.GetGeoJSonPoints(args[0], args[1])
+		case "margin":
+			points, err = getMarginPoints(dbModel, args[0], args[1])
+		default:
+			log.WithField("layer", layer).Fatal("unknown layer (use rssi or margin)")
+		}
 		if err != nil {
 			log.WithError(err).Fatal("getting geo json points")
 		}
@@ -95,4 +109,41 @@ func init() {
 	// geojsonCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	geojsonCmd.Flags().StringVarP(&callback, "callback", "c", "eqfeed_callback", "name of the callback function")
 	geojsonCmd.Flags().StringVarP(&output, "output", "o", "data_geo.json", "name of the output file")
+	geojsonCmd.Flags().StringVar(&layer, "layer", "rssi", "property of the points: rssi or margin")
+}
+
+// getMarginPoints returns the located rows of a gateway with a data rate as points
+// with their link margin.
+func getMarginPoints(dbModel *model.Model, gateway string, datarate string) ([]*geojson.Feature, error) {
+	rows, err := dbModel.GetCoverageRows(gateway, datarate)
+	if err != nil {
+		return nil, err
+	}
+
+	var points []*geojson.Feature
+	for _, row := range rows {
+		m, ok := row.Margin()
+		if !ok || (row.Latitude == 0 && row.Longitude == 0) {
+			continue
+		}
+
+		feature := geojson.NewPointFeature([]float64{row.Latitude, row.Longitude})
+		feature.SetProperty("rssi", row.RSSI)
+		feature.SetProperty("snr", row.SNR)
+		feature.SetProperty("margin", roundMargin(m.Link()))
+		feature.SetProperty("snr_margin", roundMargin(m.SNR))
+		feature.SetProperty("rssi_margin", roundMargin(m.RSSI))
+		if faster, ok := m.Faster(); ok {
+			feature.SetProperty("faster_margin", roundMargin(faster.Link()))
+		}
+
+		points = append(points, feature)
+	}
+
+	return points, nil
+}
+
+// roundMargin rounds a margin to 0.1 dB.
+func roundMargin(value float64) float64 {
+	return math.Round(value*10) / 10
 }
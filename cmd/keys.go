@@ -0,0 +1,137 @@
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"strings"
+
+	"github.com/brocaar/lorawan"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var keyNames = []string{"nwkskey", "fnwksintkey", "snwksintkey", "nwksenckey", "appskey"}
+
+// configKeys is the key store in the config file. Devices are configured under
+// lora.devices by their address:
+//
+//	lora:
+//	  devices:
+//	    26011b2c:
+//	      version: "1.1"
+//	      fnwksintkey: ...
+//	      snwksintkey: ...
+//	      nwksenckey: ...
+//	      appskey: ...
+//
+// A LoRaWAN 1.0 device has a nwkskey instead of the three network keys, keys that are
+// not configured for a device (or devices that are not configured at all) fall back
+// to lora.nwkskey and lora.appskey.
+type configKeys struct{}
+
+// DeviceKeys returns the keys of a device, the config is read on every call so keys
+// from the environment and flags are taken into account.
+func (configKeys) DeviceKeys(devAddr string) (*model.DeviceKeys, error) {
+	device := strings.ToLower(devAddr)
+	store := model.KeyStore{
+		Devices: map[string]model.KeySettings{device: keySettings("lora.devices." + device + ".")},
+		Default: keySettings("lora."),
+	}
+	return store.DeviceKeys(devAddr)
+}
+
+// keySettings returns the keys in the config file under the prefix.
+func keySettings(prefix string) model.KeySettings {
+	return model.KeySettings{
+		Version:     viper.GetString(prefix + "version"),
+		NwkSKey:     viper.GetString(prefix + "nwkskey"),
+		FNwkSIntKey: viper.GetString(prefix + "fnwksintkey"),
+		SNwkSIntKey: viper.GetString(prefix + "snwksintkey"),
+		NwkSEncKey:  viper.GetString(prefix + "nwksenckey"),
+		AppSKey:     viper.GetString(prefix + "appskey"),
+	}
+}
+
+// keyFlags are the flags of a command that override the key store.
+type keyFlags struct {
+	version string
+	keys    map[string]*string
+}
+
+// addKeyFlags adds the LoRaWAN version and session key flags to a command.
+func addKeyFlags(cmd *cobra.Command) *keyFlags {
+	flags := &keyFlags{keys: make(map[string]*string)}
+
+	cmd.Flags().StringVar(&flags.version, "lorawan-version", "", "LoRaWAN version of the keys: 1.0 or 1.1")
+	for _, key := range keyNames {
+		flags.keys[key] = cmd.Flags().String(key, "", key+" to use instead of the key store (hex)")
+	}
+
+	return flags
+}
+
+// deviceKeys returns the keys of the device in the key store with the keys given as
+// flags, the nwkskey sets the three network keys of LoRaWAN 1.1.
+func (f *keyFlags) deviceKeys(devAddr string) (*model.DeviceKeys, error) {
+	overridden := f.version != ""
+	for _, value := range f.keys {
+		overridden = overridden || *value != ""
+	}
+
+	keys, err := configKeys{}.DeviceKeys(devAddr)
+	if err != nil {
+		if !overridden {
+			return nil, err
+		}
+		keys = &model.DeviceKeys{Version: model.LoRaWAN10}
+	}
+
+	if f.version != "" {
+		keys.Version = f.version
+	}
+
+	// the nwkskey first, the separate network keys override it
+	targets := map[string][]*lorawan.AES128Key{
+		"nwkskey":     {&keys.FNwkSIntKey, &keys.SNwkSIntKey, &keys.NwkSEncKey},
+		"fnwksintkey": {&keys.FNwkSIntKey},
+		"snwksintkey": {&keys.SNwkSIntKey},
+		"nwksenckey":  {&keys.NwkSEncKey},
+		"appskey":     {&keys.AppSKey},
+	}
+	for _, name := range keyNames {
+		value := *f.keys[name]
+		if value == "" {
+			continue
+		}
+
+		var key lorawan.AES128Key
+		if err := key.UnmarshalText([]byte(value)); err != nil {
+			return nil, errors.Wrapf(err, "invalid %s", name)
+		}
+		for _, t := range targets[name] {
+			*t = key
+		}
+	}
+
+	return keys, nil
+}
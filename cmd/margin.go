@@ -0,0 +1,212 @@
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-coverage/db"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	marginDataRate string
+	marginBucket   float64
+	marginKind     string
+)
+
+// histogramWidth is the width of the largest bar of a histogram.
+const histogramWidth = 40
+
+// marginCmd represents the margin command
+var marginCmd = &cobra.Command{
+	Use:   "margin [gateway]",
+	Short: "Show the link margin per gateway and data rate",
+	Long: `lora-coverage margin shows a histogram of the link margin per gateway and data rate.
+
+This command takes an optional argument:
+	- gateway mac (in hex) [eg. 008000000000b88d], all gateways when omitted
+The SNR margin is the SNR above the demodulation floor of the spreading factor (-7.5 dB
+at SF7 down to -20 dB at SF12), the RSSI margin is the RSSI above the receiver
+sensitivity. The link margin is the smallest of both. For every data rate the share of
+uplinks that would still have been received at the next faster data rate is shown.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var gateway string
+		if len(args) > 0 {
+			gateway = strings.ToLower(args[0])
+		}
+
+		database, err := db.Open(viper.GetString("database.dbfile"))
+		if err != nil {
+			log.WithError(err).WithField("database", viper.GetString("database.dbfile")).Fatal("connecting to database")
+		}
+		defer database.Disconnect()
+
+		if err := database.Init(); err != nil {
+			log.WithError(err).Fatal("initializing database")
+		}
+
+		dbModel := model.New(database, modelConfig())
+
+		rows, err := dbModel.GetCoverageRows(gateway, marginDataRate)
+		if err != nil {
+			log.WithError(err).Fatal("getting coverage rows")
+		}
+
+		if err := printMargins(os.Stdout, rows, marginKind, marginBucket); err != nil {
+			log.WithError(err).Fatal("printing margins")
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(marginCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// marginCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// marginCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	marginCmd.Flags().StringVar(&marginDataRate, "datarate", "", "only show this data rate [eg. SF7BW125]")
+	marginCmd.Flags().Float64Var(&marginBucket, "bucket", 2.5, "width of the histogram buckets in dB")
+	marginCmd.Flags().StringVar(&marginKind, "margin", "link", "margin to show: link, snr or rssi")
+}
+
+type marginGroup struct {
+	gateway  string
+	dataRate string
+	margins  []float64
+	faster   int
+	survived int
+}
+
+// marginValue returns the requested kind of margin.
+func marginValue(m model.Margin, kind string) (float64, error) {
+	switch kind {
+	case "link":
+		return m.Link(), nil
+	case "snr":
+		return m.SNR, nil
+	case "rssi":
+		return m.RSSI, nil
+	}
+	return 0, errors.Errorf("unknown margin: %s (use link, snr or rssi)", kind)
+}
+
+func printMargins(w io.Writer, rows []*model.Coverage, kind string, bucket float64) error {
+	if bucket <= 0 {
+		return errors.New("bucket width must be positive")
+	}
+
+	groups := make(map[string]*marginGroup)
+	var keys []string
+
+	for _, row := range rows {
+		m, ok := row.Margin()
+		if !ok {
+			continue
+		}
+
+		value, err := marginValue(m, kind)
+		if err != nil {
+			return err
+		}
+
+		key := row.GatewayMac.String() + " " + m.DataRate.String()
+		group, ok := groups[key]
+		if !ok {
+			group = &marginGroup{gateway: row.GatewayMac.String(), dataRate: m.DataRate.String()}
+			groups[key] = group
+			keys = append(keys, key)
+		}
+
+		group.margins = append(group.margins, value)
+
+		if faster, ok := m.Faster(); ok {
+			group.faster++
+			if value, _ := marginValue(faster, kind); value >= 0 {
+				group.survived++
+			}
+		}
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		printMarginGroup(w, groups[key], kind, bucket)
+	}
+
+	return nil
+}
+
+func printMarginGroup(w io.Writer, group *marginGroup, kind string, bucket float64) {
+	margins := group.margins
+	sort.Float64s(margins)
+
+	fmt.Fprintf(w, "gateway %s %s: %d uplinks, %s margin min %.1f dB, median %.1f dB, max %.1f dB\n",
+		group.gateway, group.dataRate, len(margins), kind, margins[0], median(margins), margins[len(margins)-1])
+	if group.faster > 0 {
+		fmt.Fprintf(w, "  %.0f%% would survive the next faster data rate\n",
+			100*float64(group.survived)/float64(group.faster))
+	}
+
+	first := math.Floor(margins[0] / bucket)
+	last := math.Floor(margins[len(margins)-1] / bucket)
+	counts := make([]int, int(last-first)+1)
+	maxCount := 0
+	for _, m := range margins {
+		i := int(math.Floor(m/bucket) - first)
+		counts[i]++
+		if counts[i] > maxCount {
+			maxCount = counts[i]
+		}
+	}
+
+	for i, count := range counts {
+		low := (first + float64(i)) * bucket
+		bar := strings.Repeat("#", (count*histogramWidth+maxCount-1)/maxCount)
+		fmt.Fprintf(w, "  %6.1f .. %6.1f dB |%-*s %d\n", low, low+bucket, histogramWidth, bar, count)
+	}
+
+	fmt.Fprintln(w)
+}
+
+func median(sorted []float64) float64 {
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
@@ -0,0 +1,164 @@
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-coverage/db"
+	"github.com/bullettime/lora-coverage/integration"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/bullettime/lora-coverage/mqtt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	mqttBroker     string
+	mqttUsername   string
+	mqttPassword   string
+	mqttClientID   string
+	mqttTopics     []string
+	mqttQoS        int
+	mqttRetry      time.Duration
+	mqttUseDecoded bool
+)
+
+// mqttCmd represents the mqtt command
+var mqttCmd = &cobra.Command{
+	Use:   "mqtt",
+	Short: "Add data from network server uplinks received over MQTT",
+	Long: `lora-coverage mqtt subscribes to the uplink events of a network server and adds them to the database.
+
+Supported are ChirpStack (application/+/device/+/event/up) and The Things Stack (v3/+/devices/+/up),
+the format is detected from the topic of each message. Every gateway that received an uplink
+results in a coverage row. The location and power are decoded from the payload like the
+lora-logger data, or taken from the payload decoded by the network server with --use-decoded.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		database, err := db.Open(viper.GetString("database.dbfile"))
+		if err != nil {
+			log.WithError(err).WithField("database", viper.GetString("database.dbfile")).Fatal("connecting to database")
+		}
+		defer database.Disconnect()
+
+		if err := database.Init(); err != nil {
+			log.WithError(err).Fatal("initializing database")
+		}
+
+		dbModel := model.New(database, modelConfig())
+
+		addDataFromMQTT(dbModel)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(mqttCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// mqttCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// mqttCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	mqttCmd.Flags().StringVarP(&mqttBroker, "broker", "b", "tcp://localhost:1883", "address of the mqtt broker")
+	mqttCmd.Flags().StringVarP(&mqttUsername, "username", "u", "", "mqtt user name")
+	mqttCmd.Flags().StringVarP(&mqttPassword, "password", "p", "", "mqtt password (or API key)")
+	mqttCmd.Flags().StringVar(&mqttClientID, "client-id", "", "mqtt client id (default is random)")
+	mqttCmd.Flags().StringSliceVarP(&mqttTopics, "topic", "t",
+		[]string{"application/+/device/+/event/up", "v3/+/devices/+/up"}, "topics to subscribe to")
+	mqttCmd.Flags().IntVar(&mqttQoS, "qos", 1, "quality of service of the subscription (0 or 1)")
+	mqttCmd.Flags().DurationVar(&mqttRetry, "retry", 10*time.Second, "time to wait before reconnecting")
+	mqttCmd.Flags().BoolVar(&mqttUseDecoded, "use-decoded", false, "use the payload decoded by the network server")
+}
+
+func addDataFromMQTT(dbModel *model.Model) {
+	ctx := log.WithField("broker", mqttBroker)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	options := mqtt.Options{
+		Broker:   mqttBroker,
+		ClientID: mqttClientID,
+		Username: mqttUsername,
+		Password: mqttPassword,
+	}
+
+	handler := func(message mqtt.Message) {
+		ctx := ctx.WithField("topic", message.Topic)
+
+		format, ok := integration.FormatForTopic(message.Topic)
+		if !ok {
+			ctx.Debug("ignoring message on unknown topic")
+			return
+		}
+
+		if err := integration.AddUplink(dbModel, format, message.Payload, mqttUseDecoded); err != nil {
+			ctx.WithError(err).WithField("payload", string(message.Payload)).Error("adding uplink")
+		}
+	}
+
+	for {
+		client, err := mqtt.Dial(options)
+		if err == nil {
+			err = client.Subscribe(byte(mqttQoS), mqttTopics...)
+			if err != nil {
+				client.Close()
+			}
+		}
+
+		if err == nil {
+			ctx.WithField("topics", mqttTopics).Info("subscribed")
+
+			done := make(chan error, 1)
+			go func() {
+				done <- client.Run(handler)
+			}()
+
+			select {
+			case <-stop:
+				client.Close()
+				<-done
+				ctx.Info("disconnected")
+				return
+			case err = <-done:
+				client.Close()
+			}
+		}
+
+		ctx.WithError(err).WithField("retry", mqttRetry).Error("mqtt connection failed")
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(mqttRetry):
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/apex/log"
+	"github.com/pkg/errors"
+)
+
+// errorTracker counts the lines that could not be processed, writes them to an
+// optional quarantine file for later inspection and reports when too many lines
+// failed.
+type errorTracker struct {
+	max        int
+	count      int
+	quarantine *os.File
+}
+
+func newErrorTracker(max int, quarantineFile string) (*errorTracker, error) {
+	t := &errorTracker{max: max}
+
+	if len(quarantineFile) > 0 {
+		f, err := os.OpenFile(quarantineFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error opening quarantine file: %s", quarantineFile)
+		}
+		t.quarantine = f
+	}
+
+	return t, nil
+}
+
+// add registers a failed line. It returns an error when the maximum number of
+// errors is exceeded (a maximum of 0 means no limit).
+func (t *errorTracker) add(line []byte) error {
+	t.count++
+
+	if t.quarantine != nil {
+		if _, err := fmt.Fprintf(t.quarantine, "%s\n", line); err != nil {
+			log.WithError(err).WithField("quarantine-file", t.quarantine.Name()).Error("writing quarantine file")
+		}
+	}
+
+	if t.max > 0 && t.count > t.max {
+		return errors.Errorf("more than %d lines could not be processed", t.max)
+	}
+
+	return nil
+}
+
+func (t *errorTracker) close() {
+	if t.quarantine != nil {
+		t.quarantine.Close()
+	}
+}
@@ -25,11 +25,15 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/apex/log"
 	cliHandler "github.com/apex/log/handlers/cli"
 	textHandler "github.com/apex/log/handlers/logfmt"
 	multiHandler "github.com/apex/log/handlers/multi"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/bullettime/lora-coverage/region"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -104,12 +108,15 @@ func init() {
 	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.lora-coverage.yaml)")
 	RootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "print everything to standard output")
 	RootCmd.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "enable debug logs")
+	RootCmd.PersistentFlags().StringP("region", "r", "EU868", "region of the channel plan: "+strings.Join(region.Names(), ", "))
+	viper.BindPFlag("lora.region", RootCmd.PersistentFlags().Lookup("region"))
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	//RootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 
 	viper.SetDefault("database.dbfile", "coverage.db")
+	viper.SetDefault("database.duplicatetolerance", 5*time.Minute)
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -139,6 +146,24 @@ func initConfig() {
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
+	}
+}
+
+// configPlan returns the channel plan of the configured region (lora.region).
+func configPlan() *region.Plan {
+	plan, err := region.Get(viper.GetString("lora.region"))
+	if err != nil {
+		log.WithError(err).Fatal("getting region")
+	}
+	return plan
+}
+
+// modelConfig returns the configuration of the model from the config file and flags.
+func modelConfig() model.Config {
+	return model.Config{
+		Plan:               configPlan(),
+		DuplicateTolerance: viper.GetDuration("database.duplicatetolerance"),
+		Keys:               configKeys{},
 	}
 }
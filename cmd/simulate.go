@@ -0,0 +1,274 @@
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-coverage/region"
+	"github.com/bullettime/lora-coverage/sim"
+	"github.com/bullettime/lora-coverage/track"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	simGateways    []string
+	simTrack       string
+	simStart       string
+	simStartTime   string
+	simDuration    time.Duration
+	simSpeed       float64
+	simInterval    time.Duration
+	simDevAddr     string
+	simDataRate    string
+	simPower       int8
+	simPropagation string
+	simExponent    float64
+	simShadowing   float64
+	simLoss        float64
+	simCRCMargin   float64
+	simSeed        int64
+	simOutput      string
+	simKeys        *keyFlags
+)
+
+// simulateCmd represents the simulate command
+var simulateCmd = &cobra.Command{
+	Use:   "simulate",
+	Short: "Generate a lora-logger file of a simulated drive test",
+	Long: `lora-coverage simulate generates the lora-logger json file of a simulated drive test.
+
+A device sends its location in encrypted uplinks every --interval while it moves along
+the route: a gpx or csv track (--track) or a random walk from --start. Every --gateway
+(mac,latitude,longitude[,height]) receives the uplinks with the path loss of the
+propagation model (freespace, logdistance or hata) and a random shadow fading of
+--shadowing dB. Uplinks below the sensitivity of the data rate and a random --loss
+fraction are not received, receptions with a link margin below --crc-margin dB can have
+a crc error.
+
+The keys of the device are taken from the key store in the config file unless they
+are given as flags. The same --seed generates the same file, the file can be added with
+the add command.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		rnd := rand.New(rand.NewSource(simSeed))
+
+		config, err := simulationConfig(rnd)
+		if err != nil {
+			log.WithError(err).Fatal("configuring simulation")
+		}
+
+		route, err := simulationRoute(rnd)
+		if err != nil {
+			log.WithError(err).Fatal("reading route")
+		}
+
+		uplinks, err := sim.Simulate(route, config)
+		if err != nil {
+			log.WithError(err).Fatal("simulating")
+		}
+
+		var w io.Writer = os.Stdout
+		if simOutput != "" && simOutput != "-" {
+			file, err := os.Create(simOutput)
+			if err != nil {
+				log.WithError(err).WithField("file", simOutput).Fatal("creating output file")
+			}
+			defer file.Close()
+			w = file
+		}
+
+		if err := sim.WriteLog(w, uplinks); err != nil {
+			log.WithError(err).Fatal("writing log")
+		}
+
+		receptions, crcErrors, lost := 0, 0, 0
+		for _, uplink := range uplinks {
+			if len(uplink.Receptions) == 0 {
+				lost++
+			}
+			for _, packet := range uplink.Receptions {
+				receptions++
+				if packet.Crc < 0 {
+					crcErrors++
+				}
+			}
+		}
+		log.WithFields(log.Fields{"uplinks": len(uplinks), "receptions": receptions, "crc-errors": crcErrors,
+			"lost": lost}).Info("done")
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(simulateCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// simulateCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// simulateCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	simulateCmd.Flags().StringArrayVar(&simGateways, "gateway", nil, "gateway as mac,latitude,longitude[,height] (repeatable)")
+	simulateCmd.Flags().StringVar(&simTrack, "track", "", "gpx or csv file with the route")
+	simulateCmd.Flags().StringVar(&simStart, "start", "", "start of the random walk as latitude,longitude")
+	simulateCmd.Flags().StringVar(&simStartTime, "start-time", "2018-04-05T21:00:00Z", "start time of the random walk (RFC3339)")
+	simulateCmd.Flags().DurationVar(&simDuration, "duration", time.Hour, "duration of the random walk")
+	simulateCmd.Flags().Float64Var(&simSpeed, "speed", 10, "speed of the random walk in m/s")
+	simulateCmd.Flags().DurationVar(&simInterval, "interval", 30*time.Second, "time between the uplinks")
+	simulateCmd.Flags().StringVar(&simDevAddr, "devaddr", "", "device address (in hex) [eg. 26011b2c]")
+	simulateCmd.Flags().StringVar(&simDataRate, "datarate", "SF7BW125", "data rate of the uplinks (eg. SF7BW125 or 50000 for FSK)")
+	simulateCmd.Flags().Int8Var(&simPower, "power", 14, "transmit power (EIRP) in dBm")
+	simulateCmd.Flags().StringVar(&simPropagation, "propagation", "logdistance", "propagation model: freespace, logdistance or hata")
+	simulateCmd.Flags().Float64Var(&simExponent, "exponent", 2.7, "path loss exponent of the logdistance model")
+	simulateCmd.Flags().Float64Var(&simShadowing, "shadowing", 6, "standard deviation of the shadow fading in dB")
+	simulateCmd.Flags().Float64Var(&simLoss, "loss", 0.05, "fraction of the uplinks a gateway misses")
+	simulateCmd.Flags().Float64Var(&simCRCMargin, "crc-margin", 2, "link margin in dB below which crc errors occur")
+	simulateCmd.Flags().Int64Var(&simSeed, "seed", 1, "seed of the random numbers")
+	simulateCmd.Flags().StringVarP(&simOutput, "output", "o", "", "output file (standard output when omitted)")
+	simKeys = addKeyFlags(simulateCmd)
+}
+
+func simulationConfig(rnd *rand.Rand) (*sim.Config, error) {
+	plan, err := region.Get(viper.GetString("lora.region"))
+	if err != nil {
+		return nil, err
+	}
+
+	dr, err := region.ParseDataRate(simDataRate)
+	if err != nil {
+		return nil, err
+	}
+
+	propagation, err := sim.GetPropagation(simPropagation)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := propagation.(sim.LogDistance); ok {
+		propagation = sim.LogDistance{Exponent: simExponent}
+	}
+
+	config := &sim.Config{
+		Plan:        plan,
+		Device:      sim.Device{Power: simPower, DataRate: dr},
+		Propagation: propagation,
+		Interval:    simInterval,
+		Shadowing:   simShadowing,
+		Loss:        simLoss,
+		CRCMargin:   simCRCMargin,
+		Rand:        rnd,
+	}
+
+	if err := config.Device.DevAddr.UnmarshalText([]byte(simDevAddr)); err != nil {
+		return nil, errors.Wrapf(err, "invalid device address: %s", simDevAddr)
+	}
+	config.Device.Keys, err = simKeys.deviceKeys(config.Device.DevAddr.String())
+	if err != nil {
+		return nil, err
+	}
+
+	if len(simGateways) == 0 {
+		return nil, errors.New("no gateways")
+	}
+	for _, value := range simGateways {
+		gateway, err := parseSimGateway(value)
+		if err != nil {
+			return nil, err
+		}
+		config.Gateways = append(config.Gateways, gateway)
+	}
+
+	return config, nil
+}
+
+// parseSimGateway parses a gateway as mac,latitude,longitude[,height], the default
+// height is 30 meter.
+func parseSimGateway(value string) (sim.Gateway, error) {
+	gateway := sim.Gateway{Height: 30}
+
+	fields := strings.Split(value, ",")
+	if len(fields) < 3 || len(fields) > 4 {
+		return gateway, errors.Errorf("invalid gateway: %s", value)
+	}
+
+	if err := gateway.Mac.UnmarshalText([]byte(fields[0])); err != nil {
+		return gateway, errors.Wrapf(err, "invalid gateway: %s", value)
+	}
+
+	numbers, err := parseFloats(fields[1:])
+	if err != nil {
+		return gateway, errors.Wrapf(err, "invalid gateway: %s", value)
+	}
+	gateway.Latitude, gateway.Longitude = numbers[0], numbers[1]
+	if len(numbers) > 2 {
+		gateway.Height = numbers[2]
+	}
+
+	return gateway, nil
+}
+
+// simulationRoute reads the track or generates the random walk.
+func simulationRoute(rnd *rand.Rand) (track.Track, error) {
+	if simTrack != "" {
+		file, err := os.Open(simTrack)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error opening %s", simTrack)
+		}
+		defer file.Close()
+
+		return track.Read(file, "", simTrack)
+	}
+
+	position, err := parseFloats(strings.Split(simStart, ","))
+	if err != nil || len(position) != 2 {
+		return nil, errors.Errorf("invalid start (latitude,longitude): %s", simStart)
+	}
+
+	start, err := time.Parse(time.RFC3339Nano, simStartTime)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid start time: %s", simStartTime)
+	}
+
+	return sim.RandomWalk(track.Point{Time: start, Latitude: position[0], Longitude: position[1]}, simDuration,
+		10*time.Second, simSpeed, rnd), nil
+}
+
+func parseFloats(values []string) ([]float64, error) {
+	numbers := make([]float64, len(values))
+	for i, value := range values {
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, err
+		}
+		numbers[i] = number
+	}
+	return numbers, nil
+}
@@ -0,0 +1,129 @@
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-coverage/db"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/bullettime/lora-coverage/region"
+	"github.com/bullettime/lora-coverage/station"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	stationListen  string
+	stationTLSCert string
+	stationTLSKey  string
+)
+
+// stationCmd represents the station command
+var stationCmd = &cobra.Command{
+	Use:   "station",
+	Short: "Add data from LoRa Basics Station gateways",
+	Long: `lora-coverage station acts as the LNS for LoRa Basics Station gateways and adds their uplinks to the database.
+
+Point the gateways to ws://<host>:<port> (wss with --tls-cert and --tls-key), they discover
+their traffic endpoint through /router-info. The gateways are configured with the channels
+of the region given with --region (or lora.region in the config file). The uplinks are decrypted with the configured keys,
+just like the lora-logger data.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := modelConfig()
+
+		database, err := db.Open(viper.GetString("database.dbfile"))
+		if err != nil {
+			log.WithError(err).WithField("database", viper.GetString("database.dbfile")).Fatal("connecting to database")
+		}
+		defer database.Disconnect()
+
+		if err := database.Init(); err != nil {
+			log.WithError(err).Fatal("initializing database")
+		}
+
+		dbModel := model.New(database, config)
+
+		runStationServer(config.Plan, dbModel)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(stationCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// stationCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// stationCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	stationCmd.Flags().StringVarP(&stationListen, "listen", "l", ":3001", "address to listen on")
+	stationCmd.Flags().StringVar(&stationTLSCert, "tls-cert", "", "certificate file to serve wss")
+	stationCmd.Flags().StringVar(&stationTLSKey, "tls-key", "", "key file to serve wss")
+}
+
+func runStationServer(plan *region.Plan, dbModel *model.Model) {
+	ctx := log.WithFields(log.Fields{"listen": stationListen, "region": plan.Name})
+
+	server := &http.Server{
+		Addr: stationListen,
+		Handler: &station.Server{
+			Plan:  plan,
+			Model: dbModel,
+		},
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	go func() {
+		<-stop
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	ctx.Info("listening for basics station gateways")
+
+	var err error
+	if len(stationTLSCert) > 0 {
+		err = server.ListenAndServeTLS(stationTLSCert, stationTLSKey)
+	} else {
+		err = server.ListenAndServe()
+	}
+
+	if err != http.ErrServerClosed {
+		ctx.WithError(err).Fatal("running station server")
+	}
+
+	ctx.Info("stopped")
+}
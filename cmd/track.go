@@ -0,0 +1,150 @@
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"os"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-coverage/db"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/bullettime/lora-coverage/track"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	trackFormat      string
+	trackDevice      string
+	trackOffset      time.Duration
+	trackInterpolate bool
+	trackMaxGap      time.Duration
+	trackDryRun      bool
+)
+
+// trackCmd represents the track command
+var trackCmd = &cobra.Command{
+	Use:   "track",
+	Short: "Add locations from a separately logged GPS track",
+	Long: `lora-coverage track fills in the location of coverage rows without one from a GPS track.
+
+This command takes one argument:
+	- file name of the track, a gpx or csv file [eg. track.gpx]
+A csv file needs a header row with time, lat and lon columns. Times are RFC 3339 or unix
+timestamps.
+
+The rows are matched by time. Use --offset when the clock of the tracker is off, it is
+added to the track times. By default the position is interpolated between the surrounding
+track points, with --interpolate=false the nearest point is taken. Rows without track
+points within --max-gap are left alone. Matched rows are marked as "track-matched".`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := log.WithField("track-file", args[0])
+
+		file, err := os.Open(args[0])
+		if err != nil {
+			ctx.WithError(err).Fatal("opening track file (arg)")
+		}
+		defer file.Close()
+
+		points, err := track.Read(file, trackFormat, args[0])
+		if err != nil {
+			ctx.WithError(err).Fatal("reading track")
+		}
+
+		database, err := db.Open(viper.GetString("database.dbfile"))
+		if err != nil {
+			log.WithError(err).WithField("database", viper.GetString("database.dbfile")).Fatal("connecting to database")
+		}
+		defer database.Disconnect()
+
+		if err := database.Init(); err != nil {
+			log.WithError(err).Fatal("initializing database")
+		}
+
+		dbModel := model.New(database, modelConfig())
+
+		rows, err := dbModel.GetRowsWithoutLocation(trackDevice)
+		if err != nil {
+			log.WithError(err).Fatal("getting rows without location")
+		}
+
+		options := track.Options{
+			Offset:      trackOffset,
+			Interpolate: trackInterpolate,
+			MaxGap:      trackMaxGap,
+		}
+
+		var matched []*model.Location
+		for _, row := range rows {
+			lat, lon, ok := points.Position(row.Time, options)
+			if !ok {
+				continue
+			}
+
+			row.Latitude = lat
+			row.Longitude = lon
+			row.Source = model.LocationSourceTrack
+			matched = append(matched, row)
+
+			log.WithFields(log.Fields{
+				"device": row.Device,
+				"time":   row.Time,
+				"lat":    lat,
+				"lon":    lon,
+			}).Debug("matched row")
+		}
+
+		if !trackDryRun {
+			if err := dbModel.SetLocations(matched); err != nil {
+				log.WithError(err).Fatal("saving locations")
+			}
+		}
+
+		ctx.WithFields(log.Fields{
+			"points":  len(points),
+			"rows":    len(rows),
+			"matched": len(matched),
+			"dry-run": trackDryRun,
+		}).Info("done")
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(trackCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// trackCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// trackCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	trackCmd.Flags().StringVar(&trackFormat, "format", "", "format of the track: gpx or csv (default from the file extension)")
+	trackCmd.Flags().StringVar(&trackDevice, "device", "", "only match rows of this device address (in hex)")
+	trackCmd.Flags().DurationVar(&trackOffset, "offset", 0, "clock offset added to the track times (eg. -2s)")
+	trackCmd.Flags().BoolVar(&trackInterpolate, "interpolate", true, "interpolate between track points instead of taking the nearest")
+	trackCmd.Flags().DurationVar(&trackMaxGap, "max-gap", 30*time.Second, "maximum time to a track point (0 is no limit)")
+	trackCmd.Flags().BoolVar(&trackDryRun, "dry-run", false, "only report the number of matched rows")
+}
@@ -0,0 +1,147 @@
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-coverage/db"
+	"github.com/bullettime/lora-coverage/integration"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	webhookListen       string
+	webhookSecret       string
+	webhookSecretHeader string
+	webhookTLSCert      string
+	webhookTLSKey       string
+	webhookUseDecoded   bool
+)
+
+// webhookCmd represents the webhook command
+var webhookCmd = &cobra.Command{
+	Use:   "webhook",
+	Short: "Add data from network server uplinks received over HTTP",
+	Long: `lora-coverage webhook runs an HTTP server that receives uplinks from the HTTP integration of a network server.
+
+Configure the network server to post uplinks to:
+	- /chirpstack for the ChirpStack HTTP integration
+	- /tts for a The Things Stack webhook (uplink message)
+With --secret every request has to carry the secret in the --secret-header header.
+Invalid requests are answered with a 4xx status, a 5xx status is returned when the data
+could not be stored so the network server retries later.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		database, err := db.Open(viper.GetString("database.dbfile"))
+		if err != nil {
+			log.WithError(err).WithField("database", viper.GetString("database.dbfile")).Fatal("connecting to database")
+		}
+		defer database.Disconnect()
+
+		if err := database.Init(); err != nil {
+			log.WithError(err).Fatal("initializing database")
+		}
+
+		dbModel := model.New(database, modelConfig())
+
+		runWebhookServer(dbModel)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(webhookCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// webhookCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// webhookCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	webhookCmd.Flags().StringVarP(&webhookListen, "listen", "l", ":8080", "address to listen on")
+	webhookCmd.Flags().StringVar(&webhookSecret, "secret", "", "shared secret that requests have to carry")
+	webhookCmd.Flags().StringVar(&webhookSecretHeader, "secret-header", "X-Webhook-Secret", "header that contains the shared secret")
+	webhookCmd.Flags().StringVar(&webhookTLSCert, "tls-cert", "", "certificate file to serve https")
+	webhookCmd.Flags().StringVar(&webhookTLSKey, "tls-key", "", "key file to serve https")
+	webhookCmd.Flags().BoolVar(&webhookUseDecoded, "use-decoded", false, "use the payload decoded by the network server")
+}
+
+func runWebhookServer(dbModel *model.Model) {
+	ctx := log.WithField("listen", webhookListen)
+
+	mux := http.NewServeMux()
+	for path, format := range map[string]integration.Format{
+		"/chirpstack": integration.ChirpStack,
+		"/tts":        integration.TheThingsStack,
+	} {
+		mux.Handle(path, &integration.WebhookHandler{
+			Format:       format,
+			Store:        dbModel,
+			UseDecoded:   webhookUseDecoded,
+			SecretHeader: webhookSecretHeader,
+			Secret:       webhookSecret,
+		})
+	}
+
+	server := &http.Server{
+		Addr:         webhookListen,
+		Handler:      mux,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	go func() {
+		<-stop
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	ctx.Info("listening for webhooks")
+
+	var err error
+	if len(webhookTLSCert) > 0 {
+		err = server.ListenAndServeTLS(webhookTLSCert, webhookTLSKey)
+	} else {
+		err = server.ListenAndServe()
+	}
+
+	if err != http.ErrServerClosed {
+		ctx.WithError(err).Fatal("running webhook server")
+	}
+
+	ctx.Info("stopped")
+}
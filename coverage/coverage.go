@@ -0,0 +1,75 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package coverage is the library API of lora-coverage: lora-logger files are ingested
+// into a coverage database, which is queried for the coverage rows.
+//
+//	db, err := coverage.Open("coverage.db", model.Config{Plan: plan, Keys: keys})
+//	if err != nil {
+//		...
+//	}
+//	defer db.Close()
+//
+//	stats, err := coverage.Ingest(ctx, file, coverage.Options{Model: db.Model()})
+//	...
+//	rows, err := db.Rows(ctx, coverage.Query{Device: "26011b2c"})
+package coverage
+
+import (
+	"github.com/bullettime/lora-coverage/db"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/pkg/errors"
+)
+
+// DB is a Store in a sqlite database, its Model is the destination of Ingest.
+type DB struct {
+	connection *db.Connection
+	model      *model.Model
+}
+
+var _ Store = (*DB)(nil)
+
+// Open opens the sqlite database with the data source name (the file name of the
+// database or a file: URI), the tables are created or upgraded when needed.
+func Open(dsn string, config model.Config) (*DB, error) {
+	connection, err := db.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := connection.Init(); err != nil {
+		connection.Disconnect()
+		return nil, errors.Wrap(err, "error initializing database")
+	}
+
+	return &DB{connection: connection, model: model.New(connection, config)}, nil
+}
+
+// Model returns the model of the database, it stores the ingested data.
+func (d *DB) Model() *model.Model {
+	return d.model
+}
+
+// Close closes the database.
+func (d *DB) Close() error {
+	return d.connection.Disconnect()
+}
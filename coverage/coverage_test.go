@@ -0,0 +1,323 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package coverage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/bullettime/lora-coverage/region"
+)
+
+// openTestDB returns a database in a temporary directory with test.json ingested,
+// see make fixture.
+func openTestDB(t *testing.T) (*DB, string, func()) {
+	dir, err := ioutil.TempDir("", "lora-coverage")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := region.Get("eu868")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "000102030405060708090a0b0c0d0e0f"
+	config := model.Config{Plan: plan, Keys: &model.KeyStore{Default: model.KeySettings{NwkSKey: key, AppSKey: key}}}
+
+	dsn := filepath.Join(dir, "coverage.db")
+	db, err := Open(dsn, config)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+
+	file, err := os.Open("../test.json")
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	stats, err := Ingest(context.Background(), file, Options{Model: db.Model()})
+	if err != nil {
+		cleanup()
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.Lines != 59 || stats.Errors != 0 {
+		t.Errorf("wrong stats: %+v", stats)
+	}
+
+	return db, dsn, cleanup
+}
+
+func collect(t *testing.T, db *DB, query Query) []*model.Coverage {
+	rows, err := db.Rows(context.Background(), query)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer rows.Close()
+
+	var result []*model.Coverage
+	for rows.Next() {
+		result = append(result, rows.Row())
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return result
+}
+
+func TestIngestAndQuery(t *testing.T) {
+	db, _, cleanup := openTestDB(t)
+	defer cleanup()
+
+	all := collect(t, db, Query{})
+	if len(all) != 58 {
+		t.Fatalf("expected 58 rows, got %d", len(all))
+	}
+	for i, row := range all[1:] {
+		if time.Time(row.Time).Before(time.Time(all[i].Time)) {
+			t.Fatalf("rows out of order: %v before %v", row.Time, all[i].Time)
+		}
+	}
+	if row := all[0]; row.DeviceAddr.String() != "26011b2c" || row.Region != "EU868" || row.FCnt == nil ||
+		row.Power != 14 || row.Latitude == 0 {
+		t.Errorf("wrong row: %+v", row)
+	}
+
+	gateway := collect(t, db, Query{Gateway: "AA555A0000000101"})
+	if len(gateway) == 0 || len(gateway) == len(all) {
+		t.Errorf("wrong number of rows of the gateway: %d", len(gateway))
+	}
+	for _, row := range gateway {
+		if row.GatewayMac.String() != "aa555a0000000101" {
+			t.Errorf("row of another gateway: %+v", row)
+		}
+	}
+
+	from := time.Date(2018, 4, 5, 21, 5, 0, 0, time.UTC)
+	to := from.Add(5 * time.Minute)
+	window := collect(t, db, Query{Device: "26011b2c", DataRate: "sf7bw125", From: from, To: to})
+	if len(window) == 0 || len(window) == len(all) {
+		t.Errorf("wrong number of rows in the window: %d", len(window))
+	}
+	for _, row := range window {
+		if ts := time.Time(row.Time); ts.Before(from) || !ts.Before(to) {
+			t.Errorf("row outside of the window: %v", row.Time)
+		}
+	}
+
+	if rows := collect(t, db, Query{Device: "26011b2d"}); len(rows) != 0 {
+		t.Errorf("expected no rows of an unknown device, got %d", len(rows))
+	}
+
+	gateways, err := db.Gateways(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(gateways) != 2 || gateways[0].String() != "aa555a0000000101" || gateways[1].String() != "aa555a0000000102" {
+		t.Errorf("wrong gateways: %v", gateways)
+	}
+
+	devices, err := db.Devices(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(devices) != 1 || devices[0].String() != "26011b2c" {
+		t.Errorf("wrong devices: %v", devices)
+	}
+}
+
+func TestIngestErrors(t *testing.T) {
+	db, _, cleanup := openTestDB(t)
+	defer cleanup()
+
+	input := "not json\n\n" + `{"fields":{"crc":1,"data":"!"},"message":"PUSH_DATA: RXPK"}` + "\n"
+
+	var lineNumbers []int
+	stats, err := Ingest(context.Background(), strings.NewReader(input), Options{
+		Model: db.Model(),
+		OnError: func(lineNumber int, line []byte, err error) error {
+			lineNumbers = append(lineNumbers, lineNumber)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.Lines != 3 || stats.Errors != 2 || len(lineNumbers) != 2 || lineNumbers[0] != 1 || lineNumbers[1] != 3 {
+		t.Errorf("wrong stats: %+v %v", stats, lineNumbers)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	db, _, cleanup := openTestDB(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := Ingest(ctx, strings.NewReader("\n"), Options{Model: db.Model()}); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	rows, err := db.Rows(ctx, Query{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		if n++; n == 3 {
+			cancel()
+		}
+	}
+	if n != 3 || rows.Err() != context.Canceled {
+		t.Errorf("expected the iteration to stop, got %d rows and error %v", n, rows.Err())
+	}
+}
+
+func TestRowOrder(t *testing.T) {
+	db, dsn, cleanup := openTestDB(t)
+	defer cleanup()
+
+	database, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	// the fractional seconds are trimmed, ordered as text the times would be reversed
+	times := []string{"2018-04-05T20:00:00.5Z", "2018-04-05T20:00:00.25Z", "2018-04-05T20:00:00Z"}
+	for i, ts := range times {
+		if _, err := database.Exec(`UPDATE coverage SET time=? WHERE rowid=(SELECT rowid FROM coverage
+WHERE gateway='aa555a0000000101' ORDER BY rowid LIMIT 1 OFFSET ?)`, ts, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(name string, rows []*model.Coverage) {
+		if len(rows) < len(times) {
+			t.Fatalf("%s: expected at least %d rows, got %d", name, len(times), len(rows))
+		}
+		for i, row := range rows[:len(times)] {
+			if expected := times[len(times)-1-i]; row.Time.String() != expected {
+				t.Errorf("%s: expected row %d at %s, got %s", name, i, expected, row.Time)
+			}
+		}
+	}
+
+	from := time.Date(2018, 4, 5, 20, 0, 0, 0, time.UTC)
+	check("query", collect(t, db, Query{From: from, To: from.Add(time.Second)}))
+
+	rows, err := db.Model().GetCoverageRows("aa555a0000000101", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("gateway", rows)
+}
+
+func TestFrameIndex(t *testing.T) {
+	_, dsn, cleanup := openTestDB(t)
+	defer cleanup()
+
+	database, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	// the duplicate check of every new row must not scan all rows of the device
+	rows, err := database.Query(`EXPLAIN QUERY PLAN SELECT time FROM coverage WHERE gateway=? AND device=?
+AND fcnt=? AND mic=?`, "aa555a0000000101", "26011b2c", 1, "00000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var plan []string
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+			t.Fatal(err)
+		}
+		plan = append(plan, detail)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(strings.Join(plan, "\n"), "INDEX coverage_frame (gateway=? AND device=? AND fcnt=? AND mic=?)") {
+		t.Errorf("expected the frame index to be used, got plan: %v", plan)
+	}
+}
+
+func TestIngestLinkCheckAns(t *testing.T) {
+	db, dsn, cleanup := openTestDB(t)
+	defer cleanup()
+
+	// LinkCheckAns (margin 20, 3 gateways) in the FOpts of a downlink
+	phy := []byte{0x60, 0x2c, 0x1b, 0x01, 0x26, 0x03, 0x07, 0x00, 0x02, 0x14, 0x03, 0x01, 0x02, 0x03, 0x04}
+	line := `{"fields":{"gateway mac":"aa555a0000000101","token":1,"timestamp":956576768,"frequency":869.525,` +
+		`"modulation":"LORA","data rate":"SF9BW125","coding rate":"4/5","size":15,"data":"` +
+		base64.StdEncoding.EncodeToString(phy) + `"},"level":"info","timestamp":"2018-04-05T21:00:01Z",` +
+		`"message":"PULL_RESP: TXPK"}`
+
+	if err := AddLine(db.Model(), []byte(line)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	database, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	var name string
+	var uplink bool
+	var margin, count int
+	if err := database.QueryRow("SELECT name, uplink, margin, gateway_count FROM mac_command WHERE device = '26011b2c' "+
+		"AND time = '2018-04-05T21:00:01Z'").Scan(&name, &uplink, &margin, &count); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if name != "LinkCheckAns" || uplink || margin != 20 || count != 3 {
+		t.Errorf("wrong mac command: %s %v %d %d", name, uplink, margin, count)
+	}
+}
@@ -0,0 +1,279 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package coverage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/pkg/errors"
+)
+
+// Options configure Ingest.
+type Options struct {
+	// Model stores the ingested data, see DB.Model.
+	Model *model.Model
+	// OnError is called with the lines that can not be parsed (the line number starts
+	// at 1), Ingest stops with the error it returns. The lines are only logged when it
+	// is nil.
+	OnError func(lineNumber int, line []byte, err error) error
+}
+
+// Stats are the number of lines that were read by Ingest and the number of them that
+// could not be parsed.
+type Stats struct {
+	Lines  int
+	Errors int
+}
+
+type logMessage struct {
+	Fields    json.RawMessage `json:"fields"`
+	Level     string          `json:"level"`
+	TimeStamp string          `json:"timestamp"`
+	Message   string          `json:"message"`
+}
+
+// Ingest adds the lines of a lora-logger file read from r, see AddLine. It stops when
+// the context is cancelled, the stats of the lines read until then are returned with
+// the error of the context.
+func Ingest(ctx context.Context, r io.Reader, opts Options) (*Stats, error) {
+	if opts.Model == nil {
+		return nil, errors.New("no model to ingest into")
+	}
+
+	stats := &Stats{}
+	reader := bufio.NewReader(r)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			stats.Lines++
+			if lineErr := AddLine(opts.Model, line); lineErr != nil {
+				stats.Errors++
+				if opts.OnError != nil {
+					if err := opts.OnError(stats.Lines, bytes.TrimRight(line, "\r\n"), lineErr); err != nil {
+						return stats, err
+					}
+				} else {
+					log.WithError(lineErr).WithField("line-number", stats.Lines).Error("processing line")
+				}
+			}
+		}
+
+		if err == io.EOF {
+			return stats, nil
+		}
+		if err != nil {
+			return stats, errors.Wrapf(err, "error reading line %d", stats.Lines+1)
+		}
+	}
+}
+
+// AddLine adds the rx packet, or the downlink, in a lora-logger line to the model.
+// Blank lines are skipped and an error is returned for lines that can not be parsed,
+// packets that are rejected on purpose (crc, mic, ...) are only logged.
+func AddLine(m *model.Model, line []byte) error {
+	line = bytes.TrimRight(line, "\r\n")
+	if len(bytes.TrimSpace(line)) == 0 {
+		return nil
+	}
+
+	var message logMessage
+
+	err := json.Unmarshal(line, &message)
+	if err != nil {
+		return errors.Wrap(err, "error unmarshalling line")
+	}
+
+	switch message.Message {
+	case "PUSH_DATA: RXPK":
+	case "PULL_RESP: TXPK":
+		return addTxPacket(&message, m)
+	case "TX_ACK":
+		return addTxAck(&message, m)
+	default:
+		return nil
+	}
+
+	var packet model.RxPacket
+
+	if err := json.Unmarshal(message.Fields, &packet); err != nil {
+		return errors.Wrap(err, "error unmarshalling fields")
+	}
+	packet.Raw = message.Fields
+
+	return AddRxPacket(m, &packet)
+}
+
+// addTxPacket adds the downlink of a PULL_RESP, the time of the log message is the
+// time the gateway was asked to send it. Join accepts are skipped.
+func addTxPacket(message *logMessage, m *model.Model) error {
+	var packet model.TxPacket
+
+	if err := json.Unmarshal(message.Fields, &packet); err != nil {
+		return errors.Wrap(err, "error unmarshalling fields")
+	}
+
+	sent, err := time.Parse(time.RFC3339Nano, message.TimeStamp)
+	if err != nil {
+		return errors.Wrapf(err, "invalid timestamp: %s", message.TimeStamp)
+	}
+
+	ctx := log.WithField("fields", string(message.Fields))
+
+	downlink := model.Downlink{Raw: message.Fields}
+	if err := downlink.FromTxPacket(&packet, sent); err != nil {
+		if err == model.NotDataFrameError {
+			ctx.Debug("skipping join accept")
+			return nil
+		}
+		return errors.Wrap(err, "error processing tx packet")
+	}
+
+	keys, err := m.DeviceKeys(downlink.DeviceAddr)
+	if err != nil {
+		ctx.WithError(err).Debug("decoding mac commands without keys")
+	}
+	if err := downlink.SetMACCommands(keys); err != nil {
+		ctx.WithError(err).Warn("decoding mac commands")
+	}
+
+	err = m.AddDownlink(&downlink)
+	if errors.Cause(err) == model.DuplicateRowError {
+		ctx.Debug("skipping duplicate downlink")
+	} else if err != nil {
+		ctx.WithError(err).Warn("storing downlink")
+	}
+
+	return nil
+}
+
+// addTxAck stores whether the gateway could schedule the downlink of a PULL_RESP.
+func addTxAck(message *logMessage, m *model.Model) error {
+	var ack model.TxAck
+
+	if err := json.Unmarshal(message.Fields, &ack); err != nil {
+		return errors.Wrap(err, "error unmarshalling fields")
+	}
+
+	// protocol version 1 acknowledges without error field
+	if ack.Error == "" {
+		ack.Error = model.TxAckOK
+	}
+
+	if err := m.SetTxAck(&ack); err != nil {
+		log.WithError(err).WithField("fields", string(message.Fields)).Debug("storing tx ack")
+	}
+
+	return nil
+}
+
+// AddRxPacket adds a received packet to the model, packets that are rejected on
+// purpose (crc, mic, ...) are only logged. The complete record of every packet is
+// kept in the reception table, or the crc_error table for packets with an invalid crc.
+func AddRxPacket(m *model.Model, packet *model.RxPacket) error {
+	var row model.Coverage
+
+	ctx := log.WithField("fields", string(packet.Raw))
+
+	addReception(m, packet, ctx)
+	if err := m.SetDeviceKeys(packet); err != nil {
+		return errors.Wrap(err, "error processing rx packet")
+	}
+	setConfFCnt(m, packet, ctx)
+	if err := m.SetFCntCandidates(packet); err != nil {
+		ctx.WithError(err).Warn("looking up frame counter")
+	}
+
+	if err := row.FromRxPacket(packet); err != nil {
+		switch err {
+		case model.InvalidCrcError:
+			ctx.Debug(model.InvalidCrcError.Error())
+		case model.InvalidMicError:
+			ctx.Debug(model.InvalidMicError.Error())
+		case model.InvalidMacPayloadError:
+			ctx.Debug(model.InvalidMacPayloadError.Error())
+		case model.InvalidFramePayloadError:
+			ctx.Debug(model.InvalidFramePayloadError.Error())
+		case model.InvalidPayloadError:
+			ctx.Warn("invalid payload (no location data and/or power)")
+			addCoverageRow(m, &row)
+		default:
+			return errors.Wrap(err, "error processing rx packet")
+		}
+	} else {
+		addCoverageRow(m, &row)
+	}
+
+	return nil
+}
+
+// setConfFCnt looks up the downlink acknowledged by the packet, the MIC of LoRaWAN 1.1
+// devices depends on it.
+func setConfFCnt(m *model.Model, packet *model.RxPacket, ctx log.Interface) {
+	header, err := packet.FrameHeader()
+	if err != nil || !header.Uplink() || !header.FCtrl.ACK {
+		return
+	}
+
+	if packet.Keys == nil || packet.Keys.Version != model.LoRaWAN11 {
+		return
+	}
+
+	packet.ConfFCnt, err = m.ConfFCnt(header.DevAddr, time.Time(packet.Time))
+	if err != nil {
+		ctx.WithError(err).Warn("looking up acknowledged downlink")
+	}
+}
+
+func addReception(m *model.Model, packet *model.RxPacket, ctx log.Interface) {
+	var err error
+	if packet.Crc < 0 {
+		err = m.AddCrcError(packet)
+	} else {
+		err = m.AddReception(packet)
+	}
+
+	if errors.Cause(err) == model.DuplicateRowError {
+		ctx.Debug("skipping duplicate reception")
+	} else if err != nil {
+		ctx.WithError(err).Warn("storing reception")
+	}
+}
+
+func addCoverageRow(m *model.Model, row *model.Coverage) {
+	err := m.AddCoverageRow(row)
+	if err != nil {
+		log.WithError(err).Warn("Did you already scan this file?")
+	}
+}
@@ -0,0 +1,126 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package coverage
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/brocaar/lorawan"
+	"github.com/bullettime/lora-coverage/db"
+	"github.com/bullettime/lora-coverage/model"
+)
+
+// Query selects coverage rows, the empty fields match all rows.
+type Query struct {
+	// Gateway is the mac of the gateway, eg. 0102030405060708.
+	Gateway string
+	// Device is the address of the device, eg. 26011b2c.
+	Device string
+	// DataRate is the data rate as the packet forwarder reports it, eg. SF7BW125, or
+	// the bit rate of FSK, eg. 50000.
+	DataRate string
+	// From and To select the rows received at or after From and before To.
+	From time.Time
+	To   time.Time
+}
+
+// Store is a coverage database. The queries are cancelled with their context, for
+// the rows that is the iteration as well.
+type Store interface {
+	// Rows returns the coverage rows that match the query in the order they were
+	// received. The MAC commands of the rows are not included.
+	Rows(ctx context.Context, query Query) (*Rows, error)
+	// Gateways returns the gateways that received uplinks.
+	Gateways(ctx context.Context) ([]model.MacAddress, error)
+	// Devices returns the devices that sent uplinks.
+	Devices(ctx context.Context) ([]lorawan.DevAddr, error)
+}
+
+// Rows is an iterator over coverage rows, the rows are read from the database while
+// they are iterated:
+//
+//	rows, err := store.Rows(ctx, query)
+//	if err != nil {
+//		...
+//	}
+//	defer rows.Close()
+//
+//	for rows.Next() {
+//		row := rows.Row()
+//		...
+//	}
+//	if err := rows.Err(); err != nil {
+//		...
+//	}
+type Rows struct {
+	rows *db.CoverageRows
+}
+
+// Next reads the next row, false when there are no more rows, when reading failed
+// or when the context of the query is cancelled (see Err).
+func (r *Rows) Next() bool {
+	return r.rows.Next()
+}
+
+// Row returns the row read by Next.
+func (r *Rows) Row() *model.Coverage {
+	return r.rows.Row()
+}
+
+// Err returns the error that stopped the iteration, nil when all rows were read.
+func (r *Rows) Err() error {
+	return r.rows.Err()
+}
+
+// Close stops the iteration, it is safe to call it more than once.
+func (r *Rows) Close() error {
+	return r.rows.Close()
+}
+
+// Rows returns the coverage rows that match the query in the order they were received.
+func (d *DB) Rows(ctx context.Context, query Query) (*Rows, error) {
+	rows, err := d.connection.QueryCoverageRows(ctx, db.CoverageFilter{
+		Gateway:  strings.ToLower(query.Gateway),
+		Device:   strings.ToLower(query.Device),
+		DataRate: strings.ToUpper(query.DataRate),
+		From:     query.From,
+		To:       query.To,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Rows{rows: rows}, nil
+}
+
+// Gateways returns the gateways that received uplinks.
+func (d *DB) Gateways(ctx context.Context) ([]model.MacAddress, error) {
+	return d.connection.GetGateways(ctx)
+}
+
+// Devices returns the devices that sent uplinks.
+func (d *DB) Devices(ctx context.Context) ([]lorawan.DevAddr, error) {
+	return d.connection.GetDevices(ctx)
+}
@@ -23,8 +23,11 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"time"
 
+	"github.com/brocaar/lorawan"
 	"github.com/bullettime/lora-coverage/model"
 	"github.com/pkg/errors"
 	"github.com/paulmach/go.geojson"
@@ -44,29 +47,145 @@ size INTEGER NOT NULL,
 payload TEXT NOT NULL,
 lat REAL,
 lon REAL,
+location_source TEXT,
+region TEXT,
+channel INTEGER,
+datarate_index INTEGER,
+modulation TEXT,
+spreading_factor INTEGER,
+bandwidth INTEGER,
+bitrate INTEGER,
+sensitivity REAL,
+max_eirp REAL,
+coding_rate TEXT,
+airtime REAL,
+mtype TEXT,
+fcnt INTEGER,
+fport INTEGER,
+adr INTEGER,
+adr_ack_req INTEGER,
+ack INTEGER,
+fopts TEXT,
+mic TEXT,
 create_time TEXT DEFAULT CURRENT_TIMESTAMP,
 UNIQUE(gateway, device, time, payload))`
+	// createCoverageFrameIndex speeds up the duplicate check that runs for every new row
+	createCoverageFrameIndex = `CREATE INDEX IF NOT EXISTS coverage_frame ON coverage(gateway, device, fcnt, mic)`
 	addCoverageRow = `INSERT INTO coverage(gateway, device, time, frequency, datarate, power, rssi, snr, size, 
-payload, lat, lon) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-	getGeoJsonPoints = `SELECT rssi, lat, lon FROM coverage WHERE gateway=? AND datarate=?`
+payload, lat, lon, location_source, region, channel, datarate_index, modulation, spreading_factor, bandwidth, bitrate,
+sensitivity, max_eirp, coding_rate, airtime, mtype, fcnt, fport, adr, adr_ack_req, ack, fopts, mic)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	coverageRowColumns = `gateway, device, time, frequency, datarate, power, rssi, snr, size, payload, lat, lon,
+location_source, region, channel, datarate_index, modulation, spreading_factor, bandwidth, bitrate, sensitivity,
+max_eirp, coding_rate, airtime, mtype, fcnt, fport, adr, adr_ack_req, ack, fopts, mic`
+	getCoverageRows = `SELECT ` + coverageRowColumns + ` FROM coverage WHERE (? = '' OR gateway = ?) AND (? = '' OR datarate = ?) ORDER BY gateway, julianday(time)`
+	queryCoverageRows = `SELECT ` + coverageRowColumns + ` FROM coverage WHERE (? = '' OR gateway = ?) AND (? = '' OR device = ?)
+AND (? = '' OR datarate = ?) AND (? = '' OR julianday(time) >= julianday(?)) AND (? = '' OR julianday(time) < julianday(?))
+ORDER BY julianday(time)`
+	getGateways = `SELECT DISTINCT gateway FROM coverage ORDER BY gateway`
+	getDevices = `SELECT DISTINCT device FROM coverage ORDER BY device`
+	getFrameTimes = `SELECT time FROM coverage WHERE gateway=? AND device=? AND fcnt=? AND mic=?`
+	getGeoJsonPoints = `SELECT rssi, lat, lon FROM coverage WHERE gateway=? AND datarate=? AND lat IS NOT NULL AND lon IS NOT NULL ORDER BY julianday(time)`
 )
 
+// coverageColumns are the columns that were added to the coverage table after the
+// first release, they are added to older databases.
+var coverageColumns = []struct {
+	name       string
+	definition string
+}{
+	{"location_source", "TEXT"},
+	{"region", "TEXT"},
+	{"channel", "INTEGER"},
+	{"datarate_index", "INTEGER"},
+	{"spreading_factor", "INTEGER"},
+	{"bandwidth", "INTEGER"},
+	{"sensitivity", "REAL"},
+	{"max_eirp", "REAL"},
+	{"coding_rate", "TEXT"},
+	{"airtime", "REAL"},
+	{"mtype", "TEXT"},
+	{"fcnt", "INTEGER"},
+	{"fport", "INTEGER"},
+	{"adr", "INTEGER"},
+	{"adr_ack_req", "INTEGER"},
+	{"ack", "INTEGER"},
+	{"fopts", "TEXT"},
+	{"mic", "TEXT"},
+	{"modulation", "TEXT"},
+	{"bitrate", "INTEGER"},
+}
+
+// repairFSKDataRates repairs the FSK data rates of older versions, which stored the
+// bit rate as a single character, and sets the modulation and bit rate of the rows
+// that were added before those columns.
+const repairFSKDataRates = `UPDATE coverage SET datarate = CAST(unicode(datarate) AS TEXT) WHERE length(datarate) = 1;
+UPDATE coverage SET modulation = CASE WHEN datarate GLOB '[0-9]*' THEN 'FSK' ELSE 'LORA' END,
+bitrate = CASE WHEN datarate GLOB '[0-9]*' THEN CAST(datarate AS INTEGER) END WHERE modulation IS NULL`
+
 func (c *Connection) initCoverage() error {
 	_, err := c.database.Exec(createCoverageTable)
 	if err != nil {
 		return errors.Wrap(err, "error initializing table 'coverage'")
 	}
+
+	for _, column := range coverageColumns {
+		if err := c.addColumnIfMissing("coverage", column.name, column.definition); err != nil {
+			return err
+		}
+	}
+
+	if _, err := c.database.Exec(repairFSKDataRates); err != nil {
+		return errors.Wrap(err, "error repairing fsk data rates")
+	}
+
+	// after the columns were added, the index needs fcnt and mic
+	if _, err := c.database.Exec(createCoverageFrameIndex); err != nil {
+		return errors.Wrap(err, "error creating index 'coverage_frame'")
+	}
+
 	return nil
 }
 
+// AddCoverageRow adds the row and the MAC commands of its frame header.
 func (c *Connection) AddCoverageRow(m *model.Coverage) error {
 	power := getNullPower(m.Power)
 	latitude := getNullLatLon(m.Latitude)
 	longitude := getNullLatLon(m.Longitude)
-	_, err := c.database.Exec(addCoverageRow, m.GatewayMac.String(), m.DeviceAddr.String(), m.Time.String(),
-		m.Frequency, m.DataRate.String(), power, m.RSSI, m.SNR, m.Size, m.Payload, latitude, longitude)
+	source := getNullString(m.LocationSource)
+	annotated := m.Region != ""
+	header := m.MType != ""
+
+	tx, err := c.database.Begin()
 	if err != nil {
-		return errors.Wrapf(err, "error adding coverage row: %s", m)
+		return errors.Wrap(err, "error starting transaction")
+	}
+
+	_, err = tx.Exec(addCoverageRow, m.GatewayMac.String(), m.DeviceAddr.String(), m.Time.String(),
+		m.Frequency, m.DataRate.String(), power, m.RSSI, m.SNR, m.Size, m.Payload, latitude, longitude, source,
+		getNullString(m.Region), getNullInt(m.Channel, annotated && m.Channel >= 0),
+		getNullInt(m.DataRateIndex, annotated && m.DataRateIndex >= 0), getNullString(m.Modulation),
+		getNullInt(m.SpreadingFactor, m.SpreadingFactor > 0), getNullInt(m.Bandwidth, m.Bandwidth > 0),
+		getNullInt(m.BitRate, m.BitRate > 0), getNullFloat(m.Sensitivity, annotated), getNullFloat(m.MaxEIRP, annotated), getNullString(m.CodingRate),
+		getNullFloat(m.Airtime.Seconds()*1000, m.Airtime > 0), getNullString(m.MType), getNullUint32(m.FCnt),
+		getNullUint8(m.FPort), getNullBool(m.FCtrl.ADR, header), getNullBool(m.FCtrl.ADRACKReq, header),
+		getNullBool(m.FCtrl.ACK, header), getNullString(m.FOpts), getNullString(m.MIC))
+	if isUniqueConstraintError(err) {
+		err = model.DuplicateRowError
+	}
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrapf(err, "error adding coverage row: %s %s %s", m.GatewayMac, m.DeviceAddr, m.Time)
+	}
+
+	if err := addMACCommands(tx, m.GatewayMac.String(), m.DeviceAddr.String(), m.Time.String(),
+		getNullUint32(m.FCnt), m.MACCommands); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "error committing coverage row")
 	}
 
 	return nil
@@ -82,8 +201,47 @@ func getNullLatLon(value float64) sql.NullFloat64 {
 	}
 }
 
+func getNullString(value string) sql.NullString {
+	return sql.NullString{
+		String: value,
+		Valid:  value != "",
+	}
+}
+
+func getNullInt(value int, valid bool) sql.NullInt64 {
+	return sql.NullInt64{
+		Int64: int64(value),
+		Valid: valid,
+	}
+}
+
+func getNullFloat(value float64, valid bool) sql.NullFloat64 {
+	return sql.NullFloat64{
+		Float64: value,
+		Valid:   valid,
+	}
+}
+
+func getNullUint32(value *uint32) sql.NullInt64 {
+	if value == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(*value), Valid: true}
+}
+
+func getNullUint8(value *uint8) sql.NullInt64 {
+	if value == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(*value), Valid: true}
+}
+
+func getNullBool(value bool, valid bool) sql.NullBool {
+	return sql.NullBool{Bool: value, Valid: valid}
+}
+
 func getNullPower(value int8) sql.NullInt64 {
-	if value == 127 {
+	if value == model.UnknownPower {
 		return sql.NullInt64{}
 	}
 	return sql.NullInt64{
@@ -121,3 +279,274 @@ func (c *Connection) GetGeoJSonPoints(gateway string, datarate string) ([]*geojs
 
 	return points, nil
 }
+
+// GetCoverageRows returns the rows of a gateway with a data rate, an empty gateway or
+// data rate matches all of them.
+func (c *Connection) GetCoverageRows(gateway string, datarate string) ([]*model.Coverage, error) {
+	var coverage []*model.Coverage
+
+	rows, err := c.database.Query(getCoverageRows, gateway, gateway, datarate, datarate)
+	if err != nil {
+		return nil, errors.Wrap(err, "error retrieving coverage rows")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		m, err := scanCoverageRow(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		coverage = append(coverage, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error in rows")
+	}
+
+	return coverage, nil
+}
+
+// CoverageFilter selects coverage rows, the empty fields match all rows.
+type CoverageFilter struct {
+	Gateway  string
+	Device   string
+	DataRate string
+	// From and To select the rows received at or after From and before To.
+	From time.Time
+	To   time.Time
+}
+
+// CoverageRows is an iterator over the result of QueryCoverageRows, the rows are
+// scanned as they are iterated.
+type CoverageRows struct {
+	ctx  context.Context
+	rows *sql.Rows
+	row  *model.Coverage
+	err  error
+}
+
+// QueryCoverageRows returns the rows that match the filter in the order they were
+// received. The query is cancelled with the context.
+func (c *Connection) QueryCoverageRows(ctx context.Context, filter CoverageFilter) (*CoverageRows, error) {
+	from, to := filterTime(filter.From), filterTime(filter.To)
+
+	rows, err := c.database.QueryContext(ctx, queryCoverageRows, filter.Gateway, filter.Gateway, filter.Device,
+		filter.Device, filter.DataRate, filter.DataRate, from, from, to, to)
+	if err != nil {
+		return nil, errors.Wrap(err, "error retrieving coverage rows")
+	}
+
+	return &CoverageRows{ctx: ctx, rows: rows}, nil
+}
+
+// filterTime returns the time as it is stored, empty for the zero time.
+func filterTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return model.CompactTime(t).String()
+}
+
+// Next scans the next row, false when there are no more rows, on errors and once the
+// context of the query is cancelled.
+func (r *CoverageRows) Next() bool {
+	if r.err != nil {
+		return false
+	}
+	// database/sql closes the rows in the background, stop right away
+	if r.err = r.ctx.Err(); r.err != nil {
+		r.rows.Close()
+		return false
+	}
+	if !r.rows.Next() {
+		return false
+	}
+
+	r.row, r.err = scanCoverageRow(r.rows)
+	return r.err == nil
+}
+
+// Row returns the row scanned by Next.
+func (r *CoverageRows) Row() *model.Coverage {
+	return r.row
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (r *CoverageRows) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	if err := r.rows.Err(); err != nil {
+		return errors.Wrap(err, "error in rows")
+	}
+	return nil
+}
+
+// Close stops the iteration, it is safe to call it more than once.
+func (r *CoverageRows) Close() error {
+	return r.rows.Close()
+}
+
+// scanCoverageRow scans a row of coverageRowColumns.
+func scanCoverageRow(rows *sql.Rows) (*model.Coverage, error) {
+	var m model.Coverage
+	var gatewayMac, device, t, dataRate string
+	var power, channel, dataRateIndex, spreadingFactor, bandwidth, bitRate sql.NullInt64
+	var lat, lon, sensitivity, maxEIRP, airtime sql.NullFloat64
+	var source, region, modulation, codingRate, mType, fOpts, mic sql.NullString
+	var fCnt, fPort sql.NullInt64
+	var adr, adrAckReq, ack sql.NullBool
+
+	if err := rows.Scan(&gatewayMac, &device, &t, &m.Frequency, &dataRate, &power, &m.RSSI, &m.SNR, &m.Size,
+		&m.Payload, &lat, &lon, &source, &region, &channel, &dataRateIndex, &modulation, &spreadingFactor,
+		&bandwidth, &bitRate, &sensitivity, &maxEIRP, &codingRate, &airtime, &mType, &fCnt, &fPort,
+		&adr, &adrAckReq, &ack, &fOpts, &mic); err != nil {
+		return nil, errors.Wrap(err, "error scanning row")
+	}
+
+	if err := m.GatewayMac.UnmarshalText([]byte(gatewayMac)); err != nil {
+		return nil, errors.Wrapf(err, "invalid gateway: %s", gatewayMac)
+	}
+	if err := m.DeviceAddr.UnmarshalText([]byte(device)); err != nil {
+		return nil, errors.Wrapf(err, "invalid device: %s", device)
+	}
+	if err := m.DataRate.UnmarshalText([]byte(dataRate)); err != nil {
+		return nil, errors.Wrapf(err, "invalid data rate: %s", dataRate)
+	}
+
+	ts, err := time.Parse(time.RFC3339Nano, t)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid time: %s", t)
+	}
+	m.Time = model.CompactTime(ts)
+
+	m.Power = model.UnknownPower
+	if power.Valid {
+		m.Power = int8(power.Int64)
+	}
+	m.Latitude = lat.Float64
+	m.Longitude = lon.Float64
+	m.LocationSource = source.String
+
+	// unknown channels and data rate indexes of annotated rows are stored as NULL
+	m.Region = region.String
+	m.Channel, m.DataRateIndex = -1, -1
+	if channel.Valid {
+		m.Channel = int(channel.Int64)
+	}
+	if dataRateIndex.Valid {
+		m.DataRateIndex = int(dataRateIndex.Int64)
+	}
+	m.Modulation = modulation.String
+	m.SpreadingFactor = int(spreadingFactor.Int64)
+	m.Bandwidth = int(bandwidth.Int64)
+	m.BitRate = int(bitRate.Int64)
+	m.Sensitivity = sensitivity.Float64
+	m.MaxEIRP = maxEIRP.Float64
+
+	m.CodingRate = codingRate.String
+	m.Airtime = time.Duration(airtime.Float64 * float64(time.Millisecond))
+	m.MType = mType.String
+	m.FOpts = fOpts.String
+	m.MIC = mic.String
+	m.FCtrl.ADR, m.FCtrl.ADRACKReq, m.FCtrl.ACK = adr.Bool, adrAckReq.Bool, ack.Bool
+	if fCnt.Valid {
+		v := uint32(fCnt.Int64)
+		m.FCnt = &v
+	}
+	if fPort.Valid {
+		v := uint8(fPort.Int64)
+		m.FPort = &v
+	}
+
+	return &m, nil
+}
+
+// GetGateways returns the gateways in the coverage table.
+func (c *Connection) GetGateways(ctx context.Context) ([]model.MacAddress, error) {
+	var gateways []model.MacAddress
+
+	err := c.queryStrings(ctx, getGateways, func(value string) error {
+		var mac model.MacAddress
+		if err := mac.UnmarshalText([]byte(value)); err != nil {
+			return errors.Wrapf(err, "invalid gateway: %s", value)
+		}
+		gateways = append(gateways, mac)
+		return nil
+	})
+
+	return gateways, err
+}
+
+// GetDevices returns the devices in the coverage table.
+func (c *Connection) GetDevices(ctx context.Context) ([]lorawan.DevAddr, error) {
+	var devices []lorawan.DevAddr
+
+	err := c.queryStrings(ctx, getDevices, func(value string) error {
+		var device lorawan.DevAddr
+		if err := device.UnmarshalText([]byte(value)); err != nil {
+			return errors.Wrapf(err, "invalid device: %s", value)
+		}
+		devices = append(devices, device)
+		return nil
+	})
+
+	return devices, err
+}
+
+// queryStrings calls add with the values of a query that returns a single text column.
+func (c *Connection) queryStrings(ctx context.Context, query string, add func(string) error) error {
+	rows, err := c.database.QueryContext(ctx, query)
+	if err != nil {
+		return errors.Wrap(err, "error querying database")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return errors.Wrap(err, "error scanning row")
+		}
+		if err := add(value); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "error in rows")
+	}
+
+	return nil
+}
+
+// GetFrameTimes returns the times a gateway received the frame of a device with the
+// frame counter and MIC.
+func (c *Connection) GetFrameTimes(gateway, device string, fCnt uint32, mic string) ([]time.Time, error) {
+	var times []time.Time
+
+	rows, err := c.database.Query(getFrameTimes, gateway, device, fCnt, mic)
+	if err != nil {
+		return nil, errors.Wrap(err, "error retrieving frame times")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			return nil, errors.Wrap(err, "error scanning row")
+		}
+
+		ts, err := time.Parse(time.RFC3339Nano, t)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid time: %s", t)
+		}
+		times = append(times, ts)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error in rows")
+	}
+
+	return times, nil
+}
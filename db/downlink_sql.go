@@ -0,0 +1,173 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/pkg/errors"
+)
+
+var (
+	createDownlinkTable = `CREATE TABLE IF NOT EXISTS downlink(
+gateway TEXT NOT NULL,
+device TEXT NOT NULL,
+time TEXT NOT NULL,
+token INTEGER NOT NULL,
+immediate INTEGER NOT NULL,
+tmst INTEGER,
+frequency REAL NOT NULL,
+datarate TEXT NOT NULL,
+power INTEGER,
+size INTEGER NOT NULL,
+data TEXT NOT NULL,
+mtype TEXT NOT NULL,
+fcnt INTEGER NOT NULL,
+fport INTEGER,
+ack INTEGER NOT NULL,
+tx_ack TEXT,
+raw TEXT,
+fopts TEXT,
+create_time TEXT DEFAULT CURRENT_TIMESTAMP,
+UNIQUE(gateway, time, data))`
+	addDownlink = `INSERT INTO downlink(gateway, device, time, token, immediate, tmst, frequency, datarate, power, size,
+data, mtype, fcnt, fport, ack, raw, fopts) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	// the TX_ACK belongs to the last downlink of the gateway with the token
+	setTxAck = `UPDATE downlink SET tx_ack=? WHERE rowid=(SELECT rowid FROM downlink WHERE gateway=? AND token=?
+AND tx_ack IS NULL ORDER BY julianday(time) DESC LIMIT 1)`
+	getDownlinks = `SELECT gateway, device, time, token, immediate, tmst, frequency, datarate, power, size, data, mtype,
+fcnt, fport, ack, tx_ack FROM downlink WHERE (? = '' OR device = ?) ORDER BY device, julianday(time)`
+)
+
+func (c *Connection) initDownlink() error {
+	_, err := c.database.Exec(createDownlinkTable)
+	if err != nil {
+		return errors.Wrap(err, "error initializing table 'downlink'")
+	}
+
+	return c.addColumnIfMissing("downlink", "fopts", "TEXT")
+}
+
+// AddDownlink adds a downlink and its MAC commands.
+func (c *Connection) AddDownlink(d *model.Downlink) error {
+	var raw sql.NullString
+	if len(d.Raw) > 0 {
+		raw = sql.NullString{String: string(d.Raw), Valid: true}
+	}
+	t := d.Time.Format(time.RFC3339Nano)
+
+	tx, err := c.database.Begin()
+	if err != nil {
+		return errors.Wrap(err, "error starting transaction")
+	}
+
+	_, err = tx.Exec(addDownlink, d.GatewayMac.String(), d.DeviceAddr, t,
+		d.Token, d.Immediate, sql.NullInt64{Int64: int64(d.Timestamp), Valid: !d.Immediate}, d.Frequency, d.DataRate.String(),
+		d.Power, d.Size, d.Data, d.MType, d.FCnt, getNullUint8(d.FPort), d.ACK, raw, getNullString(d.FOpts))
+	if isUniqueConstraintError(err) {
+		err = model.DuplicateRowError
+	}
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrapf(err, "error adding downlink: %s %s %s", d.GatewayMac, d.DeviceAddr, d.Time)
+	}
+
+	fCnt := sql.NullInt64{Int64: int64(d.FCnt), Valid: true}
+	if err := addMACCommands(tx, d.GatewayMac.String(), d.DeviceAddr, t, fCnt, d.MACCommands); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "error committing downlink")
+	}
+
+	return nil
+}
+
+// SetTxAck stores the TX_ACK error of the last unacknowledged downlink of the gateway
+// with the token.
+func (c *Connection) SetTxAck(ack *model.TxAck) error {
+	result, err := c.database.Exec(setTxAck, ack.Error, ack.GatewayMac.String(), ack.Token)
+	if err != nil {
+		return errors.Wrapf(err, "error setting tx ack: %s %d", ack.GatewayMac, ack.Token)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return errors.Errorf("no downlink for tx ack: %s %d", ack.GatewayMac, ack.Token)
+	}
+
+	return nil
+}
+
+// GetDownlinks returns the downlinks of a device, an empty device matches all of
+// them.
+func (c *Connection) GetDownlinks(device string) ([]*model.Downlink, error) {
+	var downlinks []*model.Downlink
+
+	rows, err := c.database.Query(getDownlinks, device, device)
+	if err != nil {
+		return nil, errors.Wrap(err, "error retrieving downlinks")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d model.Downlink
+		var gateway, t, dataRate string
+		var tmst, fPort sql.NullInt64
+		var txAck sql.NullString
+
+		if err := rows.Scan(&gateway, &d.DeviceAddr, &t, &d.Token, &d.Immediate, &tmst, &d.Frequency, &dataRate,
+			&d.Power, &d.Size, &d.Data, &d.MType, &d.FCnt, &fPort, &d.ACK, &txAck); err != nil {
+			return nil, errors.Wrap(err, "error scanning row")
+		}
+
+		if err := d.GatewayMac.UnmarshalText([]byte(gateway)); err != nil {
+			return nil, errors.Wrapf(err, "invalid gateway: %s", gateway)
+		}
+		if err := d.DataRate.UnmarshalText([]byte(dataRate)); err != nil {
+			return nil, errors.Wrapf(err, "invalid data rate: %s", dataRate)
+		}
+
+		d.Time, err = time.Parse(time.RFC3339Nano, t)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid time: %s", t)
+		}
+
+		d.Timestamp = uint32(tmst.Int64)
+		if fPort.Valid {
+			port := uint8(fPort.Int64)
+			d.FPort = &port
+		}
+		d.TxAck = txAck.String
+
+		downlinks = append(downlinks, &d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error in rows")
+	}
+
+	return downlinks, nil
+}
@@ -0,0 +1,81 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/pkg/errors"
+)
+
+var (
+	createFCntStateTable = `CREATE TABLE IF NOT EXISTS fcnt_state(
+device TEXT PRIMARY KEY,
+fcnt INTEGER NOT NULL,
+time TEXT NOT NULL,
+resets INTEGER NOT NULL DEFAULT 0,
+update_time TEXT DEFAULT CURRENT_TIMESTAMP)`
+	getFCntState = `SELECT fcnt, time, resets FROM fcnt_state WHERE device=?`
+	setFCntState = `INSERT OR REPLACE INTO fcnt_state(device, fcnt, time, resets, update_time)
+VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)`
+)
+
+func (c *Connection) initFCntState() error {
+	_, err := c.database.Exec(createFCntStateTable)
+	if err != nil {
+		return errors.Wrap(err, "error initializing table 'fcnt_state'")
+	}
+	return nil
+}
+
+// GetFCntState returns the frame counter state of a device, nil when the device has
+// none.
+func (c *Connection) GetFCntState(device string) (*model.FCntState, error) {
+	state := &model.FCntState{Device: device}
+	var t string
+
+	err := c.database.QueryRow(getFCntState, device).Scan(&state.FCnt, &t, &state.Resets)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error retrieving frame counter: %s", device)
+	}
+
+	state.Time, err = time.Parse(time.RFC3339Nano, t)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid time: %s", t)
+	}
+
+	return state, nil
+}
+
+func (c *Connection) SetFCntState(s *model.FCntState) error {
+	_, err := c.database.Exec(setFCntState, s.Device, s.FCnt, s.Time.UTC().Format(time.RFC3339Nano), s.Resets)
+	if err != nil {
+		return errors.Wrapf(err, "error saving frame counter: %s", s.Device)
+	}
+
+	return nil
+}
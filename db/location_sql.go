@@ -0,0 +1,95 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package db
+
+import (
+	"time"
+
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/pkg/errors"
+)
+
+var (
+	getRowsWithoutLocation = `SELECT rowid, device, time FROM coverage WHERE (lat IS NULL OR lon IS NULL)
+AND (? = '' OR device = ?) ORDER BY julianday(time)`
+	setLocation = `UPDATE coverage SET lat=?, lon=?, location_source=? WHERE rowid=?`
+)
+
+func (c *Connection) GetRowsWithoutLocation(device string) ([]*model.Location, error) {
+	var locations []*model.Location
+
+	rows, err := c.database.Query(getRowsWithoutLocation, device, device)
+	if err != nil {
+		return nil, errors.Wrap(err, "error retrieving rows without location")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var location model.Location
+		var t string
+
+		if err := rows.Scan(&location.ID, &location.Device, &t); err != nil {
+			return nil, errors.Wrap(err, "error scanning row")
+		}
+
+		location.Time, err = time.Parse(time.RFC3339Nano, t)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing time of row %d", location.ID)
+		}
+
+		locations = append(locations, &location)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error in rows")
+	}
+
+	return locations, nil
+}
+
+func (c *Connection) SetLocations(locations []*model.Location) error {
+	tx, err := c.database.Begin()
+	if err != nil {
+		return errors.Wrap(err, "error starting transaction")
+	}
+
+	stmt, err := tx.Prepare(setLocation)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "error preparing location update")
+	}
+	defer stmt.Close()
+
+	for _, l := range locations {
+		if _, err := stmt.Exec(l.Latitude, l.Longitude, l.Source, l.ID); err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "error setting location of row %d", l.ID)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "error committing locations")
+	}
+
+	return nil
+}
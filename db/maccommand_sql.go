@@ -0,0 +1,80 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package db
+
+import (
+	"database/sql"
+	"encoding/hex"
+
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/pkg/errors"
+)
+
+var (
+	createMACCommandTable = `CREATE TABLE IF NOT EXISTS mac_command(
+gateway TEXT NOT NULL,
+device TEXT NOT NULL,
+time TEXT NOT NULL,
+fcnt INTEGER,
+uplink INTEGER NOT NULL,
+position INTEGER NOT NULL,
+cid INTEGER NOT NULL,
+name TEXT NOT NULL,
+payload TEXT NOT NULL,
+margin INTEGER,
+gateway_count INTEGER,
+battery INTEGER,
+create_time TEXT DEFAULT CURRENT_TIMESTAMP,
+UNIQUE(gateway, device, time, uplink, position))`
+	addMACCommand = `INSERT INTO mac_command(gateway, device, time, fcnt, uplink, position, cid, name, payload, margin,
+gateway_count, battery) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+)
+
+func (c *Connection) initMACCommand() error {
+	_, err := c.database.Exec(createMACCommandTable)
+	if err != nil {
+		return errors.Wrap(err, "error initializing table 'mac_command'")
+	}
+	return nil
+}
+
+// addMACCommands adds the MAC commands of a coverage row or downlink, they have the
+// gateway, device, time and frame counter of the frame.
+func addMACCommands(tx *sql.Tx, gateway, device, t string, fCnt sql.NullInt64, commands []model.MACCommand) error {
+	for i, command := range commands {
+		_, err := tx.Exec(addMACCommand, gateway, device, t, fCnt, command.Uplink, i, command.CID, command.Name,
+			hex.EncodeToString(command.Payload), getNullIntPointer(command.Margin),
+			getNullIntPointer(command.GatewayCount), getNullIntPointer(command.Battery))
+		if err != nil {
+			return errors.Wrapf(err, "error adding mac command %s: %s %s", command.Name, device, t)
+		}
+	}
+
+	return nil
+}
+
+func getNullIntPointer(value *int) sql.NullInt64 {
+	if value == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(*value), Valid: true}
+}
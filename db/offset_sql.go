@@ -0,0 +1,73 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package db
+
+import (
+	"database/sql"
+
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/pkg/errors"
+)
+
+var (
+	createFileOffsetTable = `CREATE TABLE IF NOT EXISTS file_offset(
+file TEXT PRIMARY KEY,
+position INTEGER NOT NULL,
+fingerprint TEXT NOT NULL,
+fingerprint_size INTEGER NOT NULL,
+update_time TEXT DEFAULT CURRENT_TIMESTAMP)`
+	getFileOffset = `SELECT position, fingerprint, fingerprint_size FROM file_offset WHERE file=?`
+	setFileOffset = `INSERT OR REPLACE INTO file_offset(file, position, fingerprint, fingerprint_size, update_time)
+VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)`
+)
+
+func (c *Connection) initFileOffset() error {
+	_, err := c.database.Exec(createFileOffsetTable)
+	if err != nil {
+		return errors.Wrap(err, "error initializing table 'file_offset'")
+	}
+	return nil
+}
+
+func (c *Connection) GetFileOffset(file string) (*model.FileOffset, error) {
+	offset := &model.FileOffset{File: file}
+
+	err := c.database.QueryRow(getFileOffset, file).Scan(&offset.Offset, &offset.Fingerprint, &offset.FingerprintSize)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error retrieving file offset: %s", file)
+	}
+
+	return offset, nil
+}
+
+func (c *Connection) SetFileOffset(o *model.FileOffset) error {
+	_, err := c.database.Exec(setFileOffset, o.File, o.Offset, o.Fingerprint, o.FingerprintSize)
+	if err != nil {
+		return errors.Wrapf(err, "error saving file offset: %s", o.File)
+	}
+
+	return nil
+}
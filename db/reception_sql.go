@@ -0,0 +1,124 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/pkg/errors"
+)
+
+const receptionColumns = `gateway TEXT NOT NULL,
+device TEXT,
+time TEXT NOT NULL,
+frequency REAL NOT NULL,
+if_channel INTEGER NOT NULL,
+rf_chain INTEGER NOT NULL,
+crc INTEGER NOT NULL,
+modulation TEXT,
+datarate TEXT NOT NULL,
+coding_rate TEXT,
+rssi INTEGER NOT NULL,
+signal_rssi REAL,
+snr REAL NOT NULL,
+tmst INTEGER,
+tmms INTEGER,
+fine_timestamp INTEGER,
+freq_offset INTEGER,
+size INTEGER NOT NULL,
+data TEXT NOT NULL,
+raw TEXT NOT NULL,
+create_time TEXT DEFAULT CURRENT_TIMESTAMP,
+UNIQUE(gateway, time, data)`
+
+var (
+	createReceptionTable = `CREATE TABLE IF NOT EXISTS reception(` + receptionColumns + `)`
+	createCrcErrorTable  = `CREATE TABLE IF NOT EXISTS crc_error(` + receptionColumns + `)`
+	addReception         = `INSERT INTO %s(gateway, device, time, frequency, if_channel, rf_chain, crc, modulation,
+datarate, coding_rate, rssi, signal_rssi, snr, tmst, tmms, fine_timestamp, freq_offset, size, data, raw)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+)
+
+func (c *Connection) initReception() error {
+	if _, err := c.database.Exec(createReceptionTable); err != nil {
+		return errors.Wrap(err, "error initializing table 'reception'")
+	}
+
+	if _, err := c.database.Exec(createCrcErrorTable); err != nil {
+		return errors.Wrap(err, "error initializing table 'crc_error'")
+	}
+
+	return nil
+}
+
+// AddReception stores the complete record of a received packet, the coverage row of
+// the packet has the same gateway and time.
+func (c *Connection) AddReception(p *model.RxPacket) error {
+	return c.addReception("reception", p)
+}
+
+// AddCrcError stores the complete record of a packet that was received with an
+// invalid crc.
+func (c *Connection) AddCrcError(p *model.RxPacket) error {
+	return c.addReception("crc_error", p)
+}
+
+func (c *Connection) addReception(table string, p *model.RxPacket) error {
+	raw, err := p.RawRecord()
+	if err != nil {
+		return err
+	}
+
+	device, ok := p.DevAddr()
+
+	var tmst, tmms, fineTimestamp, freqOffset sql.NullInt64
+	var signalRSSI sql.NullFloat64
+	if p.Timestamp != 0 {
+		tmst = sql.NullInt64{Int64: int64(p.Timestamp), Valid: true}
+	}
+	if p.GPSTime != nil {
+		tmms = sql.NullInt64{Int64: int64(*p.GPSTime), Valid: true}
+	}
+	if p.FineTimestamp != nil {
+		fineTimestamp = sql.NullInt64{Int64: int64(*p.FineTimestamp), Valid: true}
+	}
+	if p.FreqOffset != nil {
+		freqOffset = sql.NullInt64{Int64: int64(*p.FreqOffset), Valid: true}
+	}
+	if p.SignalRSSI != nil {
+		signalRSSI = sql.NullFloat64{Float64: *p.SignalRSSI, Valid: true}
+	}
+
+	_, err = c.database.Exec(fmt.Sprintf(addReception, table), p.GatewayMac.String(), sql.NullString{String: device, Valid: ok},
+		p.Time.String(), p.Frequency, p.IFChannel, p.RFChain, p.Crc, getNullString(p.Modulation), p.DataR.String(),
+		getNullString(p.CodingRate), p.RSSI, signalRSSI, p.SNR, tmst, tmms, fineTimestamp, freqOffset, p.Size, p.Data, raw)
+	if isUniqueConstraintError(err) {
+		err = model.DuplicateRowError
+	}
+	if err != nil {
+		return errors.Wrapf(err, "error adding %s: %s %s", table, p.GatewayMac, p.Time)
+	}
+
+	return nil
+}
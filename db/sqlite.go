@@ -25,24 +25,24 @@ package db
 import (
 	"database/sql"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
-	"github.com/spf13/viper"
 )
 
 type Connection struct {
 	database *sql.DB
 }
 
-func Connect() (*Connection, error) {
-	dbFile := viper.GetString("database.dbfile")
-	if len(dbFile) == 0 {
+// Open opens the sqlite database with the given data source name, the file name of
+// the database or a file: URI.
+func Open(dsn string) (*Connection, error) {
+	if len(dsn) == 0 {
 		return nil, errors.New("database file not found (did you run configure?)")
 	}
 
-	db, err := sql.Open("sqlite3", dbFile)
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
-		return nil, errors.Wrapf(err, "error opening database: %s", dbFile)
+		return nil, errors.Wrapf(err, "error opening database: %s", dsn)
 	}
 
 	return &Connection{database: db}, nil
@@ -53,9 +53,69 @@ func (c *Connection) Init() error {
 		return err
 	}
 
+	if err := c.initFileOffset(); err != nil {
+		return err
+	}
+
+	if err := c.initReception(); err != nil {
+		return err
+	}
+
+	if err := c.initMACCommand(); err != nil {
+		return err
+	}
+
+	if err := c.initDownlink(); err != nil {
+		return err
+	}
+
+	if err := c.initFCntState(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// addColumnIfMissing adds a column to a table of a database that was created by an
+// older version.
+func (c *Connection) addColumnIfMissing(table, column, definition string) error {
+	rows, err := c.database.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return errors.Wrapf(err, "error retrieving columns of table '%s'", table)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			return errors.Wrap(err, "error scanning row")
+		}
+		if name == column {
+			return nil
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "error in rows")
+	}
+	rows.Close()
+
+	_, err = c.database.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	if err != nil {
+		return errors.Wrapf(err, "error adding column '%s' to table '%s'", column, table)
+	}
+
+	return nil
+}
+
+func isUniqueConstraintError(err error) bool {
+	sqliteErr, ok := err.(sqlite3.Error)
+	return ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
 func (c *Connection) Ping() error {
 	return c.database.Ping()
 }
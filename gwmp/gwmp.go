@@ -0,0 +1,155 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package gwmp decodes the Semtech UDP packet forwarder protocol (GWMP).
+package gwmp
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultPort is the port the packet forwarder sends its upstream traffic to.
+	DefaultPort = 1700
+
+	protocolVersion1 = 1
+	protocolVersion2 = 2
+
+	identifierPushData = 0x00
+)
+
+var NotPushDataError = errors.New("not a PUSH_DATA packet")
+
+// PushData is a PUSH_DATA packet sent by the packet forwarder.
+type PushData struct {
+	ProtocolVersion uint8
+	Token           uint16
+	GatewayMac      model.MacAddress
+	RxPackets       []RxPk `json:"rxpk"`
+}
+
+// RxPk is a received packet as reported by the packet forwarder.
+type RxPk struct {
+	Time       *model.CompactTime `json:"time"`
+	Timestamp  uint32             `json:"tmst"`
+	Channel    uint8              `json:"chan"`
+	RFChain    uint8              `json:"rfch"`
+	Frequency  float64            `json:"freq"`
+	Status     int8               `json:"stat"`
+	Modulation string             `json:"modu"`
+	DataRate   model.DataRate     `json:"datr"`
+	CodingRate string             `json:"codr"`
+	RSSI       int16              `json:"rssi"`
+	SNR        float64            `json:"lsnr"`
+	Size       uint16             `json:"size"`
+	Data       string             `json:"data"`
+
+	GPSTime       *uint64          `json:"tmms"`
+	FineTimestamp *uint32          `json:"ftime"`
+	FreqOffset    *int32           `json:"foff"`
+	SignalRSSI    *float64         `json:"rssis"`
+	Signals       []model.RxSignal `json:"rsig"`
+
+	// Raw is the json object of the packet as the forwarder sent it.
+	Raw json.RawMessage `json:"-"`
+}
+
+// UnmarshalJSON decodes the packet and keeps the json object in Raw.
+func (r *RxPk) UnmarshalJSON(data []byte) error {
+	type rxpk RxPk
+	if err := json.Unmarshal(data, (*rxpk)(r)); err != nil {
+		return err
+	}
+	r.Raw = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+// ParsePushData decodes a PUSH_DATA datagram, NotPushDataError is returned for the
+// other packet types.
+func ParsePushData(data []byte) (*PushData, error) {
+	if len(data) < 4 {
+		return nil, errors.New("gwmp packet too short")
+	}
+
+	if data[0] != protocolVersion1 && data[0] != protocolVersion2 {
+		return nil, errors.Errorf("unknown gwmp protocol version: %d", data[0])
+	}
+
+	if data[3] != identifierPushData {
+		return nil, NotPushDataError
+	}
+
+	if len(data) < 12 {
+		return nil, errors.New("PUSH_DATA packet too short")
+	}
+
+	pushData := PushData{
+		ProtocolVersion: data[0],
+		Token:           uint16(data[1])<<8 | uint16(data[2]),
+	}
+	copy(pushData.GatewayMac[:], data[4:12])
+
+	if err := json.Unmarshal(data[12:], &pushData); err != nil {
+		return nil, errors.Wrap(err, "error unmarshalling PUSH_DATA payload")
+	}
+
+	return &pushData, nil
+}
+
+// RxPacket converts the received packet to the format of the lora-logger tool. When
+// the forwarder did not add a time (no GPS), the given receive time is used.
+func (r *RxPk) RxPacket(gateway model.MacAddress, received time.Time) *model.RxPacket {
+	packet := &model.RxPacket{
+		GatewayMac: gateway,
+		Time:       model.CompactTime(received),
+		Frequency:  r.Frequency,
+		IFChannel:  r.Channel,
+		RFChain:    r.RFChain,
+		Crc:        r.Status,
+		Modulation: strings.ToUpper(r.Modulation),
+		DataR:      r.DataRate,
+		CodingRate: r.CodingRate,
+		RSSI:       r.RSSI,
+		SNR:        r.SNR,
+		Size:       r.Size,
+		Data:       r.Data,
+
+		Timestamp:     r.Timestamp,
+		GPSTime:       r.GPSTime,
+		FineTimestamp: r.FineTimestamp,
+		FreqOffset:    r.FreqOffset,
+		SignalRSSI:    r.SignalRSSI,
+		Signals:       r.Signals,
+		Raw:           r.Raw,
+	}
+
+	if r.Time != nil && !time.Time(*r.Time).IsZero() {
+		packet.Time = *r.Time
+	}
+
+	return packet
+}
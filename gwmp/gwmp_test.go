@@ -0,0 +1,119 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package gwmp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bullettime/lora-coverage/model"
+)
+
+func pushData(payload string) []byte {
+	return append([]byte{0x02, 0x12, 0x34, 0x00, 0xaa, 0x55, 0x5a, 0x00, 0x00, 0x00, 0x01, 0x01}, payload...)
+}
+
+func TestParsePushData(t *testing.T) {
+	data := pushData(`{"rxpk":[` +
+		`{"time":"2018-04-05T21:46:04.123456Z","tmst":3512348611,"chan":2,"rfch":0,"freq":868.500000,"stat":1,"modu":"LORA","datr":"SF7BW125","codr":"4/5","rssi":-35,"lsnr":5.1,"size":32,"data":"QAEBAQGAAAABVfdjR6YrSw=="},` +
+		`{"tmst":3512348612,"chan":8,"rfch":1,"freq":868.800000,"stat":-1,"modu":"FSK","datr":50000,"rssi":-75,"size":16,"data":"VEVTVF9QQUNLRVRfMTIzNA=="}` +
+		`],"stat":{"time":"2018-04-05 21:46:04 GMT"}}`)
+
+	p, err := ParsePushData(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if p.ProtocolVersion != 2 || p.Token != 0x1234 {
+		t.Errorf("wrong header: %d %x", p.ProtocolVersion, p.Token)
+	}
+	if p.GatewayMac.String() != "aa555a0000000101" {
+		t.Errorf("wrong gateway mac: %s", p.GatewayMac)
+	}
+	if len(p.RxPackets) != 2 {
+		t.Fatalf("expected 2 rx packets, got %d", len(p.RxPackets))
+	}
+
+	received := time.Date(2018, 4, 5, 21, 46, 5, 0, time.UTC)
+
+	packet := p.RxPackets[0].RxPacket(p.GatewayMac, received)
+	if packet.Time.String() != "2018-04-05T21:46:04.123456Z" {
+		t.Errorf("wrong time: %s", packet.Time)
+	}
+	if packet.DataR.LoRa != "SF7BW125" || packet.Crc != 1 || packet.RSSI != -35 || packet.IFChannel != 2 {
+		t.Errorf("wrong packet: %+v", packet)
+	}
+
+	packet = p.RxPackets[1].RxPacket(p.GatewayMac, received)
+	if !time.Time(packet.Time).Equal(received) {
+		t.Errorf("expected the receive time, got %s", packet.Time)
+	}
+	if packet.DataR != (model.DataRate{FSK: 50000}) || packet.Crc != -1 || packet.Modulation != "FSK" {
+		t.Errorf("wrong packet: %+v", packet)
+	}
+}
+
+func TestParseMetadata(t *testing.T) {
+	rxpk := `{"tmst":3512348611,"tmms":1207161982123,"ftime":123456789,"chan":0,"rfch":0,"freq":868.1,"stat":1,"modu":"LORA","datr":"SF7BW125","codr":"4/5","rssis":-37.5,"lsnr":9.5,"foff":-120,"rssi":-35,"size":13,"data":"QCwbASYAAQAB8JvgtQ=="}`
+	data := pushData(`{"rxpk":[` + rxpk + `]}`)
+
+	p, err := ParsePushData(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	packet := p.RxPackets[0].RxPacket(p.GatewayMac, time.Now())
+	if packet.Timestamp != 3512348611 {
+		t.Errorf("wrong tmst: %d", packet.Timestamp)
+	}
+	if packet.GPSTime == nil || *packet.GPSTime != 1207161982123 {
+		t.Errorf("wrong tmms: %v", packet.GPSTime)
+	}
+	if packet.FineTimestamp == nil || *packet.FineTimestamp != 123456789 {
+		t.Errorf("wrong ftime: %v", packet.FineTimestamp)
+	}
+	if packet.FreqOffset == nil || *packet.FreqOffset != -120 {
+		t.Errorf("wrong foff: %v", packet.FreqOffset)
+	}
+	if packet.SignalRSSI == nil || *packet.SignalRSSI != -37.5 {
+		t.Errorf("wrong rssis: %v", packet.SignalRSSI)
+	}
+	if string(packet.Raw) != rxpk {
+		t.Errorf("wrong raw record: %s", packet.Raw)
+	}
+}
+
+func TestParseOtherPackets(t *testing.T) {
+	// PULL_DATA
+	if _, err := ParsePushData([]byte{0x02, 0x12, 0x34, 0x02, 0xaa, 0x55, 0x5a, 0x00, 0x00, 0x00, 0x01, 0x01}); err != NotPushDataError {
+		t.Errorf("expected NotPushDataError, got %v", err)
+	}
+
+	if _, err := ParsePushData([]byte{0x09, 0x12, 0x34, 0x00}); err == nil {
+		t.Error("expected an error for an unknown protocol version")
+	}
+
+	if _, err := ParsePushData(pushData(`{"rxpk":[`)); err == nil {
+		t.Error("expected an error for invalid json")
+	}
+}
@@ -0,0 +1,182 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package integration
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/pkg/errors"
+)
+
+// chirpStackUplink is the json encoding of a ChirpStack up event. Both the v4 field
+// names and the older v3 ones (gatewayID, loRaSNR, loRaModulationInfo) are accepted.
+type chirpStackUplink struct {
+	Time    *time.Time             `json:"time"`
+	DevAddr string                 `json:"devAddr"`
+	DR      *int                   `json:"dr"`
+	FCnt    uint32                 `json:"fCnt"`
+	FPort   uint8                  `json:"fPort"`
+	Data    []byte                 `json:"data"`
+	Object  map[string]interface{} `json:"object"`
+	RxInfo  []chirpStackRxInfo     `json:"rxInfo"`
+	TxInfo  chirpStackTxInfo       `json:"txInfo"`
+}
+
+type chirpStackRxInfo struct {
+	GatewayID   string     `json:"gatewayId"`
+	GatewayIDV3 string     `json:"gatewayID"`
+	Time        *time.Time `json:"time"`
+	RSSI        int16      `json:"rssi"`
+	SNR         *float64   `json:"snr"`
+	LoRaSNR     *float64   `json:"loRaSNR"`
+}
+
+type chirpStackTxInfo struct {
+	Frequency          uint64                    `json:"frequency"`
+	DR                 *int                      `json:"dr"`
+	Modulation         json.RawMessage           `json:"modulation"`
+	LoRaModulationInfo *chirpStackLoRaModulation `json:"loRaModulationInfo"`
+}
+
+type chirpStackModulation struct {
+	LoRa *chirpStackLoRaModulation `json:"lora"`
+	FSK  *struct {
+		Datarate uint32 `json:"datarate"`
+	} `json:"fsk"`
+}
+
+type chirpStackLoRaModulation struct {
+	Bandwidth       uint32 `json:"bandwidth"`
+	SpreadingFactor uint32 `json:"spreadingFactor"`
+	CodeRate        string `json:"codeRate"`
+}
+
+func parseChirpStack(data []byte) (*Uplink, error) {
+	var event chirpStackUplink
+
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, errors.Wrap(err, "error unmarshalling chirpstack uplink")
+	}
+
+	devAddr, err := parseDevAddr(event.DevAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	dataRate, dataRateIndex, err := event.TxInfo.dataRate(event.DR)
+	if err != nil {
+		return nil, err
+	}
+
+	uplink := &Uplink{
+		DevAddr:       devAddr,
+		Time:          firstTime(event.Time),
+		Frequency:     float64(event.TxInfo.Frequency) / 1e6,
+		DataRate:      dataRate,
+		DataRateIndex: dataRateIndex,
+		CodingRate:    event.TxInfo.codingRate(),
+		FCnt:          event.FCnt,
+		FPort:         event.FPort,
+		Payload:       event.Data,
+		Decoded:       event.Object,
+	}
+
+	for _, rx := range event.RxInfo {
+		id := rx.GatewayID
+		if len(id) == 0 {
+			id = rx.GatewayIDV3
+		}
+
+		gateway, err := parseGatewayEUI(id)
+		if err != nil {
+			log.WithError(err).Debug("skipping reception")
+			continue
+		}
+
+		reception := Reception{
+			Gateway: gateway,
+			Time:    firstTime(rx.Time, &uplink.Time),
+			RSSI:    rx.RSSI,
+		}
+
+		if rx.SNR != nil {
+			reception.SNR = *rx.SNR
+		} else if rx.LoRaSNR != nil {
+			reception.SNR = *rx.LoRaSNR
+		}
+
+		uplink.Receptions = append(uplink.Receptions, reception)
+	}
+
+	if len(uplink.Receptions) == 0 {
+		return nil, NoReceptionsError
+	}
+
+	return uplink, nil
+}
+
+// codingRate returns the LoRa coding rate, eg. 4/5 (v3) or CR_4_5 (v4).
+func (t chirpStackTxInfo) codingRate() string {
+	if t.LoRaModulationInfo != nil {
+		return t.LoRaModulationInfo.CodeRate
+	}
+
+	var modulation chirpStackModulation
+	if len(t.Modulation) > 0 && t.Modulation[0] == '{' && json.Unmarshal(t.Modulation, &modulation) == nil &&
+		modulation.LoRa != nil {
+		return modulation.LoRa.CodeRate
+	}
+
+	return ""
+}
+
+// dataRate returns the data rate or, when only its index is known, the index.
+func (t chirpStackTxInfo) dataRate(dr *int) (model.DataRate, int, error) {
+	if t.LoRaModulationInfo != nil {
+		return loraDataRate(t.LoRaModulationInfo.SpreadingFactor, t.LoRaModulationInfo.Bandwidth), -1, nil
+	}
+
+	// v4 has a modulation object, v3 a modulation name
+	var modulation chirpStackModulation
+	if len(t.Modulation) > 0 && t.Modulation[0] == '{' {
+		if err := json.Unmarshal(t.Modulation, &modulation); err != nil {
+			return model.DataRate{}, -1, errors.Wrap(err, "error unmarshalling modulation")
+		}
+	}
+
+	switch {
+	case modulation.LoRa != nil:
+		return loraDataRate(modulation.LoRa.SpreadingFactor, modulation.LoRa.Bandwidth), -1, nil
+	case modulation.FSK != nil:
+		return model.DataRate{FSK: modulation.FSK.Datarate}, -1, nil
+	case t.DR != nil:
+		return model.DataRate{}, *t.DR, nil
+	case dr != nil:
+		return model.DataRate{}, *dr, nil
+	}
+
+	return model.DataRate{}, -1, errors.New("uplink has no data rate")
+}
@@ -0,0 +1,262 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package integration converts the uplink events of network servers (ChirpStack and
+// The Things Stack) into coverage rows.
+package integration
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/brocaar/lorawan"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/pkg/errors"
+)
+
+// Format is the json format of an uplink event.
+type Format int
+
+const (
+	ChirpStack Format = iota + 1
+	TheThingsStack
+)
+
+// frameOverhead is the size of a data frame without FOpts and FRMPayload
+// (MHDR, DevAddr, FCtrl, FCnt, FPort and MIC).
+const frameOverhead = 13
+
+var (
+	UnknownFormatError = errors.New("unknown uplink format")
+	NoReceptionsError  = errors.New("uplink has no receptions with a gateway eui")
+)
+
+// Uplink is an uplink event as reported by a network server, it has one reception
+// for every gateway that received the frame.
+type Uplink struct {
+	DevAddr   lorawan.DevAddr
+	Time      time.Time
+	Frequency float64
+	DataRate  model.DataRate
+	// DataRateIndex is the index of the data rate in the channel plan of the region,
+	// it is only set (>= 0) when the network server did not report the data rate.
+	DataRateIndex int
+	CodingRate    string
+	FCnt          uint32
+	FPort         uint8
+	Payload       []byte
+	Decoded       map[string]interface{}
+	Receptions    []Reception
+}
+
+// Reception is the reception of an uplink by a single gateway.
+type Reception struct {
+	Gateway model.MacAddress
+	Time    time.Time
+	RSSI    int16
+	SNR     float64
+}
+
+func (f Format) String() string {
+	switch f {
+	case ChirpStack:
+		return "chirpstack"
+	case TheThingsStack:
+		return "tts"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// ParseFormat returns the format with the given name.
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "chirpstack":
+		return ChirpStack, nil
+	case "tts", "ttn", "thethingsstack":
+		return TheThingsStack, nil
+	}
+	return 0, errors.Wrap(UnknownFormatError, name)
+}
+
+// FormatForTopic returns the format of the uplink events published on an MQTT topic:
+// application/+/device/+/event/up for ChirpStack and v3/+/devices/+/up for The Things Stack.
+func FormatForTopic(topic string) (Format, bool) {
+	levels := strings.Split(topic, "/")
+
+	if len(levels) == 6 && levels[0] == "application" && levels[2] == "device" &&
+		levels[4] == "event" && levels[5] == "up" {
+		return ChirpStack, true
+	}
+
+	if len(levels) == 5 && levels[0] == "v3" && levels[2] == "devices" && levels[4] == "up" {
+		return TheThingsStack, true
+	}
+
+	return 0, false
+}
+
+// Parse parses an uplink event in the given format.
+func Parse(format Format, data []byte) (*Uplink, error) {
+	switch format {
+	case ChirpStack:
+		return parseChirpStack(data)
+	case TheThingsStack:
+		return parseTheThingsStack(data)
+	}
+	return nil, UnknownFormatError
+}
+
+// Coverage converts the uplink into a coverage row per reception. The location and the
+// power are taken from the payload decoded by the network server when useDecoded is
+// set, otherwise the payload is decoded like the lora-logger data. Just like
+// model.Coverage.Unmarshal, model.InvalidPayloadError is returned together with the
+// rows when the payload contains no (complete) location. Rows of uplinks with only a
+// data rate index get their data rate when they are annotated with the region.
+func (u *Uplink) Coverage(useDecoded bool) ([]*model.Coverage, error) {
+	var rows []*model.Coverage
+	var payloadErr error
+
+	for _, rx := range u.Receptions {
+		fCnt, fPort := u.FCnt, u.FPort
+
+		t := rx.Time
+		if t.IsZero() {
+			t = u.Time
+		}
+
+		row := &model.Coverage{
+			GatewayMac:    rx.Gateway,
+			DeviceAddr:    u.DevAddr,
+			Time:          model.CompactTime(t),
+			Frequency:     u.Frequency,
+			DataRate:      u.DataRate,
+			DataRateIndex: u.DataRateIndex,
+			CodingRate:    u.CodingRate,
+			RSSI:          rx.RSSI,
+			SNR:           rx.SNR,
+			Size:          uint16(len(u.Payload) + frameOverhead),
+			FCnt:          &fCnt,
+			FPort:         &fPort,
+		}
+
+		if useDecoded {
+			payloadErr = setDecodedPayload(row, u.Payload, u.Decoded)
+		} else {
+			payloadErr = row.SetPayload(u.Payload)
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, payloadErr
+}
+
+// setDecodedPayload takes the location and power from the fields decoded by the
+// network server.
+func setDecodedPayload(c *model.Coverage, payload []byte, decoded map[string]interface{}) error {
+	c.Payload = hex.EncodeToString(payload)
+	c.Power = model.UnknownPower
+
+	if power, ok := lookupNumber(decoded, "power", "txpower", "tx_power", "txPower"); ok {
+		c.Power = int8(power)
+	}
+
+	lat, latOk := lookupNumber(decoded, "latitude", "lat")
+	lon, lonOk := lookupNumber(decoded, "longitude", "lon", "lng", "long")
+	if !latOk || !lonOk {
+		return model.InvalidPayloadError
+	}
+
+	c.Latitude = lat
+	c.Longitude = lon
+	c.LocationSource = model.LocationSourceDecoded
+
+	return nil
+}
+
+func lookupNumber(fields map[string]interface{}, keys ...string) (float64, bool) {
+	for _, key := range keys {
+		if value, ok := fields[key].(float64); ok {
+			return value, true
+		}
+	}
+	return 0, false
+}
+
+func loraDataRate(spreadingFactor, bandwidth uint32) model.DataRate {
+	// bandwidth is in Hz for current network servers, in kHz for older ones
+	if bandwidth >= 1000 {
+		bandwidth /= 1000
+	}
+	return model.DataRate{LoRa: fmt.Sprintf("SF%dBW%d", spreadingFactor, bandwidth)}
+}
+
+// parseGatewayEUI parses a hex gateway eui, separators are ignored.
+func parseGatewayEUI(eui string) (model.MacAddress, error) {
+	var mac model.MacAddress
+
+	eui = strings.NewReplacer(":", "", "-", "").Replace(eui)
+	b, err := hex.DecodeString(eui)
+	if err != nil {
+		return mac, errors.Wrapf(err, "invalid gateway eui: %s", eui)
+	}
+	if len(b) != len(mac) {
+		return mac, errors.Errorf("invalid gateway eui: %s", eui)
+	}
+
+	copy(mac[:], b)
+	return mac, nil
+}
+
+// parseDevAddr parses a device address in hex or in base64 (older ChirpStack versions).
+func parseDevAddr(devAddr string) (lorawan.DevAddr, error) {
+	var addr lorawan.DevAddr
+
+	if len(devAddr) == 0 {
+		return addr, nil
+	}
+
+	if err := addr.UnmarshalText([]byte(devAddr)); err == nil {
+		return addr, nil
+	}
+
+	b, err := base64.StdEncoding.DecodeString(devAddr)
+	if err != nil || len(b) != len(addr) {
+		return addr, errors.Errorf("invalid device address: %s", devAddr)
+	}
+
+	copy(addr[:], b)
+	return addr, nil
+}
+
+// firstTime returns the first time that is set.
+func firstTime(times ...*time.Time) time.Time {
+	for _, t := range times {
+		if t != nil && !t.IsZero() {
+			return t.UTC()
+		}
+	}
+	return time.Now().UTC()
+}
@@ -0,0 +1,153 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package integration
+
+import (
+	"testing"
+
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/bullettime/lora-coverage/region"
+)
+
+var uplinkTests = []struct {
+	name       string
+	format     Format
+	data       string
+	useDecoded bool
+	gateways   []string
+	dataRate   string
+	rssi       int16
+	snr        float64
+}{
+	{
+		name:   "chirpstack v4",
+		format: ChirpStack,
+		data: `{"time":"2018-04-01T10:00:00Z","devAddr":"00189440","dr":5,"fCnt":4,"fPort":1,"data":"B8N+ALedDg==",
+"rxInfo":[{"gatewayId":"0016c001f153a14c","rssi":-60,"snr":10.5},{"gatewayId":"0016c001f153a14d","rssi":-90,"snr":-2}],
+"txInfo":{"frequency":868100000,"modulation":{"lora":{"bandwidth":125000,"spreadingFactor":7,"codeRate":"CR_4_5"}}}}`,
+		gateways: []string{"0016c001f153a14c", "0016c001f153a14d"},
+		dataRate: "SF7BW125",
+		rssi:     -60,
+		snr:      10.5,
+	},
+	{
+		name:   "chirpstack v3",
+		format: ChirpStack,
+		data: `{"devAddr":"ABiUQA==","fCnt":4,"fPort":1,"data":"B8N+ALedDg==",
+"rxInfo":[{"gatewayID":"0016c001f153a14c","rssi":-61,"loRaSNR":7}],"txInfo":{"frequency":868100000,"dr":3}}`,
+		gateways: []string{"0016c001f153a14c"},
+		dataRate: "SF9BW125",
+		rssi:     -61,
+		snr:      7,
+	},
+	{
+		name:   "the things stack",
+		format: TheThingsStack,
+		data: `{"end_device_ids":{"device_id":"dev1","dev_addr":"00189440"},"received_at":"2018-04-01T10:00:00Z",
+"uplink_message":{"f_port":1,"f_cnt":4,"frm_payload":"AAAA","decoded_payload":{"latitude":50.8798,"longitude":4.7005,"power":14},
+"rx_metadata":[{"gateway_ids":{"gateway_id":"gw1","eui":"0016C001F153A14C"},"rssi":-62,"channel_rssi":-62,"snr":9.25},
+{"gateway_ids":{"gateway_id":"packet-broker"}}],
+"settings":{"data_rate":{"lora":{"bandwidth":250000,"spreading_factor":7}},"frequency":"868300000"}}}`,
+		useDecoded: true,
+		gateways:   []string{"0016c001f153a14c"},
+		dataRate:   "SF7BW250",
+		rssi:       -62,
+		snr:        9.25,
+	},
+}
+
+func TestUplinkCoverage(t *testing.T) {
+	for _, test := range uplinkTests {
+		t.Run(test.name, func(t *testing.T) {
+			uplink, err := Parse(test.format, []byte(test.data))
+			if err != nil {
+				t.Fatal("error parsing uplink:", err)
+			}
+
+			rows, err := uplink.Coverage(test.useDecoded)
+			if err != nil {
+				t.Fatal("error converting uplink:", err)
+			}
+
+			if len(rows) != len(test.gateways) {
+				t.Fatalf("expected %d rows, got %d", len(test.gateways), len(rows))
+			}
+
+			for i, row := range rows {
+				if row.GatewayMac.String() != test.gateways[i] {
+					t.Errorf("row %d: wrong gateway %s", i, row.GatewayMac)
+				}
+			}
+
+			row := rows[0]
+			if err := row.Annotate(eu868); err != nil {
+				t.Error("error annotating row:", err)
+			}
+			if row.DeviceAddr.String() != "00189440" {
+				t.Errorf("wrong device address: %s", row.DeviceAddr)
+			}
+			if row.DataRate.String() != test.dataRate {
+				t.Errorf("wrong data rate: %s", row.DataRate)
+			}
+			if row.RSSI != test.rssi || row.SNR != test.snr {
+				t.Errorf("wrong rssi/snr: %d/%f", row.RSSI, row.SNR)
+			}
+			if row.Latitude != 50.8798 || row.Longitude != 4.7005 || row.Power != 14 {
+				t.Errorf("wrong location/power: %f, %f, %d", row.Latitude, row.Longitude, row.Power)
+			}
+		})
+	}
+}
+
+var eu868, _ = region.Get("EU868")
+
+func TestFormatForTopic(t *testing.T) {
+	tests := map[string]Format{
+		"application/12/device/0102030405060708/event/up":  ChirpStack,
+		"v3/my-app@ttn/devices/dev1/up":                    TheThingsStack,
+		"application/12/device/0102030405060708/event/ack": 0,
+		"v3/my-app/devices/dev1/join":                      0,
+	}
+
+	for topic, expected := range tests {
+		format, ok := FormatForTopic(topic)
+		if format != expected || ok != (expected != 0) {
+			t.Errorf("%s: got %s, expected %s", topic, format, expected)
+		}
+	}
+}
+
+func TestMissingLocation(t *testing.T) {
+	uplink := &Uplink{
+		Payload:    []byte{1, 2, 3},
+		Receptions: []Reception{{}},
+	}
+
+	rows, err := uplink.Coverage(false)
+	if err != model.InvalidPayloadError {
+		t.Fatal("expected invalid payload error, got:", err)
+	}
+	if len(rows) != 1 || rows[0].Power != model.UnknownPower {
+		t.Error("expected a row without power")
+	}
+}
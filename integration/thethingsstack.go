@@ -0,0 +1,172 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package integration
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/pkg/errors"
+)
+
+// ttsUplink is the json encoding of a The Things Stack (v3) uplink message.
+type ttsUplink struct {
+	EndDeviceIDs struct {
+		DevAddr string `json:"dev_addr"`
+	} `json:"end_device_ids"`
+	ReceivedAt    *time.Time       `json:"received_at"`
+	UplinkMessage ttsUplinkMessage `json:"uplink_message"`
+}
+
+type ttsUplinkMessage struct {
+	FPort          uint8                  `json:"f_port"`
+	FCnt           uint32                 `json:"f_cnt"`
+	FRMPayload     []byte                 `json:"frm_payload"`
+	DecodedPayload map[string]interface{} `json:"decoded_payload"`
+	RxMetadata     []ttsRxMetadata        `json:"rx_metadata"`
+	Settings       ttsSettings            `json:"settings"`
+	ReceivedAt     *time.Time             `json:"received_at"`
+}
+
+type ttsRxMetadata struct {
+	GatewayIDs struct {
+		GatewayID string `json:"gateway_id"`
+		EUI       string `json:"eui"`
+	} `json:"gateway_ids"`
+	Time        *time.Time `json:"time"`
+	RSSI        *float64   `json:"rssi"`
+	ChannelRSSI *float64   `json:"channel_rssi"`
+	SNR         float64    `json:"snr"`
+}
+
+type ttsSettings struct {
+	DataRate struct {
+		LoRa *struct {
+			Bandwidth       uint32 `json:"bandwidth"`
+			SpreadingFactor uint32 `json:"spreading_factor"`
+			CodingRate      string `json:"coding_rate"`
+		} `json:"lora"`
+		FSK *struct {
+			BitRate uint32 `json:"bit_rate"`
+		} `json:"fsk"`
+	} `json:"data_rate"`
+	DataRateIndex *int       `json:"data_rate_index"`
+	CodingRate    string     `json:"coding_rate"`
+	Frequency     string     `json:"frequency"`
+	Time          *time.Time `json:"time"`
+}
+
+func parseTheThingsStack(data []byte) (*Uplink, error) {
+	var event ttsUplink
+
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, errors.Wrap(err, "error unmarshalling the things stack uplink")
+	}
+
+	message := event.UplinkMessage
+
+	devAddr, err := parseDevAddr(event.EndDeviceIDs.DevAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	dataRate, dataRateIndex, err := message.Settings.dataRate()
+	if err != nil {
+		return nil, err
+	}
+
+	var frequency float64
+	if len(message.Settings.Frequency) > 0 {
+		hz, err := strconv.ParseUint(message.Settings.Frequency, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid frequency: %s", message.Settings.Frequency)
+		}
+		frequency = float64(hz) / 1e6
+	}
+
+	uplink := &Uplink{
+		DevAddr:       devAddr,
+		Time:          firstTime(message.Settings.Time, message.ReceivedAt, event.ReceivedAt),
+		Frequency:     frequency,
+		DataRate:      dataRate,
+		DataRateIndex: dataRateIndex,
+		CodingRate:    message.Settings.codingRate(),
+		FCnt:          message.FCnt,
+		FPort:         message.FPort,
+		Payload:       message.FRMPayload,
+		Decoded:       message.DecodedPayload,
+	}
+
+	for _, rx := range message.RxMetadata {
+		gateway, err := parseGatewayEUI(rx.GatewayIDs.EUI)
+		if err != nil {
+			log.WithError(err).WithField("gateway", rx.GatewayIDs.GatewayID).Debug("skipping reception")
+			continue
+		}
+
+		reception := Reception{
+			Gateway: gateway,
+			Time:    firstTime(rx.Time, &uplink.Time),
+			SNR:     rx.SNR,
+		}
+
+		if rx.RSSI != nil {
+			reception.RSSI = int16(*rx.RSSI)
+		} else if rx.ChannelRSSI != nil {
+			reception.RSSI = int16(*rx.ChannelRSSI)
+		}
+
+		uplink.Receptions = append(uplink.Receptions, reception)
+	}
+
+	if len(uplink.Receptions) == 0 {
+		return nil, NoReceptionsError
+	}
+
+	return uplink, nil
+}
+
+// codingRate returns the LoRa coding rate, older versions have it next to the data rate.
+func (s ttsSettings) codingRate() string {
+	if s.DataRate.LoRa != nil && s.DataRate.LoRa.CodingRate != "" {
+		return s.DataRate.LoRa.CodingRate
+	}
+	return s.CodingRate
+}
+
+// dataRate returns the data rate or, when only its index is known, the index.
+func (s ttsSettings) dataRate() (model.DataRate, int, error) {
+	switch {
+	case s.DataRate.LoRa != nil:
+		return loraDataRate(s.DataRate.LoRa.SpreadingFactor, s.DataRate.LoRa.Bandwidth), -1, nil
+	case s.DataRate.FSK != nil:
+		return model.DataRate{FSK: s.DataRate.FSK.BitRate}, -1, nil
+	case s.DataRateIndex != nil:
+		return model.DataRate{}, *s.DataRateIndex, nil
+	}
+
+	return model.DataRate{}, -1, errors.New("uplink has no data rate")
+}
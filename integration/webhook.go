@@ -0,0 +1,150 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package integration
+
+import (
+	"crypto/subtle"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/pkg/errors"
+)
+
+// maxBodySize is the maximum size of a webhook request body.
+const maxBodySize = 1 << 20
+
+// Store stores coverage rows, it is implemented by model.Model.
+type Store interface {
+	AddCoverageRow(*model.Coverage) error
+}
+
+// storeError is returned by AddUplink when the uplink was valid, but storing it failed.
+type storeError struct {
+	err error
+}
+
+func (e *storeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *storeError) Cause() error {
+	return e.err
+}
+
+// AddUplink parses an uplink event and stores a coverage row for every gateway that
+// received it. Rows that are already stored are skipped. Uplinks without a location
+// are stored as well (like the lora-logger data).
+func AddUplink(store Store, format Format, data []byte, useDecoded bool) error {
+	uplink, err := Parse(format, data)
+	if err != nil {
+		return err
+	}
+
+	ctx := log.WithField("device", uplink.DevAddr)
+
+	rows, err := uplink.Coverage(useDecoded)
+	switch err {
+	case nil:
+	case model.InvalidPayloadError:
+		ctx.Warn("invalid payload (no location data and/or power)")
+	default:
+		return errors.Wrap(err, "error converting uplink")
+	}
+
+	for _, row := range rows {
+		err := store.AddCoverageRow(row)
+		if errors.Cause(err) == model.DuplicateRowError {
+			ctx.WithField("gateway", row.GatewayMac).Debug("skipping duplicate row")
+			continue
+		}
+		if err != nil {
+			return &storeError{err: err}
+		}
+	}
+
+	return nil
+}
+
+// WebhookHandler receives the uplinks that the HTTP integration of a network server
+// posts. Requests that can not be processed get a 4xx status, failures to store the
+// data a 5xx status so the network server retries them.
+type WebhookHandler struct {
+	Format     Format
+	Store      Store
+	UseDecoded bool
+
+	// SecretHeader and Secret, when set, are the header and value every request has to carry.
+	SecretHeader string
+	Secret       string
+}
+
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := log.WithFields(log.Fields{"format": h.Format, "remote": r.RemoteAddr})
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if len(h.Secret) > 0 {
+		secret := r.Header.Get(h.SecretHeader)
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(h.Secret)) != 1 {
+			ctx.Warn("webhook request with invalid secret")
+			http.Error(w, "invalid secret", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	// ChirpStack posts all events to the same url and sets the event type in the query
+	if event := r.URL.Query().Get("event"); len(event) > 0 && event != "up" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, "error reading body", http.StatusBadRequest)
+		return
+	}
+
+	err = AddUplink(h.Store, h.Format, body, h.UseDecoded)
+	if _, ok := err.(*storeError); ok {
+		ctx.WithError(err).Error("storing uplink")
+		http.Error(w, "error storing uplink", http.StatusInternalServerError)
+		return
+	}
+
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case NoReceptionsError:
+		ctx.Debug(err.Error())
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		ctx.WithError(err).WithField("body", string(body)).Error("invalid uplink")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,95 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package integration
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/pkg/errors"
+)
+
+type memoryStore struct {
+	rows []*model.Coverage
+	err  error
+}
+
+func (s *memoryStore) AddCoverageRow(row *model.Coverage) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.rows = append(s.rows, row)
+	return nil
+}
+
+func TestWebhookHandler(t *testing.T) {
+	uplink := uplinkTests[0].data
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		secret string
+		body   string
+		err    error
+		status int
+		rows   int
+	}{
+		{"uplink", http.MethodPost, "/chirpstack?event=up", "secret", uplink, nil, http.StatusNoContent, 2},
+		{"other event", http.MethodPost, "/chirpstack?event=join", "secret", `{}`, nil, http.StatusNoContent, 0},
+		{"wrong method", http.MethodGet, "/chirpstack", "secret", "", nil, http.StatusMethodNotAllowed, 0},
+		{"wrong secret", http.MethodPost, "/chirpstack", "guess", uplink, nil, http.StatusUnauthorized, 0},
+		{"invalid json", http.MethodPost, "/chirpstack", "secret", `{"rxInfo":`, nil, http.StatusBadRequest, 0},
+		{"no receptions", http.MethodPost, "/chirpstack", "secret", `{"txInfo":{"dr":5}}`, nil, http.StatusNoContent, 0},
+		{"duplicate", http.MethodPost, "/chirpstack", "secret", uplink, errors.Wrap(model.DuplicateRowError, "row"), http.StatusNoContent, 0},
+		{"store failure", http.MethodPost, "/chirpstack", "secret", uplink, errors.New("disk full"), http.StatusInternalServerError, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := &memoryStore{err: test.err}
+			handler := &WebhookHandler{
+				Format:       ChirpStack,
+				Store:        store,
+				SecretHeader: "X-Webhook-Secret",
+				Secret:       "secret",
+			}
+
+			request := httptest.NewRequest(test.method, test.url, strings.NewReader(test.body))
+			request.Header.Set("X-Webhook-Secret", test.secret)
+			recorder := httptest.NewRecorder()
+
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Errorf("expected status %d, got %d (%s)", test.status, recorder.Code, recorder.Body)
+			}
+			if len(store.rows) != test.rows {
+				t.Errorf("expected %d rows, got %d", test.rows, len(store.rows))
+			}
+		})
+	}
+}
@@ -23,12 +23,16 @@
 package model
 
 import (
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"math"
+	"time"
 
+	"github.com/apex/log"
 	"github.com/brocaar/lorawan"
+	"github.com/bullettime/lora-coverage/region"
 	"github.com/pkg/errors"
-	"github.com/spf13/viper"
 )
 
 type Coverage struct {
@@ -44,98 +48,330 @@ type Coverage struct {
 	Payload    string
 	Latitude   float64
 	Longitude  float64
+	// LocationSource tells where the latitude and longitude come from.
+	LocationSource string
+
+	// regional parameters, see Annotate
+	Region          string
+	Channel         int
+	DataRateIndex   int
+	Modulation      string
+	SpreadingFactor int
+	Bandwidth       int
+	BitRate         int
+	Sensitivity     float64
+	MaxEIRP         float64
+
+	// CodingRate is the LoRa coding rate, eg. 4/5
+	CodingRate string
+	// Airtime is the time on air of the frame, see Annotate
+	Airtime time.Duration
+
+	// frame header, see SetFrameHeader
+	MType string
+	FCnt  *uint32
+	FPort *uint8
+	FCtrl FCtrl
+	// FOpts are the MAC commands in the frame header (hex).
+	FOpts       string
+	MACCommands []MACCommand
+	// MIC of the frame (hex), it identifies the frame together with the device and
+	// the frame counter.
+	MIC string
 }
 
+// Modulations as the packet forwarder reports them.
+const (
+	ModulationLoRa = "LORA"
+	ModulationFSK  = "FSK"
+)
+
+// UnknownPower is the power of a coverage row when the payload contains no power.
+const UnknownPower int8 = 127
+
 var (
 	InvalidCrcError          = errors.New("invalid crc")
 	InvalidMicError          = errors.New("invalid mic")
 	InvalidMacPayloadError   = errors.New("invalid mac payload")
 	InvalidFramePayloadError = errors.New("invalid frame payload")
 	InvalidPayloadError      = errors.New("invalid payload")
+	DuplicateRowError        = errors.New("duplicate row")
 )
 
-func (c *Coverage) Unmarshal(data []byte) error {
+// Unmarshal fills the coverage row with the data of an rx packet in json, the keys
+// of the device and the channel plan are taken from the model (see SetDeviceKeys).
+func (c *Coverage) Unmarshal(data []byte, m *Model) error {
 	var packet RxPacket
 
 	if err := json.Unmarshal(data, &packet); err != nil {
 		return err
 	}
 
+	if err := m.SetDeviceKeys(&packet); err != nil {
+		return err
+	}
+
+	return c.FromRxPacket(&packet)
+}
+
+// FromRxPacket fills the coverage row with the data of a received packet, the keys
+// of the device must be set on the packet (see Model.SetDeviceKeys).
+func (c *Coverage) FromRxPacket(packet *RxPacket) error {
 	if packet.Crc < 0 {
 		return InvalidCrcError
 	}
 
-	phyPayload, err := getDecryptedPayload([]byte(packet.Data))
+	header, err := packet.FrameHeader()
+	if err == NotDataFrameError {
+		return InvalidMacPayloadError
+	}
 	if err != nil {
 		return err
 	}
 
-	macPayload, ok := phyPayload.MACPayload.(*lorawan.MACPayload)
-	if !ok {
-		return InvalidMacPayloadError
+	keys := packet.Keys
+	if keys == nil {
+		return errors.Errorf("no keys for device %s", header.DevAddr)
 	}
 
-	payload, ok := macPayload.FRMPayload[0].(*lorawan.DataPayload)
-	if !ok {
-		return InvalidFramePayloadError
+	var payload []byte
+	var fCnt uint32
+	if keys.Version == LoRaWAN11 {
+		payload, header.FOpts, fCnt, err = getDecryptedPayload11(packet, header, keys)
+	} else {
+		payload, fCnt, err = getDecryptedPayload(packet, keys)
+	}
+	if err != nil {
+		return err
 	}
 
 	c.GatewayMac = packet.GatewayMac
-	c.DeviceAddr = macPayload.FHDR.DevAddr
+	c.DeviceAddr.UnmarshalText([]byte(header.DevAddr))
 	c.Time = packet.Time
 	c.Frequency = packet.Frequency
 	c.DataRate = packet.DataR
 	c.RSSI = packet.RSSI
 	c.SNR = packet.SNR
 	c.Size = packet.Size
-	c.Payload = hex.EncodeToString(payload.Bytes[:])
+	c.CodingRate = packet.CodingRate
 
-	lat, lon, err := getLocation(payload.Bytes[:])
+	// FOpts are kept undecoded when they contain an unknown command
+	c.SetFrameHeader(header)
+	c.FCnt = &fCnt
+
+	return c.SetPayload(payload)
+}
+
+// SetPayload stores the decrypted application payload and decodes the location and
+// the transmit power from it.
+func (c *Coverage) SetPayload(data []byte) error {
+	c.Payload = hex.EncodeToString(data)
+
+	pwr, powerErr := getPower(data)
+	c.Power = pwr
+
+	lat, lon, err := getLocation(data)
 	if err != nil {
 		return err
 	}
 
 	c.Latitude = lat
 	c.Longitude = lon
+	c.LocationSource = LocationSourcePayload
 
-	pwr, err := getPower(payload.Bytes[:])
-	c.Power = pwr
+	return powerErr
+}
+
+// Annotate sets the regional parameters and the time on air of the row. When the row has no data rate, it
+// is taken from the DataRateIndex. region.OutOfPlanError is returned when the
+// frequency is not an uplink channel of the region, the row is annotated anyway.
+func (c *Coverage) Annotate(plan *region.Plan) error {
+	var dr region.DataRate
+	var err error
+
+	if c.DataRate.LoRa == "" && c.DataRate.FSK == 0 {
+		dr, err = plan.DataRate(c.DataRateIndex)
+		if err == nil {
+			c.DataRate = newDataRate(dr)
+		}
+	} else {
+		dr, err = c.DataRate.RegionDataRate()
+	}
 	if err != nil {
 		return err
 	}
 
-	return nil
+	annotation, err := plan.Annotate(int(math.Round(c.Frequency*1e6)), dr)
+
+	c.Region = annotation.Region
+	c.Channel = annotation.Channel
+	c.DataRateIndex = annotation.DataRateIndex
+	c.Modulation = ModulationLoRa
+	if dr.Modulation == region.FSK {
+		c.Modulation = ModulationFSK
+	}
+	c.SpreadingFactor = dr.SpreadingFactor
+	c.Bandwidth = dr.Bandwidth
+	c.BitRate = dr.BitRate
+	c.Sensitivity = annotation.Sensitivity
+	c.MaxEIRP = annotation.MaxEIRP
+	c.Airtime = dr.TimeOnAir(int(c.Size), FrameSettings(c.CodingRate))
+
+	return err
+}
+
+// FrameSettings returns the settings of a LoRaWAN uplink with the coding rate, 4/5
+// when it is unknown.
+func FrameSettings(codingRate string) region.FrameSettings {
+	frame := region.UplinkFrame
+	if cr, err := region.ParseCodingRate(codingRate); err == nil {
+		frame.CodingRate = cr
+	}
+	return frame
+}
+
+// RegionDataRate returns the data rate as it is described in the region package.
+func (d DataRate) RegionDataRate() (region.DataRate, error) {
+	if d.LoRa != "" {
+		return region.ParseDataRate(d.LoRa)
+	}
+	if d.FSK != 0 {
+		return region.DataRate{Modulation: region.FSK, BitRate: int(d.FSK)}, nil
+	}
+	return region.DataRate{}, region.UnknownDataRateError
+}
+
+// fCntCandidates returns the full frame counters to try for the 16 bit frame counter.
+func (p *RxPacket) fCntCandidates(fCnt uint16) []uint32 {
+	if len(p.FCntCandidates) > 0 {
+		return p.FCntCandidates
+	}
+	return []uint32{uint32(fCnt)}
 }
 
-func getDecryptedPayload(data []byte) (*lorawan.PHYPayload, error) {
+// Channel returns the data rate and channel index of the packet in its channel plan,
+// -1 when they are unknown.
+func (p *RxPacket) Channel() (int, int) {
+	if p.Plan == nil {
+		return -1, -1
+	}
+
+	dr, err := p.DataR.RegionDataRate()
+	if err != nil {
+		return -1, -1
+	}
+
+	index := p.Plan.DataRateIndex(dr)
+	if index < 0 {
+		return -1, -1
+	}
+
+	return index, p.Plan.ChannelIndex(int(math.Round(p.Frequency*1e6)), index)
+}
+
+func newDataRate(dr region.DataRate) DataRate {
+	if dr.Modulation == region.FSK {
+		return DataRate{FSK: uint32(dr.BitRate)}
+	}
+	return DataRate{LoRa: dr.String()}
+}
+
+// getDecryptedPayload validates and decrypts a LoRaWAN 1.0 frame and returns its
+// payload and the full frame counter, the first of the candidates with a valid MIC.
+func getDecryptedPayload(packet *RxPacket, keys *DeviceKeys) ([]byte, uint32, error) {
 	var phy lorawan.PHYPayload
-	if err := phy.UnmarshalText(data); err != nil {
-		return nil, err
+	if err := phy.UnmarshalText([]byte(packet.Data)); err != nil {
+		return nil, 0, err
 	}
 
-	var nwkSKey lorawan.AES128Key
-	if err := nwkSKey.UnmarshalText([]byte(viper.GetString("lora.nwkskey"))); err != nil {
-		return nil, err
+	macPayload, ok := phy.MACPayload.(*lorawan.MACPayload)
+	if !ok {
+		return nil, 0, InvalidMacPayloadError
 	}
 
-	ok, err := phy.ValidateMIC(nwkSKey)
+	valid := false
+	for _, fCnt := range packet.fCntCandidates(uint16(macPayload.FHDR.FCnt)) {
+		macPayload.FHDR.FCnt = fCnt
+
+		ok, err := phy.ValidateMIC(keys.FNwkSIntKey)
+		if err != nil {
+			return nil, 0, err
+		}
+		if ok {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return nil, 0, InvalidMicError
+	}
+
+	if err := phy.DecryptFRMPayload(keys.AppSKey); err != nil {
+		return nil, 0, err
+	}
+
+	// frames without FRMPayload or with MAC commands on port 0
+	if len(macPayload.FRMPayload) == 0 {
+		return nil, 0, InvalidFramePayloadError
+	}
+	payload, ok := macPayload.FRMPayload[0].(*lorawan.DataPayload)
+	if !ok {
+		return nil, 0, InvalidFramePayloadError
+	}
+
+	return payload.Bytes, macPayload.FHDR.FCnt, nil
+}
+
+// getDecryptedPayload11 validates a LoRaWAN 1.1 frame and returns its decrypted
+// FRMPayload and FOpts and the full frame counter.
+func getDecryptedPayload11(packet *RxPacket, header *FrameHeader, keys *DeviceKeys) ([]byte, []byte, uint32, error) {
+	phy, err := base64.StdEncoding.DecodeString(packet.Data)
 	if err != nil {
-		return nil, err
+		return nil, nil, 0, errors.Wrap(err, "invalid PHYPayload")
+	}
+
+	ctx := MICContext{ConfFCnt: packet.ConfFCnt}
+	ctx.TxDR, ctx.TxCh = packet.Channel()
+
+	var ok, complete bool
+	for _, fCnt := range packet.fCntCandidates(header.FCnt) {
+		ctx.FCnt = fCnt
+
+		ok, complete, err = ValidateUplinkMIC11(phy, keys, ctx)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		if ok {
+			break
+		}
 	}
 	if !ok {
-		return nil, InvalidMicError
+		return nil, nil, 0, InvalidMicError
+	}
+	if !complete {
+		log.WithFields(log.Fields{"device": header.DevAddr, "fcnt": ctx.FCnt}).
+			Debug("only the FNwkSIntKey half of the mic was validated")
 	}
 
-	var appSKey lorawan.AES128Key
-	if err := appSKey.UnmarshalText([]byte(viper.GetString("lora.appskey"))); err != nil {
-		return nil, err
+	devAddr := phy[1:5]
+
+	fOpts, err := EncryptFRMPayload(keys.NwkSEncKey, true, devAddr, ctx.FCnt, header.FOpts)
+	if err != nil {
+		return nil, nil, 0, err
 	}
 
-	if err := phy.DecryptFRMPayload(appSKey); err != nil {
-		return nil, err
+	// frames without payload and MAC commands on port 0
+	if header.FPort == nil || *header.FPort == 0 {
+		return nil, fOpts, ctx.FCnt, InvalidFramePayloadError
+	}
+
+	start := 8 + len(header.FOpts) + 1
+	payload, err := EncryptFRMPayload(keys.AppSKey, true, devAddr, ctx.FCnt, phy[start:len(phy)-4])
+	if err != nil {
+		return nil, nil, 0, err
 	}
 
-	return &phy, nil
+	return payload, fOpts, ctx.FCnt, nil
 }
 
 func getLocation(data []byte) (float64, float64, error) {
@@ -153,7 +389,7 @@ func getLocation(data []byte) (float64, float64, error) {
 
 func getPower(data []byte) (int8, error) {
 	if !isValidPayload(data) || len(data) < 7 {
-		return 127, InvalidPayloadError
+		return UnknownPower, InvalidPayloadError
 	}
 
 	power := int8(data[6])
@@ -0,0 +1,162 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package model
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+
+	"github.com/brocaar/lorawan"
+	"github.com/jacobsa/crypto/cmac"
+	"github.com/pkg/errors"
+)
+
+// MICContext holds the inputs of the LoRaWAN 1.1 uplink MIC that are not part of the
+// frame.
+type MICContext struct {
+	// FCnt is the full 32 bit frame counter.
+	FCnt uint32
+	// ConfFCnt is the frame counter of the confirmed downlink that is acknowledged,
+	// nil when it is unknown.
+	ConfFCnt *uint16
+	// TxDR and TxCh are the data rate and channel index of the uplink, negative when
+	// they are unknown.
+	TxDR int
+	TxCh int
+}
+
+// ValidateUplinkMIC11 validates the MIC of a LoRaWAN 1.1 uplink. The half of the MIC
+// calculated with the FNwkSIntKey is always checked, the half calculated with the
+// SNwkSIntKey only when all its inputs are known: the data rate and channel and, for
+// frames with the ACK bit, the acknowledged frame counter. The second result tells
+// whether the complete MIC was checked.
+func ValidateUplinkMIC11(phy []byte, keys *DeviceKeys, ctx MICContext) (bool, bool, error) {
+	if len(phy) < 12 {
+		return false, false, errors.New("PHYPayload too short")
+	}
+
+	msg, mic := phy[:len(phy)-4], phy[len(phy)-4:]
+	ack := phy[5]&0x20 != 0
+
+	var confFCnt uint16
+	complete := ctx.TxDR >= 0 && ctx.TxCh >= 0
+	if ack {
+		if ctx.ConfFCnt != nil {
+			confFCnt = *ctx.ConfFCnt
+		} else {
+			complete = false
+		}
+	}
+
+	cmacF, err := micHalf(keys.FNwkSIntKey, msg, ctx.FCnt, 0, 0, 0)
+	if err != nil {
+		return false, false, err
+	}
+	if !bytes.Equal(cmacF, mic[2:]) {
+		return false, complete, nil
+	}
+
+	if !complete {
+		return true, false, nil
+	}
+
+	cmacS, err := micHalf(keys.SNwkSIntKey, msg, ctx.FCnt, confFCnt, ctx.TxDR, ctx.TxCh)
+	if err != nil {
+		return false, true, err
+	}
+
+	return bytes.Equal(cmacS, mic[:2]), true, nil
+}
+
+// micHalf returns the half of a LoRaWAN 1.1 uplink MIC calculated with the key, the
+// acknowledged frame counter, data rate and channel are zero for the FNwkSIntKey.
+func micHalf(key lorawan.AES128Key, msg []byte, fCnt uint32, confFCnt uint16, txDR, txCh int) ([]byte, error) {
+	b := micBlock(msg, fCnt, 0)
+	binary.LittleEndian.PutUint16(b[1:3], confFCnt)
+	b[3] = byte(txDR)
+	b[4] = byte(txCh)
+
+	sum, err := calculateCMAC(key, b[:], msg)
+	if err != nil {
+		return nil, err
+	}
+	return sum[:2], nil
+}
+
+// micBlock returns the B0 block of the MIC of a frame without MIC.
+func micBlock(msg []byte, fCnt uint32, dir byte) [16]byte {
+	var b [16]byte
+	b[0] = 0x49
+	b[5] = dir
+	copy(b[6:10], msg[1:5])
+	binary.LittleEndian.PutUint32(b[10:14], fCnt)
+	b[15] = byte(len(msg))
+	return b
+}
+
+func calculateCMAC(key lorawan.AES128Key, blocks ...[]byte) ([]byte, error) {
+	hash, err := cmac.New(key[:])
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating cmac")
+	}
+
+	for _, block := range blocks {
+		if _, err := hash.Write(block); err != nil {
+			return nil, errors.Wrap(err, "error calculating cmac")
+		}
+	}
+
+	return hash.Sum(nil), nil
+}
+
+// EncryptFRMPayload encrypts (or decrypts) a FRMPayload: with the NwkSEncKey on port 0
+// and with the AppSKey otherwise. It also encrypts the FOpts of a LoRaWAN 1.1 frame
+// with the NwkSEncKey, the counter block of FOpts ends with 0x01 since the 1.1 errata
+// which makes it the first block of a FRMPayload. The device address is in the byte
+// order of the frame.
+func EncryptFRMPayload(key lorawan.AES128Key, uplink bool, devAddr []byte, fCnt uint32, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating cipher")
+	}
+
+	out := make([]byte, len(data))
+
+	var a, s [16]byte
+	a[0] = 0x01
+	if !uplink {
+		a[5] = 0x01
+	}
+	copy(a[6:10], devAddr)
+	binary.LittleEndian.PutUint32(a[10:14], fCnt)
+
+	for i := 0; i < len(data); i += 16 {
+		a[15] = byte(i/16 + 1)
+		block.Encrypt(s[:], a[:])
+		for j := i; j < i+16 && j < len(data); j++ {
+			out[j] = data[j] ^ s[j-i]
+		}
+	}
+
+	return out, nil
+}
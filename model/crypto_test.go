@@ -0,0 +1,180 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package model
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+
+	"github.com/brocaar/lorawan"
+	"github.com/bullettime/lora-coverage/region"
+)
+
+var testKey = lorawan.AES128Key{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+func TestEncryptFRMPayload(t *testing.T) {
+	plain := []byte{0x07, 0xc6, 0xcb, 0x00, 0x2a, 0x80, 0x0e, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	fPort := uint8(1)
+
+	phy := lorawan.PHYPayload{
+		MHDR: lorawan.MHDR{MType: lorawan.UnconfirmedDataUp, Major: lorawan.LoRaWANR1},
+		MACPayload: &lorawan.MACPayload{
+			FHDR:       lorawan.FHDR{DevAddr: lorawan.DevAddr{0x26, 0x01, 0x1b, 0x2c}, FCnt: 5},
+			FPort:      &fPort,
+			FRMPayload: []lorawan.Payload{&lorawan.DataPayload{Bytes: append([]byte(nil), plain...)}},
+		},
+	}
+	if err := phy.EncryptFRMPayload(testKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := phy.SetMIC(testKey); err != nil {
+		t.Fatal(err)
+	}
+	data, err := phy.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := EncryptFRMPayload(testKey, true, data[1:5], 5, data[9:len(data)-4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plain) {
+		t.Errorf("wrong payload: %x", decrypted)
+	}
+}
+
+// frame11 builds a LoRaWAN 1.1 uplink with the keys, FOpts and payload.
+func frame11(t *testing.T, keys *DeviceKeys, fCtrl byte, fCnt uint16, fOpts, payload []byte, ctx MICContext) []byte {
+	devAddr := []byte{0x2c, 0x1b, 0x01, 0x26}
+
+	encFOpts, err := EncryptFRMPayload(keys.NwkSEncKey, true, devAddr, uint32(fCnt), fOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encPayload, err := EncryptFRMPayload(keys.AppSKey, true, devAddr, uint32(fCnt), payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := append([]byte{0x40}, devAddr...)
+	msg = append(msg, fCtrl|byte(len(fOpts)), byte(fCnt), byte(fCnt>>8))
+	msg = append(msg, encFOpts...)
+	msg = append(msg, 1)
+	msg = append(msg, encPayload...)
+
+	var confFCnt uint16
+	if ctx.ConfFCnt != nil {
+		confFCnt = *ctx.ConfFCnt
+	}
+
+	b0 := micBlock(msg, uint32(fCnt), 0)
+	b1 := b0
+	binary.LittleEndian.PutUint16(b1[1:3], confFCnt)
+	b1[3], b1[4] = byte(ctx.TxDR), byte(ctx.TxCh)
+
+	cmacF, err := calculateCMAC(keys.FNwkSIntKey, b0[:], msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmacS, err := calculateCMAC(keys.SNwkSIntKey, b1[:], msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return append(append(msg, cmacS[:2]...), cmacF[:2]...)
+}
+
+func TestValidateUplinkMIC11(t *testing.T) {
+	keys := &DeviceKeys{Version: LoRaWAN11, FNwkSIntKey: testKey, SNwkSIntKey: lorawan.AES128Key{1},
+		NwkSEncKey: lorawan.AES128Key{2}, AppSKey: lorawan.AES128Key{3}}
+	confFCnt := uint16(9)
+	ctx := MICContext{FCnt: 10, ConfFCnt: &confFCnt, TxDR: 5, TxCh: 1}
+
+	phy := frame11(t, keys, 0x20, 10, nil, []byte{1, 2, 3}, ctx)
+
+	if ok, complete, err := ValidateUplinkMIC11(phy, keys, ctx); !ok || !complete || err != nil {
+		t.Errorf("expected a valid complete mic: %v %v %v", ok, complete, err)
+	}
+
+	wrong := uint16(8)
+	ctx.ConfFCnt = &wrong
+	if ok, _, _ := ValidateUplinkMIC11(phy, keys, ctx); ok {
+		t.Error("expected an invalid mic with the wrong ConfFCnt")
+	}
+
+	ctx.ConfFCnt = nil
+	if ok, complete, _ := ValidateUplinkMIC11(phy, keys, ctx); !ok || complete {
+		t.Errorf("expected only the FNwkSIntKey half to be validated: %v %v", ok, complete)
+	}
+
+	phy[len(phy)-1] ^= 0xff
+	if ok, _, _ := ValidateUplinkMIC11(phy, keys, ctx); ok {
+		t.Error("expected an invalid mic")
+	}
+}
+
+func TestFromRxPacket11(t *testing.T) {
+	plan, err := region.Get("eu868")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := KeyStore{Devices: map[string]KeySettings{"26011b2c": {
+		Version:     "1.1",
+		FNwkSIntKey: "000102030405060708090a0b0c0d0e0f",
+		SNwkSIntKey: "01000000000000000000000000000000",
+		NwkSEncKey:  "02000000000000000000000000000000",
+		AppSKey:     "03000000000000000000000000000000",
+	}}}
+	keys, err := store.DeviceKeys("26011B2C")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// SF7BW125 (DR5) on 868.3 MHz (channel 1), DevStatusAns in FOpts
+	phy := frame11(t, keys, 0, 3, []byte{0x06, 0xc8, 0x3d}, []byte{0x07, 0xc6, 0xcb, 0x00, 0x2a, 0x80, 0x0e},
+		MICContext{FCnt: 3, TxDR: 5, TxCh: 1})
+
+	packet := RxPacket{Frequency: 868.3, Crc: 1, DataR: DataRate{LoRa: "SF7BW125"}, Size: uint16(len(phy)),
+		Data: base64.StdEncoding.EncodeToString(phy), Keys: keys, Plan: plan}
+
+	var c Coverage
+	if err := c.FromRxPacket(&packet); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if c.DeviceAddr.String() != "26011b2c" || c.Power != 14 || !almostEqual(c.Latitude, 50.9643) {
+		t.Errorf("wrong row: %+v", c)
+	}
+	if c.FOpts != "06c83d" || len(c.MACCommands) != 1 || *c.MACCommands[0].Battery != 200 {
+		t.Errorf("wrong FOpts: %s %v", c.FOpts, c.MACCommands)
+	}
+
+	// the MIC covers the channel
+	packet.Frequency = 868.1
+	if err := c.FromRxPacket(&packet); err != InvalidMicError {
+		t.Errorf("expected InvalidMicError, got %v", err)
+	}
+}
@@ -22,9 +22,26 @@
 
 package model
 
-import "github.com/paulmach/go.geojson"
+import (
+	"time"
+
+	"github.com/paulmach/go.geojson"
+)
 
 type db interface {
 	AddCoverageRow(*Coverage) error
 	GetGeoJSonPoints(string, string) ([]*geojson.Feature, error)
+	GetCoverageRows(string, string) ([]*Coverage, error)
+	GetFileOffset(string) (*FileOffset, error)
+	SetFileOffset(*FileOffset) error
+	GetRowsWithoutLocation(string) ([]*Location, error)
+	SetLocations([]*Location) error
+	AddReception(*RxPacket) error
+	AddCrcError(*RxPacket) error
+	AddDownlink(*Downlink) error
+	SetTxAck(*TxAck) error
+	GetDownlinks(string) ([]*Downlink, error)
+	GetFCntState(string) (*FCntState, error)
+	SetFCntState(*FCntState) error
+	GetFrameTimes(string, string, uint32, string) ([]time.Time, error)
 }
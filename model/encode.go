@@ -0,0 +1,131 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package model
+
+import (
+	"math"
+
+	"github.com/brocaar/lorawan"
+	"github.com/pkg/errors"
+)
+
+// UplinkFrame is the content of a data uplink, see EncodeUplink.
+type UplinkFrame struct {
+	DevAddr   lorawan.DevAddr
+	FCnt      uint32
+	FPort     uint8
+	Confirmed bool
+	ADR       bool
+	ACK       bool
+	// Payload is the FRMPayload before encryption.
+	Payload []byte
+}
+
+// EncodePayload returns the payload of the coverage application with a location and,
+// unless it is UnknownPower, the transmit power. It is the reverse of the decoding
+// in FromRxPacket: the coordinates are 24 bit unsigned values in 1/10000 degrees.
+func EncodePayload(latitude, longitude float64, power int8) ([]byte, error) {
+	lat := math.Round(latitude * 10000)
+	lon := math.Round(longitude * 10000)
+	if lat < 0 || lat >= 1<<24 || lon < 0 || lon >= 1<<24 {
+		return nil, errors.Errorf("location can not be encoded: %f, %f", latitude, longitude)
+	}
+
+	data := []byte{
+		byte(uint32(lat) >> 16), byte(uint32(lat) >> 8), byte(uint32(lat)),
+		byte(uint32(lon) >> 16), byte(uint32(lon) >> 8), byte(uint32(lon)),
+	}
+	if power != UnknownPower {
+		data = append(data, byte(power))
+	}
+
+	return data, nil
+}
+
+// EncodeUplink returns the PHYPayload of an uplink, encrypted and with a valid MIC
+// for the keys of the device. The MIC of a LoRaWAN 1.1 uplink also depends on the
+// context (the frame counter in it is ignored), see ValidateUplinkMIC11.
+func EncodeUplink(frame *UplinkFrame, keys *DeviceKeys, ctx MICContext) ([]byte, error) {
+	mType := lorawan.UnconfirmedDataUp
+	if frame.Confirmed {
+		mType = lorawan.ConfirmedDataUp
+	}
+
+	fPort := frame.FPort
+	phy := lorawan.PHYPayload{
+		MHDR: lorawan.MHDR{MType: mType, Major: lorawan.LoRaWANR1},
+		MACPayload: &lorawan.MACPayload{
+			FHDR: lorawan.FHDR{
+				DevAddr: frame.DevAddr,
+				FCtrl:   lorawan.FCtrl{ADR: frame.ADR, ACK: frame.ACK},
+				FCnt:    frame.FCnt,
+			},
+			FPort:      &fPort,
+			FRMPayload: []lorawan.Payload{&lorawan.DataPayload{Bytes: frame.Payload}},
+		},
+	}
+
+	key := keys.AppSKey
+	if fPort == 0 {
+		key = keys.NwkSEncKey
+	}
+	if err := phy.EncryptFRMPayload(key); err != nil {
+		return nil, errors.Wrap(err, "error encrypting payload")
+	}
+
+	if keys.Version != LoRaWAN11 {
+		if err := phy.SetMIC(keys.FNwkSIntKey); err != nil {
+			return nil, errors.Wrap(err, "error calculating mic")
+		}
+		data, err := phy.MarshalBinary()
+		return data, errors.Wrap(err, "error marshalling PHYPayload")
+	}
+
+	data, err := phy.MarshalBinary()
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshalling PHYPayload")
+	}
+	if ctx.TxDR < 0 || ctx.TxCh < 0 {
+		return nil, errors.New("data rate and channel are needed for the mic")
+	}
+
+	msg := data[:len(data)-4]
+
+	var confFCnt uint16
+	if frame.ACK && ctx.ConfFCnt != nil {
+		confFCnt = *ctx.ConfFCnt
+	}
+
+	cmacF, err := micHalf(keys.FNwkSIntKey, msg, frame.FCnt, 0, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	cmacS, err := micHalf(keys.SNwkSIntKey, msg, frame.FCnt, confFCnt, ctx.TxDR, ctx.TxCh)
+	if err != nil {
+		return nil, err
+	}
+
+	copy(data[len(data)-4:], append(cmacS, cmacF...))
+
+	return data, nil
+}
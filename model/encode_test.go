@@ -0,0 +1,96 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package model
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/brocaar/lorawan"
+	"github.com/bullettime/lora-coverage/region"
+)
+
+func TestEncodePayload(t *testing.T) {
+	data, err := EncodePayload(50.9643, 1.088, 14)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	latitude, longitude, err := getLocation(data)
+	if err != nil || !almostEqual(latitude, 50.9643) || !almostEqual(longitude, 1.088) {
+		t.Errorf("wrong location: %f %f %v", latitude, longitude, err)
+	}
+	if power, err := getPower(data); err != nil || power != 14 {
+		t.Errorf("wrong power: %d %v", power, err)
+	}
+
+	if data, _ := EncodePayload(50.9643, 1.088, UnknownPower); len(data) != 6 {
+		t.Errorf("expected a payload without power: %x", data)
+	}
+	if _, err := EncodePayload(-1, 1.088, 14); err == nil {
+		t.Error("expected an error for a negative latitude")
+	}
+}
+
+func TestEncodeUplink(t *testing.T) {
+	plan, err := region.Get("eu868")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := EncodePayload(50.9643, 1.088, 14)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	frame := &UplinkFrame{DevAddr: lorawan.DevAddr{0x26, 0x01, 0x1b, 0x2c}, FCnt: 7, FPort: 1, Payload: payload}
+
+	tests := []*DeviceKeys{
+		{Version: LoRaWAN10, FNwkSIntKey: testKey, SNwkSIntKey: testKey, NwkSEncKey: testKey,
+			AppSKey: lorawan.AES128Key{3}},
+		{Version: LoRaWAN11, FNwkSIntKey: testKey, SNwkSIntKey: lorawan.AES128Key{1},
+			NwkSEncKey: lorawan.AES128Key{2}, AppSKey: lorawan.AES128Key{3}},
+	}
+
+	for _, keys := range tests {
+		// SF7BW125 (DR5) on 868.3 MHz (channel 1)
+		phy, err := EncodeUplink(frame, keys, MICContext{TxDR: 5, TxCh: 1})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", keys.Version, err)
+		}
+
+		packet := RxPacket{Frequency: 868.3, Crc: 1, DataR: DataRate{LoRa: "SF7BW125"}, Size: uint16(len(phy)),
+			Data: base64.StdEncoding.EncodeToString(phy), Keys: keys, Plan: plan}
+
+		var c Coverage
+		if err := c.FromRxPacket(&packet); err != nil {
+			t.Fatalf("%s: unexpected error: %v", keys.Version, err)
+		}
+		if c.DeviceAddr.String() != "26011b2c" || *c.FCnt != 7 || c.Power != 14 || !almostEqual(c.Latitude, 50.9643) {
+			t.Errorf("%s: wrong row: %+v", keys.Version, c)
+		}
+	}
+
+	if _, err := EncodeUplink(frame, tests[1], MICContext{TxDR: -1, TxCh: -1}); err == nil {
+		t.Error("expected an error without data rate and channel")
+	}
+}
@@ -0,0 +1,89 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package model
+
+import (
+	"time"
+)
+
+// FCntState is the last full 32 bit frame counter received from a device.
+type FCntState struct {
+	Device string
+	FCnt   uint32
+	Time   time.Time
+	// Resets is the number of times the counter went back, eg. after a rejoin.
+	Resets int
+}
+
+// FCntCandidates returns the full frame counters a 16 bit frame counter can stand for,
+// the most likely first: the same 65536 block as the last frame counter, the next
+// block (rollover), the previous block (an older import) and the first block (reset
+// after a rejoin). Only the frame counter itself is returned without state.
+func (s *FCntState) FCntCandidates(fCnt uint16) []uint32 {
+	if s == nil {
+		return []uint32{uint32(fCnt)}
+	}
+
+	block := s.FCnt &^ 0xffff
+	var candidates []uint32
+
+	add := func(c uint32) {
+		for _, existing := range candidates {
+			if existing == c {
+				return
+			}
+		}
+		candidates = append(candidates, c)
+	}
+
+	if uint32(fCnt) >= s.FCnt&0xffff {
+		add(block | uint32(fCnt))
+		add((block + 0x10000) | uint32(fCnt))
+	} else {
+		add((block + 0x10000) | uint32(fCnt))
+		add(block | uint32(fCnt))
+	}
+	if block > 0 {
+		add((block - 0x10000) | uint32(fCnt))
+	}
+	add(uint32(fCnt))
+
+	return candidates
+}
+
+// Update adds a frame counter received at the given time and tells whether the
+// counter was reset. Frames older than the state are ignored.
+func (s *FCntState) Update(fCnt uint32, t time.Time) bool {
+	if t.Before(s.Time) {
+		return false
+	}
+
+	reset := fCnt < s.FCnt
+	if reset {
+		s.Resets++
+	}
+
+	s.FCnt = fCnt
+	s.Time = t
+
+	return reset
+}
@@ -0,0 +1,174 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package model
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brocaar/lorawan"
+)
+
+func TestFCntCandidates(t *testing.T) {
+	var none *FCntState
+	if c := none.FCntCandidates(5); !reflect.DeepEqual(c, []uint32{5}) {
+		t.Errorf("wrong candidates without state: %v", c)
+	}
+
+	tests := []struct {
+		last       uint32
+		fCnt       uint16
+		candidates []uint32
+	}{
+		{10, 11, []uint32{11, 0x10000 + 11}},
+		{0x1fff0, 0xfff1, []uint32{0x1fff1, 0x2fff1, 0xfff1}},
+		// rollover
+		{0x1fff0, 0x0002, []uint32{0x20002, 0x10002, 0x00002}},
+	}
+
+	for _, test := range tests {
+		state := &FCntState{FCnt: test.last}
+		if c := state.FCntCandidates(test.fCnt); !reflect.DeepEqual(c, test.candidates) {
+			t.Errorf("%x %x: expected %x, got %x", test.last, test.fCnt, test.candidates, c)
+		}
+	}
+}
+
+func TestFCntUpdate(t *testing.T) {
+	t0 := time.Date(2018, 4, 5, 21, 46, 4, 0, time.UTC)
+	state := &FCntState{FCnt: 0x10005, Time: t0}
+
+	if state.Update(0x10006, t0.Add(time.Minute)) || state.FCnt != 0x10006 {
+		t.Errorf("unexpected reset: %+v", state)
+	}
+	// older frame of an earlier import
+	if state.Update(3, t0) || state.FCnt != 0x10006 {
+		t.Errorf("older frame changed the state: %+v", state)
+	}
+	if !state.Update(0, t0.Add(2*time.Minute)) || state.Resets != 1 || state.FCnt != 0 {
+		t.Errorf("expected a reset: %+v", state)
+	}
+}
+
+func TestFromRxPacketRollover(t *testing.T) {
+	fPort := uint8(1)
+	phy := lorawan.PHYPayload{
+		MHDR: lorawan.MHDR{MType: lorawan.UnconfirmedDataUp, Major: lorawan.LoRaWANR1},
+		MACPayload: &lorawan.MACPayload{
+			FHDR:       lorawan.FHDR{DevAddr: lorawan.DevAddr{0x26, 0x01, 0x1b, 0x2c}, FCnt: 0x10002},
+			FPort:      &fPort,
+			FRMPayload: []lorawan.Payload{&lorawan.DataPayload{Bytes: []byte{0x07, 0xc6, 0xcb, 0x00, 0x2a, 0x80, 0x0e}}},
+		},
+	}
+	if err := phy.EncryptFRMPayload(testKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := phy.SetMIC(testKey); err != nil {
+		t.Fatal(err)
+	}
+	data, err := phy.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packet := RxPacket{Crc: 1, Data: string(data), Keys: &DeviceKeys{Version: LoRaWAN10, FNwkSIntKey: testKey,
+		SNwkSIntKey: testKey, NwkSEncKey: testKey, AppSKey: testKey}}
+
+	var c Coverage
+	if err := c.FromRxPacket(&packet); err != InvalidMicError {
+		t.Errorf("expected InvalidMicError with the 16 bit counter, got %v", err)
+	}
+
+	packet.FCntCandidates = (&FCntState{FCnt: 0xfff0}).FCntCandidates(2)
+	if err := c.FromRxPacket(&packet); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *c.FCnt != 0x10002 || c.Power != 14 {
+		t.Errorf("wrong row: fcnt %x power %d", *c.FCnt, c.Power)
+	}
+}
+
+// fCntDB is a database with only the frame counter states and coverage rows.
+type fCntDB struct {
+	db
+	lock   sync.Mutex
+	rows   int
+	states map[string]FCntState
+}
+
+func (d *fCntDB) AddCoverageRow(*Coverage) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.rows++
+	return nil
+}
+
+func (d *fCntDB) GetFCntState(device string) (*FCntState, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if state, ok := d.states[device]; ok {
+		return &state, nil
+	}
+	return nil, nil
+}
+
+func (d *fCntDB) SetFCntState(s *FCntState) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.states[s.Device] = *s
+	return nil
+}
+
+func TestConcurrentFCnt(t *testing.T) {
+	database := &fCntDB{states: make(map[string]FCntState)}
+	m := New(database, Config{})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				fCnt := uint32(i)
+				c := Coverage{DeviceAddr: lorawan.DevAddr{0x26, 0x01, 0x1b, byte(g % 2)}, FCnt: &fCnt,
+					Region: "EU868", Time: CompactTime(time.Date(2018, 4, 5, 21, 0, i, 0, time.UTC))}
+				if err := m.AddCoverageRow(&c); err != nil {
+					t.Error(err)
+					return
+				}
+
+				packet := RxPacket{Crc: 1, Data: "QCwbASYAAAABmlHTtqk0rneArxA="}
+				if err := m.SetFCntCandidates(&packet); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if database.rows != 800 || database.states["26011b00"].FCnt != 99 || database.states["26011b01"].FCnt != 99 {
+		t.Errorf("wrong result: %d rows, states %+v", database.rows, database.states)
+	}
+}
@@ -0,0 +1,146 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package model
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+)
+
+// message types of the MHDR
+const (
+	MTypeJoinRequest         = "JoinRequest"
+	MTypeJoinAccept          = "JoinAccept"
+	MTypeUnconfirmedDataUp   = "UnconfirmedDataUp"
+	MTypeUnconfirmedDataDown = "UnconfirmedDataDown"
+	MTypeConfirmedDataUp     = "ConfirmedDataUp"
+	MTypeConfirmedDataDown   = "ConfirmedDataDown"
+	MTypeRejoinRequest       = "RejoinRequest"
+	MTypeProprietary         = "Proprietary"
+)
+
+var mTypes = [8]string{MTypeJoinRequest, MTypeJoinAccept, MTypeUnconfirmedDataUp, MTypeUnconfirmedDataDown,
+	MTypeConfirmedDataUp, MTypeConfirmedDataDown, MTypeRejoinRequest, MTypeProprietary}
+
+var NotDataFrameError = errors.New("not a data frame")
+
+// FCtrl holds the flags of the frame control field.
+type FCtrl struct {
+	ADR       bool
+	ADRACKReq bool
+	ACK       bool
+	// FPending is the ClassB flag in uplinks.
+	FPending bool
+	FOptsLen uint8
+}
+
+// FrameHeader is the header of a data frame, it is read without validating the MIC.
+type FrameHeader struct {
+	MType   string
+	DevAddr string
+	FCtrl   FCtrl
+	FCnt    uint16
+	FOpts   []byte
+	// FPort is nil for frames without payload.
+	FPort *uint8
+	// MIC is the message integrity code (hex).
+	MIC string
+}
+
+// ParseFrameHeader reads the header of a data frame from a PHYPayload.
+func ParseFrameHeader(phy []byte) (*FrameHeader, error) {
+	if len(phy) < 1 {
+		return nil, errors.New("empty PHYPayload")
+	}
+
+	h := FrameHeader{MType: mTypes[phy[0]>>5]}
+	switch h.MType {
+	case MTypeUnconfirmedDataUp, MTypeUnconfirmedDataDown, MTypeConfirmedDataUp, MTypeConfirmedDataDown:
+	default:
+		return nil, NotDataFrameError
+	}
+
+	// MHDR, FHDR without FOpts and MIC
+	if len(phy) < 12 {
+		return nil, errors.Errorf("data frame too short: %d bytes", len(phy))
+	}
+
+	h.DevAddr = hex.EncodeToString([]byte{phy[4], phy[3], phy[2], phy[1]})
+	h.FCtrl = FCtrl{
+		ADR:       phy[5]&0x80 != 0,
+		ADRACKReq: phy[5]&0x40 != 0,
+		ACK:       phy[5]&0x20 != 0,
+		FPending:  phy[5]&0x10 != 0,
+		FOptsLen:  phy[5] & 0x0f,
+	}
+	h.FCnt = binary.LittleEndian.Uint16(phy[6:8])
+
+	end := 8 + int(h.FCtrl.FOptsLen)
+	if end > len(phy)-4 {
+		return nil, errors.Errorf("FOpts length %d exceeds the frame", h.FCtrl.FOptsLen)
+	}
+	h.FOpts = phy[8:end]
+
+	if end < len(phy)-4 {
+		port := phy[end]
+		h.FPort = &port
+	}
+	h.MIC = hex.EncodeToString(phy[len(phy)-4:])
+
+	return &h, nil
+}
+
+// Uplink tells whether the frame is sent by a device.
+func (h *FrameHeader) Uplink() bool {
+	return h.MType == MTypeUnconfirmedDataUp || h.MType == MTypeConfirmedDataUp
+}
+
+// FrameHeader reads the header of the packet, NotDataFrameError is returned for
+// join and proprietary frames.
+func (p *RxPacket) FrameHeader() (*FrameHeader, error) {
+	phy, err := base64.StdEncoding.DecodeString(p.Data)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid PHYPayload")
+	}
+	return ParseFrameHeader(phy)
+}
+
+// SetFrameHeader copies the header fields and the MAC commands in FOpts to the row.
+// The row is kept when the MAC commands can not be decoded.
+func (c *Coverage) SetFrameHeader(h *FrameHeader) error {
+	fCnt := uint32(h.FCnt)
+
+	c.MType = h.MType
+	c.FCnt = &fCnt
+	c.FPort = h.FPort
+	c.FCtrl = h.FCtrl
+	c.FOpts = hex.EncodeToString(h.FOpts)
+	c.MIC = h.MIC
+
+	commands, err := ParseMACCommands(h.FOpts, h.Uplink())
+	c.MACCommands = commands
+
+	return err
+}
@@ -0,0 +1,93 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package model
+
+import (
+	"testing"
+)
+
+func TestParseFrameHeader(t *testing.T) {
+	// confirmed data up with ADR and ACK, FCnt 258, FOpts LinkCheckReq and
+	// DevStatusAns (battery 200, margin -3), FPort 10
+	phy := []byte{0x80, 0x2c, 0x1b, 0x01, 0x26, 0xa4, 0x02, 0x01, 0x02, 0x06, 0xc8, 0x3d, 0x0a, 0xaa, 0x01, 0x02, 0x03, 0x04}
+
+	h, err := ParseFrameHeader(phy)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if h.MType != MTypeConfirmedDataUp || !h.Uplink() {
+		t.Errorf("wrong mtype: %s", h.MType)
+	}
+	if h.DevAddr != "26011b2c" || h.FCnt != 258 {
+		t.Errorf("wrong header: %s %d", h.DevAddr, h.FCnt)
+	}
+	if !h.FCtrl.ADR || h.FCtrl.ADRACKReq || !h.FCtrl.ACK || h.FCtrl.FOptsLen != 4 {
+		t.Errorf("wrong fctrl: %+v", h.FCtrl)
+	}
+	if h.FPort == nil || *h.FPort != 10 {
+		t.Errorf("wrong fport: %v", h.FPort)
+	}
+	if h.MIC != "01020304" {
+		t.Errorf("wrong mic: %s", h.MIC)
+	}
+
+	commands, err := ParseMACCommands(h.FOpts, h.Uplink())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(commands) != 2 || commands[0].Name != "LinkCheckReq" || commands[1].Name != "DevStatusAns" {
+		t.Fatalf("wrong commands: %v", commands)
+	}
+	if *commands[1].Battery != 200 || *commands[1].Margin != -3 {
+		t.Errorf("wrong DevStatusAns: %d %d", *commands[1].Battery, *commands[1].Margin)
+	}
+
+	// join request
+	if _, err := ParseFrameHeader([]byte{0x00, 0x01}); err != NotDataFrameError {
+		t.Errorf("expected NotDataFrameError, got %v", err)
+	}
+
+	// no FPort
+	h, err = ParseFrameHeader([]byte{0x40, 0x2c, 0x1b, 0x01, 0x26, 0x00, 0x00, 0x00, 0x01, 0x02, 0x03, 0x04})
+	if err != nil || h.FPort != nil {
+		t.Errorf("expected no fport: %v %v", h, err)
+	}
+}
+
+func TestParseDownlinkMACCommands(t *testing.T) {
+	commands, err := ParseMACCommands([]byte{0x02, 0x14, 0x03, 0x06}, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(commands) != 2 || commands[0].Name != "LinkCheckAns" || commands[1].Name != "DevStatusReq" {
+		t.Fatalf("wrong commands: %v", commands)
+	}
+	if *commands[0].Margin != 20 || *commands[0].GatewayCount != 3 {
+		t.Errorf("wrong LinkCheckAns: %d %d", *commands[0].Margin, *commands[0].GatewayCount)
+	}
+
+	commands, err = ParseMACCommands([]byte{0x02, 0x14, 0x03, 0x03, 0x01}, false)
+	if err == nil || len(commands) != 1 {
+		t.Errorf("expected an error after the first command: %v %v", commands, err)
+	}
+}
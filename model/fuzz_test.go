@@ -0,0 +1,205 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package model
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/brocaar/lorawan"
+	"github.com/bullettime/lora-coverage/region"
+)
+
+// addFixtureSeeds adds the fields of the rx packets in the fixture as seeds.
+func addFixtureSeeds(f *testing.F) {
+	file, err := os.Open("../test.json")
+	if err != nil {
+		f.Fatal(err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var line struct {
+			Fields json.RawMessage `json:"fields"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			f.Fatal(err)
+		}
+		f.Add([]byte(line.Fields))
+	}
+}
+
+func TestCoverageUnmarshal(t *testing.T) {
+	plan, err := region.Get("eu868")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "000102030405060708090a0b0c0d0e0f"
+	m := New(nil, Config{Plan: plan, Keys: &KeyStore{Default: KeySettings{NwkSKey: key, AppSKey: key}}})
+
+	// the first rx packet of the fixture
+	data := `{"gateway mac":"aa555a0000000101","time":"2018-04-05T21:00:00Z","frequency":867.5,"crc":1,` +
+		`"modulation":"LORA","data rate":"SF7BW125","coding rate":"4/5","rssi":-94,"snr":23.3,"size":20,` +
+		`"data":"QCwbASYAAAABmlHTtqk0rneArxA="}`
+
+	var c Coverage
+	if err := c.Unmarshal([]byte(data), m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.DeviceAddr.String() != "26011b2c" || *c.FCnt != 0 || c.Power != 14 {
+		t.Errorf("wrong row: %+v", c)
+	}
+
+	if err := c.Unmarshal([]byte(data), New(nil, Config{})); err == nil {
+		t.Error("expected an error without keys")
+	}
+}
+
+func FuzzCoverageUnmarshal(f *testing.F) {
+	plan, err := region.Get("eu868")
+	if err != nil {
+		f.Fatal(err)
+	}
+	key := "000102030405060708090a0b0c0d0e0f"
+	m := New(nil, Config{Plan: plan, Keys: &KeyStore{
+		Default: KeySettings{NwkSKey: key, AppSKey: key},
+		// 26011b2d is a LoRaWAN 1.1 device
+		Devices: map[string]KeySettings{"26011b2d": {Version: "1.1", FNwkSIntKey: key, SNwkSIntKey: key,
+			NwkSEncKey: key}},
+	}})
+
+	addFixtureSeeds(f)
+	// a frame without FRMPayload and a frame with a LinkCheckReq on port 0
+	fPort := uint8(0)
+	for _, macPayload := range []*lorawan.MACPayload{
+		{FHDR: lorawan.FHDR{DevAddr: lorawan.DevAddr{0x26, 0x01, 0x1b, 0x2c}, FCnt: 1}},
+		{FHDR: lorawan.FHDR{DevAddr: lorawan.DevAddr{0x26, 0x01, 0x1b, 0x2c}, FCnt: 2}, FPort: &fPort,
+			FRMPayload: []lorawan.Payload{&lorawan.DataPayload{Bytes: []byte{0x02}}}},
+	} {
+		phy := lorawan.PHYPayload{MHDR: lorawan.MHDR{MType: lorawan.UnconfirmedDataUp, Major: lorawan.LoRaWANR1},
+			MACPayload: macPayload}
+		if err := phy.EncryptFRMPayload(testKey); err != nil {
+			f.Fatal(err)
+		}
+		if err := phy.SetMIC(testKey); err != nil {
+			f.Fatal(err)
+		}
+		data, err := phy.MarshalText()
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add([]byte(`{"crc":1,"data":"` + string(data) + `"}`))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var c Coverage
+		c.Unmarshal(data, m)
+	})
+}
+
+func FuzzDataRateJSON(f *testing.F) {
+	for _, seed := range []string{`"SF7BW125"`, `50000`, `"50000"`, `-1`, `""`, `1e3`, `null`} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var d DataRate
+		if err := json.Unmarshal(data, &d); err != nil {
+			return
+		}
+
+		encoded, err := json.Marshal(d)
+		if err != nil {
+			t.Fatalf("error marshalling %#v: %v", d, err)
+		}
+
+		var decoded DataRate
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatalf("error unmarshalling %s: %v", encoded, err)
+		}
+		if decoded != d {
+			t.Errorf("%s: %#v != %#v", encoded, decoded, d)
+		}
+		if d.LoRa == "" && d.String() != string(encoded) {
+			t.Errorf("wrong string of %s: %s", encoded, d.String())
+		}
+	})
+}
+
+func FuzzCompactTimeJSON(f *testing.F) {
+	for _, seed := range []string{`"2018-04-05T21:46:04.123456Z"`, `"2018-04-05T21:46:04+02:00"`, `""`, `0`} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var ct CompactTime
+		if err := json.Unmarshal(data, &ct); err != nil {
+			return
+		}
+
+		encoded, err := json.Marshal(ct)
+		if err != nil {
+			// years outside 0-9999 can not be represented
+			return
+		}
+
+		var decoded CompactTime
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatalf("error unmarshalling %s: %v", encoded, err)
+		}
+		if !time.Time(decoded).Equal(time.Time(ct)) {
+			t.Errorf("%s: %v != %v", encoded, decoded, ct)
+		}
+	})
+}
+
+func FuzzMacAddressJSON(f *testing.F) {
+	for _, seed := range []string{`"aa555a0000000101"`, `"AA555A0000000101"`, `"aa55"`, `"zz"`, `1`} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var m MacAddress
+		if err := json.Unmarshal(data, &m); err != nil {
+			return
+		}
+
+		encoded, err := json.Marshal(m)
+		if err != nil {
+			t.Fatalf("error marshalling %v: %v", m, err)
+		}
+
+		var decoded MacAddress
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatalf("error unmarshalling %s: %v", encoded, err)
+		}
+		if !reflect.DeepEqual(decoded, m) {
+			t.Errorf("%s: %v != %v", encoded, decoded, m)
+		}
+	})
+}
@@ -0,0 +1,143 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package model
+
+import (
+	"strings"
+
+	"github.com/brocaar/lorawan"
+	"github.com/pkg/errors"
+)
+
+// LoRaWAN versions of the key store
+const (
+	LoRaWAN10 = "1.0"
+	LoRaWAN11 = "1.1"
+)
+
+// DeviceKeys are the session keys of a device. For LoRaWAN 1.0 the three network
+// keys are the NwkSKey.
+type DeviceKeys struct {
+	Version     string
+	FNwkSIntKey lorawan.AES128Key
+	SNwkSIntKey lorawan.AES128Key
+	NwkSEncKey  lorawan.AES128Key
+	AppSKey     lorawan.AES128Key
+}
+
+// KeyProvider provides the session keys of the devices, eg. from a key store in a
+// config file.
+type KeyProvider interface {
+	DeviceKeys(devAddr string) (*DeviceKeys, error)
+}
+
+// KeySettings are the configured keys of a device in hex, empty when they are not
+// configured. A LoRaWAN 1.0 device has a NwkSKey instead of the three network keys.
+type KeySettings struct {
+	Version     string
+	NwkSKey     string
+	FNwkSIntKey string
+	SNwkSIntKey string
+	NwkSEncKey  string
+	AppSKey     string
+}
+
+// KeyStore is a KeyProvider for configured keys. The devices are looked up by their
+// lower case address, settings that a device does not have (or devices that are not
+// in the store at all) fall back to Default.
+type KeyStore struct {
+	Devices map[string]KeySettings
+	Default KeySettings
+}
+
+// DeviceKeys returns the keys of a device.
+func (s *KeyStore) DeviceKeys(devAddr string) (*DeviceKeys, error) {
+	device := s.Devices[strings.ToLower(devAddr)]
+	setting := func(name string) string {
+		if value := device.get(name); value != "" {
+			return value
+		}
+		return s.Default.get(name)
+	}
+
+	keys := DeviceKeys{Version: setting("version")}
+	if keys.Version == "" {
+		keys.Version = LoRaWAN10
+	}
+
+	parseKey := func(key *lorawan.AES128Key, name string) error {
+		value := setting(name)
+		if value == "" {
+			return errors.Errorf("%s not configured (device %s)", name, strings.ToLower(devAddr))
+		}
+		if err := key.UnmarshalText([]byte(value)); err != nil {
+			return errors.Wrapf(err, "invalid %s", name)
+		}
+		return nil
+	}
+
+	if err := parseKey(&keys.AppSKey, "appskey"); err != nil {
+		return nil, err
+	}
+
+	switch keys.Version {
+	case LoRaWAN10:
+		if err := parseKey(&keys.FNwkSIntKey, "nwkskey"); err != nil {
+			return nil, err
+		}
+		keys.SNwkSIntKey = keys.FNwkSIntKey
+		keys.NwkSEncKey = keys.FNwkSIntKey
+	case LoRaWAN11:
+		for name, key := range map[string]*lorawan.AES128Key{
+			"fnwksintkey": &keys.FNwkSIntKey,
+			"snwksintkey": &keys.SNwkSIntKey,
+			"nwksenckey":  &keys.NwkSEncKey,
+		} {
+			if err := parseKey(key, name); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, errors.Errorf("unknown LoRaWAN version of device %s: %s", devAddr, keys.Version)
+	}
+
+	return &keys, nil
+}
+
+// get returns a setting by its name in the config file.
+func (s KeySettings) get(name string) string {
+	switch name {
+	case "version":
+		return s.Version
+	case "nwkskey":
+		return s.NwkSKey
+	case "fnwksintkey":
+		return s.FNwkSIntKey
+	case "snwksintkey":
+		return s.SNwkSIntKey
+	case "nwksenckey":
+		return s.NwkSEncKey
+	case "appskey":
+		return s.AppSKey
+	}
+	return ""
+}
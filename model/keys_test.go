@@ -0,0 +1,62 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/brocaar/lorawan"
+)
+
+func TestKeyStore(t *testing.T) {
+	store := KeyStore{
+		Default: KeySettings{NwkSKey: "000102030405060708090a0b0c0d0e0f", AppSKey: "03000000000000000000000000000000"},
+		Devices: map[string]KeySettings{
+			"26011b2c": {AppSKey: "04000000000000000000000000000000"},
+			"26011b2d": {Version: "1.1", FNwkSIntKey: "000102030405060708090a0b0c0d0e0f"},
+		},
+	}
+
+	// a device that is not in the store
+	keys, err := store.DeviceKeys("26011B2B")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if keys.Version != LoRaWAN10 || keys.SNwkSIntKey != testKey || keys.AppSKey != (lorawan.AES128Key{3}) {
+		t.Errorf("wrong keys: %+v", keys)
+	}
+
+	// the appskey of the device, the nwkskey of the default
+	keys, err = store.DeviceKeys("26011B2C")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if keys.NwkSEncKey != testKey || keys.AppSKey != (lorawan.AES128Key{4}) {
+		t.Errorf("wrong keys: %+v", keys)
+	}
+
+	// the nwkskey is not used for LoRaWAN 1.1
+	if _, err := store.DeviceKeys("26011B2D"); err == nil {
+		t.Error("expected an error for the missing network keys")
+	}
+}
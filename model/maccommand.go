@@ -0,0 +1,156 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package model
+
+import (
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+)
+
+// MACCommand is a MAC command of FOpts, the values that are useful as coverage
+// signal are decoded.
+type MACCommand struct {
+	CID     uint8
+	Name    string
+	Uplink  bool
+	Payload []byte
+
+	// Margin is the demodulation margin in dB of a LinkCheckAns or DevStatusAns.
+	Margin *int
+	// GatewayCount is the number of gateways that received the LinkCheckReq.
+	GatewayCount *int
+	// Battery is the battery level of a DevStatusAns: 0 for an external power
+	// source, 1 to 254 for the level and 255 when it is unknown.
+	Battery *int
+}
+
+type macCommandSpec struct {
+	name string
+	size int
+}
+
+var uplinkMACCommands = map[uint8]macCommandSpec{
+	0x01: {"ResetInd", 1},
+	0x02: {"LinkCheckReq", 0},
+	0x03: {"LinkADRAns", 1},
+	0x04: {"DutyCycleAns", 0},
+	0x05: {"RXParamSetupAns", 1},
+	0x06: {"DevStatusAns", 2},
+	0x07: {"NewChannelAns", 1},
+	0x08: {"RXTimingSetupAns", 0},
+	0x09: {"TxParamSetupAns", 0},
+	0x0a: {"DlChannelAns", 1},
+	0x0b: {"RekeyInd", 1},
+	0x0c: {"ADRParamSetupAns", 0},
+	0x0d: {"DeviceTimeReq", 0},
+	0x0f: {"RejoinParamSetupAns", 1},
+	0x10: {"PingSlotInfoReq", 1},
+	0x11: {"PingSlotChannelAns", 1},
+	0x12: {"BeaconTimingReq", 0},
+	0x13: {"BeaconFreqAns", 1},
+}
+
+var downlinkMACCommands = map[uint8]macCommandSpec{
+	0x01: {"ResetConf", 1},
+	0x02: {"LinkCheckAns", 2},
+	0x03: {"LinkADRReq", 4},
+	0x04: {"DutyCycleReq", 1},
+	0x05: {"RXParamSetupReq", 4},
+	0x06: {"DevStatusReq", 0},
+	0x07: {"NewChannelReq", 5},
+	0x08: {"RXTimingSetupReq", 1},
+	0x09: {"TxParamSetupReq", 1},
+	0x0a: {"DlChannelReq", 4},
+	0x0b: {"RekeyConf", 1},
+	0x0c: {"ADRParamSetupReq", 1},
+	0x0d: {"DeviceTimeAns", 5},
+	0x0e: {"ForceRejoinReq", 2},
+	0x0f: {"RejoinParamSetupReq", 1},
+	0x10: {"PingSlotInfoAns", 0},
+	0x11: {"PingSlotChannelReq", 4},
+	0x12: {"BeaconTimingAns", 3},
+	0x13: {"BeaconFreqReq", 3},
+}
+
+// ParseMACCommands decodes the MAC commands of FOpts (or of a FRMPayload on port 0).
+// The commands before an unknown command are returned together with the error,
+// proprietary commands take the rest of the data.
+func ParseMACCommands(data []byte, uplink bool) ([]MACCommand, error) {
+	specs := downlinkMACCommands
+	if uplink {
+		specs = uplinkMACCommands
+	}
+
+	var commands []MACCommand
+
+	for i := 0; i < len(data); {
+		cid := data[i]
+		i++
+
+		command := MACCommand{CID: cid, Uplink: uplink}
+
+		if cid >= 0x80 {
+			command.Name = "Proprietary"
+			command.Payload = data[i:]
+			commands = append(commands, command)
+			break
+		}
+
+		spec, ok := specs[cid]
+		if !ok {
+			return commands, errors.Errorf("unknown MAC command: %#02x", cid)
+		}
+		if i+spec.size > len(data) {
+			return commands, errors.Errorf("%s needs %d bytes, %d left", spec.name, spec.size, len(data)-i)
+		}
+
+		command.Name = spec.name
+		command.Payload = data[i : i+spec.size]
+		command.decode()
+
+		commands = append(commands, command)
+		i += spec.size
+	}
+
+	return commands, nil
+}
+
+func (m *MACCommand) decode() {
+	switch m.Name {
+	case "LinkCheckAns":
+		margin, count := int(m.Payload[0]), int(m.Payload[1])
+		m.Margin, m.GatewayCount = &margin, &count
+	case "DevStatusAns":
+		battery := int(m.Payload[0])
+		// 6 bit signed integer
+		margin := int(int8(m.Payload[1]<<2) >> 2)
+		m.Battery, m.Margin = &battery, &margin
+	}
+}
+
+func (m MACCommand) String() string {
+	if len(m.Payload) == 0 {
+		return m.Name
+	}
+	return m.Name + "(" + hex.EncodeToString(m.Payload) + ")"
+}
@@ -0,0 +1,92 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package model
+
+import (
+	"math"
+
+	"github.com/bullettime/lora-coverage/region"
+)
+
+// Margin is the link margin of a received uplink: how far its SNR was above the
+// demodulation floor of the data rate and its RSSI above the receiver sensitivity.
+type Margin struct {
+	DataRate region.DataRate
+	SNR      float64
+	RSSI     float64
+}
+
+// NewMargin returns the margin of an uplink received with the rssi and snr. The
+// packet forwarder reports no SNR for FSK, its SNR margin is the RSSI margin.
+func NewMargin(dr region.DataRate, rssi int16, snr float64) Margin {
+	m := Margin{
+		DataRate: dr,
+		SNR:      snr - dr.RequiredSNR(),
+		RSSI:     float64(rssi) - dr.Sensitivity(),
+	}
+	if dr.Modulation == region.FSK {
+		m.SNR = m.RSSI
+	}
+	return m
+}
+
+// Margin returns the link margin of the row, false when the data rate is unknown.
+func (c *Coverage) Margin() (Margin, bool) {
+	dr, err := c.DataRate.RegionDataRate()
+	if err != nil {
+		return Margin{}, false
+	}
+
+	return NewMargin(dr, c.RSSI, c.SNR), true
+}
+
+// Link returns the limiting one of the SNR and the RSSI margin.
+func (m Margin) Link() float64 {
+	return math.Min(m.SNR, m.RSSI)
+}
+
+// At returns the margin the uplink would have had at another data rate. The SNR is
+// corrected for the different noise bandwidth, the RSSI is the same.
+func (m Margin) At(dr region.DataRate) Margin {
+	snr := m.SNR + m.DataRate.RequiredSNR()
+	rssi := m.RSSI + m.DataRate.Sensitivity()
+
+	if m.DataRate.Bandwidth > 0 && dr.Bandwidth > 0 {
+		snr -= 10 * math.Log10(float64(dr.Bandwidth)/float64(m.DataRate.Bandwidth))
+	}
+
+	return Margin{
+		DataRate: dr,
+		SNR:      snr - dr.RequiredSNR(),
+		RSSI:     rssi - dr.Sensitivity(),
+	}
+}
+
+// Faster returns the margin at the next faster data rate, false when there is none.
+func (m Margin) Faster() (Margin, bool) {
+	dr, ok := m.DataRate.Faster()
+	if !ok {
+		return Margin{}, false
+	}
+	return m.At(dr), true
+}
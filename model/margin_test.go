@@ -0,0 +1,77 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package model
+
+import (
+	"math"
+	"testing"
+)
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 0.05
+}
+
+func TestMargin(t *testing.T) {
+	tests := []struct {
+		dataRate DataRate
+		rssi     int16
+		snr      float64
+		snrM     float64
+		rssiM    float64
+		faster   bool
+		fasterM  float64
+	}{
+		{DataRate{LoRa: "SF7BW125"}, -110, 2.5, 10, 14.5, false, 0},
+		{DataRate{LoRa: "SF12BW125"}, -130, -15, 5, 7, true, 2.5},
+		{DataRate{LoRa: "SF9BW125"}, -120, -11, 1.5, 9.5, true, -1},
+		// no SNR for FSK
+		{DataRate{FSK: 50000}, -100, 0, 8, 8, false, 0},
+	}
+
+	for _, test := range tests {
+		row := Coverage{DataRate: test.dataRate, RSSI: test.rssi, SNR: test.snr}
+
+		m, ok := row.Margin()
+		if !ok {
+			t.Fatalf("%s: no margin", test.dataRate)
+		}
+		if !almostEqual(m.SNR, test.snrM) || !almostEqual(m.RSSI, test.rssiM) {
+			t.Errorf("%s: wrong margin %+v", test.dataRate, m)
+		}
+		if !almostEqual(m.Link(), math.Min(test.snrM, test.rssiM)) {
+			t.Errorf("%s: wrong link margin %f", test.dataRate, m.Link())
+		}
+
+		faster, ok := m.Faster()
+		if ok != test.faster {
+			t.Errorf("%s: expected faster %v", test.dataRate, test.faster)
+		}
+		if ok && !almostEqual(faster.Link(), test.fasterM) {
+			t.Errorf("%s: wrong faster margin %+v", test.dataRate, faster)
+		}
+	}
+
+	if _, ok := (&Coverage{}).Margin(); ok {
+		t.Error("expected no margin without data rate")
+	}
+}
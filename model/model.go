@@ -22,12 +22,220 @@
 
 package model
 
+import (
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-coverage/region"
+	"github.com/pkg/errors"
+)
+
+// Config is the configuration of a Model.
+type Config struct {
+	// Plan is the channel plan of the network, the coverage rows are annotated with it
+	// and the MIC of LoRaWAN 1.1 uplinks depends on the channel in it.
+	Plan *region.Plan
+	// DuplicateTolerance is the clock difference within which a frame that a gateway
+	// received again is a duplicate.
+	DuplicateTolerance time.Duration
+	// Keys provides the session keys of the devices, see SetDeviceKeys.
+	Keys KeyProvider
+}
+
 type Model struct {
 	db
+	config Config
+
+	// fCntLock guards fCntStates, the servers add rows from more than one goroutine
+	fCntLock   sync.Mutex
+	fCntStates map[string]*FCntState
 }
 
-func New(database db) *Model {
+func New(database db, config Config) *Model {
 	return &Model{
-		db: database,
+		db:         database,
+		config:     config,
+		fCntStates: make(map[string]*FCntState),
+	}
+}
+
+// SetDeviceKeys sets the keys of the device that sent an uplink, from the key provider
+// of the configuration, and the channel plan of the configuration when the packet
+// does not have them yet. Packets with an invalid crc and packets that are not uplink
+// data frames are left alone.
+func (m *Model) SetDeviceKeys(p *RxPacket) error {
+	if p.Plan == nil {
+		p.Plan = m.config.Plan
+	}
+
+	if p.Keys != nil || p.Crc < 0 {
+		return nil
+	}
+
+	header, err := p.FrameHeader()
+	if err != nil || !header.Uplink() {
+		return nil
+	}
+
+	p.Keys, err = m.DeviceKeys(header.DevAddr)
+	return err
+}
+
+// DeviceKeys returns the keys of a device from the key provider of the configuration,
+// nil when there is no key provider.
+func (m *Model) DeviceKeys(devAddr string) (*DeviceKeys, error) {
+	if m.config.Keys == nil {
+		return nil, nil
+	}
+	return m.config.Keys.DeviceKeys(devAddr)
+}
+
+// AddCoverageRow annotates the row with the parameters of the configured channel plan,
+// when that was not done yet, and adds it to the database. DuplicateRowError is
+// returned when the gateway already received the frame (same device, frame counter
+// and MIC) within the duplicate tolerance of the row, eg. when the same log is
+// imported again with a skewed clock.
+func (m *Model) AddCoverageRow(c *Coverage) error {
+	if duplicate, err := m.isDuplicate(c); err != nil {
+		return err
+	} else if duplicate {
+		return errors.Wrapf(DuplicateRowError, "frame %s %d %s already received by %s", c.DeviceAddr,
+			*c.FCnt, c.MIC, c.GatewayMac)
+	}
+
+	if c.Region == "" {
+		if m.config.Plan == nil {
+			return errors.New("no channel plan configured")
+		}
+
+		if err := c.Annotate(m.config.Plan); err != nil {
+			ctx := log.WithError(err).WithFields(log.Fields{
+				"gateway":   c.GatewayMac,
+				"device":    c.DeviceAddr,
+				"frequency": c.Frequency,
+				"datarate":  c.DataRate,
+			})
+			if errors.Cause(err) == region.OutOfPlanError {
+				ctx.Warn("uplink outside of the channel plan")
+			} else {
+				ctx.Warn("annotating coverage row")
+			}
+		}
+	}
+
+	if err := m.db.AddCoverageRow(c); err != nil {
+		return err
+	}
+
+	if c.FCnt != nil {
+		return m.updateFCnt(c)
+	}
+
+	return nil
+}
+
+func (m *Model) isDuplicate(c *Coverage) (bool, error) {
+	if c.MIC == "" || c.FCnt == nil {
+		return false, nil
+	}
+
+	times, err := m.GetFrameTimes(c.GatewayMac.String(), c.DeviceAddr.String(), *c.FCnt, c.MIC)
+	if err != nil {
+		return false, err
 	}
+
+	tolerance := m.config.DuplicateTolerance
+	for _, t := range times {
+		d := t.Sub(time.Time(c.Time))
+		if d <= tolerance && d >= -tolerance {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// SetFCntCandidates sets the full frame counters the 16 bit frame counter of an
+// uplink can stand for, based on the last frame counter of the device.
+func (m *Model) SetFCntCandidates(p *RxPacket) error {
+	header, err := p.FrameHeader()
+	if err != nil || !header.Uplink() {
+		return nil
+	}
+
+	m.fCntLock.Lock()
+	defer m.fCntLock.Unlock()
+
+	state, err := m.fCntState(header.DevAddr)
+	if err != nil {
+		return err
+	}
+
+	p.FCntCandidates = state.FCntCandidates(header.FCnt)
+	return nil
+}
+
+// fCntState returns the frame counter state of a device, fCntLock must be held.
+func (m *Model) fCntState(device string) (*FCntState, error) {
+	if state, ok := m.fCntStates[device]; ok {
+		return state, nil
+	}
+
+	state, err := m.GetFCntState(device)
+	if err != nil {
+		return nil, err
+	}
+
+	m.fCntStates[device] = state
+	return state, nil
+}
+
+// updateFCnt stores the frame counter of the row as the last one of the device.
+func (m *Model) updateFCnt(c *Coverage) error {
+	device := c.DeviceAddr.String()
+
+	m.fCntLock.Lock()
+	defer m.fCntLock.Unlock()
+
+	state, err := m.fCntState(device)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		state = &FCntState{Device: device}
+		m.fCntStates[device] = state
+	}
+
+	previous := state.FCnt
+	if state.Update(*c.FCnt, time.Time(c.Time)) {
+		log.WithFields(log.Fields{
+			"device":   device,
+			"previous": previous,
+			"fcnt":     *c.FCnt,
+			"resets":   state.Resets,
+		}).Warn("frame counter reset")
+	}
+
+	return m.SetFCntState(state)
+}
+
+// ConfFCnt returns the frame counter of the last confirmed downlink sent to a device
+// before the given time, nil when there is none.
+func (m *Model) ConfFCnt(device string, before time.Time) (*uint16, error) {
+	downlinks, err := m.GetDownlinks(device)
+	if err != nil {
+		return nil, err
+	}
+
+	var fCnt *uint16
+	for _, d := range downlinks {
+		if d.MType != MTypeConfirmedDataDown || !d.Time.Before(before) {
+			continue
+		}
+		value := uint16(d.FCnt)
+		fCnt = &value
+	}
+
+	return fCnt, nil
 }
@@ -0,0 +1,105 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/brocaar/lorawan"
+	"github.com/pkg/errors"
+)
+
+// frameDB is a database that keeps the coverage rows for the duplicate check.
+type frameDB struct {
+	fCntDB
+	coverage []Coverage
+}
+
+func (d *frameDB) AddCoverageRow(c *Coverage) error {
+	d.coverage = append(d.coverage, *c)
+	return nil
+}
+
+func (d *frameDB) GetFrameTimes(gateway, device string, fCnt uint32, mic string) ([]time.Time, error) {
+	var times []time.Time
+	for _, c := range d.coverage {
+		if c.GatewayMac.String() == gateway && c.DeviceAddr.String() == device && c.FCnt != nil &&
+			*c.FCnt == fCnt && c.MIC == mic {
+			times = append(times, time.Time(c.Time))
+		}
+	}
+	return times, nil
+}
+
+func TestDuplicateTolerance(t *testing.T) {
+	t0 := time.Date(2018, 4, 5, 21, 0, 0, 0, time.UTC)
+	gateway := MacAddress{0xaa, 0x55, 0x5a, 0, 0, 0, 1, 1}
+	otherGateway := MacAddress{0xaa, 0x55, 0x5a, 0, 0, 0, 1, 2}
+
+	row := func(gateway MacAddress, offset time.Duration, mic string) *Coverage {
+		fCnt := uint32(7)
+		return &Coverage{GatewayMac: gateway, DeviceAddr: lorawan.DevAddr{0x26, 0x01, 0x1b, 0x2c}, FCnt: &fCnt,
+			MIC: mic, Region: "EU868", Time: CompactTime(t0.Add(offset))}
+	}
+
+	tests := []struct {
+		name      string
+		row       *Coverage
+		duplicate bool
+	}{
+		{"copy inside the tolerance", row(gateway, 4*time.Minute, "01020304"), true},
+		{"copy with a clock behind", row(gateway, -5*time.Minute, "01020304"), true},
+		{"replay outside the tolerance", row(gateway, time.Hour, "01020304"), false},
+		{"other gateway", row(otherGateway, 0, "01020304"), false},
+		{"other mic", row(gateway, 0, "05060708"), false},
+		{"no mic", row(gateway, 0, ""), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			database := &frameDB{fCntDB: fCntDB{states: make(map[string]FCntState)}}
+			m := New(database, Config{DuplicateTolerance: 5 * time.Minute})
+
+			if err := m.AddCoverageRow(row(gateway, 0, "01020304")); err != nil {
+				t.Fatal(err)
+			}
+
+			err := m.AddCoverageRow(test.row)
+			if test.duplicate {
+				if errors.Cause(err) != DuplicateRowError {
+					t.Errorf("expected DuplicateRowError, got %v", err)
+				}
+				if len(database.coverage) != 1 {
+					t.Errorf("expected the copy to be skipped, got %d rows", len(database.coverage))
+				}
+			} else {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				if len(database.coverage) != 2 {
+					t.Errorf("expected the row to be stored, got %d rows", len(database.coverage))
+				}
+			}
+		})
+	}
+}
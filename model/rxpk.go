@@ -23,15 +23,21 @@
 package model
 
 import (
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"strconv"
-	"strings"
 	"time"
 
+	"github.com/bullettime/lora-coverage/region"
 	"github.com/pkg/errors"
 )
 
+// InvalidDataRateError is returned for a data rate that is neither a string nor a bit
+// rate.
+var InvalidDataRateError = errors.New("invalid data rate")
+
 type CompactTime time.Time
 
 type DataRate struct {
@@ -55,6 +61,86 @@ type RxPacket struct {
 	SNR        float64     `json:"snr"`
 	Size       uint16      `json:"size"`
 	Data       string      `json:"data"`
+
+	// metadata of the packet forwarder that is only kept in the reception record
+	Timestamp     uint32     `json:"tmst,omitempty"`
+	GPSTime       *uint64    `json:"tmms,omitempty"`
+	FineTimestamp *uint32    `json:"ftime,omitempty"`
+	FreqOffset    *int32     `json:"foff,omitempty"`
+	SignalRSSI    *float64   `json:"rssis,omitempty"`
+	Signals       []RxSignal `json:"rsig,omitempty"`
+
+	// Raw is the record as it was read, it is stored with the reception.
+	Raw json.RawMessage `json:"-"`
+	// ConfFCnt is the frame counter of the confirmed downlink acknowledged by the
+	// packet, it is needed for the MIC of LoRaWAN 1.1 devices.
+	ConfFCnt *uint16 `json:"-"`
+	// FCntCandidates are the full frame counters that are tried to validate the MIC,
+	// only the 16 bit frame counter of the header when empty (see FCntState).
+	FCntCandidates []uint32 `json:"-"`
+	// Keys are the session keys of the device that sent the packet, see
+	// Model.SetDeviceKeys.
+	Keys *DeviceKeys `json:"-"`
+	// Plan is the channel plan the data rate and channel index of the packet are
+	// looked up in, they are needed for the MIC of LoRaWAN 1.1 devices.
+	Plan *region.Plan `json:"-"`
+}
+
+// RxSignal is the metadata of an antenna of a gateway with more than one antenna
+// (rsig of the v2 packet forwarder).
+type RxSignal struct {
+	Antenna       uint8   `json:"ant"`
+	Channel       uint8   `json:"chan"`
+	ChannelRSSI   int16   `json:"rssic"`
+	SignalRSSI    *int16  `json:"rssis,omitempty"`
+	SNR           float64 `json:"lsnr"`
+	EncryptedTime string  `json:"etime,omitempty"`
+	FineTimestamp *uint32 `json:"ftime,omitempty"`
+	FreqOffset    *int32  `json:"foff,omitempty"`
+	RSSISD        *uint8  `json:"rssisd,omitempty"`
+}
+
+// RawRecord returns the record as it was read, or its json encoding when the
+// packet was not read from json.
+func (p *RxPacket) RawRecord() (string, error) {
+	if len(p.Raw) > 0 {
+		return string(p.Raw), nil
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", errors.Wrap(err, "error marshalling rx packet")
+	}
+	return string(data), nil
+}
+
+// LogLine returns the packet as a PUSH_DATA: RXPK line of the lora-logger tool, with
+// the time of the packet as the time of the line.
+func (p *RxPacket) LogLine() ([]byte, error) {
+	line, err := json.Marshal(struct {
+		Fields    *RxPacket   `json:"fields"`
+		Level     string      `json:"level"`
+		TimeStamp CompactTime `json:"timestamp"`
+		Message   string      `json:"message"`
+	}{p, "info", p.Time, "PUSH_DATA: RXPK"})
+
+	return line, errors.Wrap(err, "error marshalling log line")
+}
+
+// DevAddr returns the device address of a data frame without validating or
+// decrypting it, false for the other frame types.
+func (p *RxPacket) DevAddr() (string, bool) {
+	phy, err := base64.StdEncoding.DecodeString(p.Data)
+	if err != nil || len(phy) < 12 {
+		return "", false
+	}
+
+	// unconfirmed or confirmed data up
+	if mType := phy[0] >> 5; mType != 2 && mType != 4 {
+		return "", false
+	}
+
+	return hex.EncodeToString([]byte{phy[4], phy[3], phy[2], phy[1]}), true
 }
 
 func (t CompactTime) String() string {
@@ -79,23 +165,39 @@ func (d DataRate) String() string {
 		return d.LoRa
 	}
 
-	return string(d.FSK)
+	return strconv.FormatUint(uint64(d.FSK), 10)
 }
 
 func (d DataRate) MarshalJSON() ([]byte, error) {
 	if d.LoRa != "" {
-		return []byte(`"` + d.LoRa + `"`), nil
+		return json.Marshal(d.LoRa)
 	}
 	return []byte(strconv.FormatUint(uint64(d.FSK), 10)), nil
 }
 
+// UnmarshalJSON parses a data rate of the packet forwarder: a string for LoRa or the
+// bit rate for FSK, as a number or a string.
 func (d *DataRate) UnmarshalJSON(data []byte) error {
-	i, err := strconv.ParseUint(string(data), 10, 32)
-	if err != nil {
-		d.LoRa = strings.Trim(string(data), `"`)
+	if i, err := strconv.ParseUint(string(data), 10, 32); err == nil {
+		*d = DataRate{FSK: uint32(i)}
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return errors.Wrapf(InvalidDataRateError, "%s", data)
+	}
+	return d.UnmarshalText([]byte(text))
+}
+
+// UnmarshalText parses a data rate as it is stored in the database.
+func (d *DataRate) UnmarshalText(text []byte) error {
+	if i, err := strconv.ParseUint(string(text), 10, 32); err == nil {
+		*d = DataRate{FSK: uint32(i)}
 		return nil
 	}
-	d.FSK = uint32(i)
+
+	*d = DataRate{LoRa: string(text)}
 	return nil
 }
 
@@ -112,7 +214,11 @@ func (m *MacAddress) UnmarshalJSON(data []byte) error {
 	if err != nil {
 		return err
 	}
-	mac, err := hex.DecodeString(dataStr)
+	return m.UnmarshalText([]byte(dataStr))
+}
+
+func (m *MacAddress) UnmarshalText(text []byte) error {
+	mac, err := hex.DecodeString(string(text))
 	if err != nil {
 		return err
 	}
@@ -24,9 +24,14 @@ package model
 
 import (
 	"bufio"
+	"encoding/base64"
 	"encoding/json"
 	"os"
 	"testing"
+	"time"
+
+	"github.com/bullettime/lora-coverage/region"
+	"github.com/pkg/errors"
 )
 
 var (
@@ -70,7 +75,15 @@ func TestRxPk(t *testing.T) {
 					t.Error("error marshaling fields to json:", m.Fields, "(", err, ")")
 				}
 				t.Log(string(fieldsJson))
-				json.Unmarshal(fieldsJson, &p)
+				if err := json.Unmarshal(fieldsJson, &p); err != nil {
+					t.Error("error unmarshaling fields:", string(fieldsJson), "(", err, ")")
+				}
+				if phy, err := base64.StdEncoding.DecodeString(p.Data); err != nil || len(phy) != int(p.Size) {
+					t.Error("data does not match size:", p.Data, p.Size, "(", err, ")")
+				}
+				if p.GatewayMac == (MacAddress{}) || time.Time(p.Time).IsZero() || p.DataR.String() == "" {
+					t.Error("missing fields:", string(fieldsJson))
+				}
 				t.Log(&p)
 				t.Log("Gateway Mac:", p.GatewayMac)
 				t.Log("Time:", p.Time)
@@ -93,3 +106,31 @@ func TestRxPk(t *testing.T) {
 	if tearDown {
 	}
 }
+
+func TestDataRateFSK(t *testing.T) {
+	for _, data := range []string{`50000`, `"50000"`} {
+		var d DataRate
+		if err := json.Unmarshal([]byte(data), &d); err != nil || d.FSK != 50000 || d.String() != "50000" {
+			t.Errorf("%s: wrong data rate %#v (%v)", data, d, err)
+		}
+	}
+
+	var d DataRate
+	if err := json.Unmarshal([]byte(`true`), &d); errors.Cause(err) != InvalidDataRateError {
+		t.Errorf("expected InvalidDataRateError, got %v", err)
+	}
+
+	plan, err := region.Get("EU868")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := Coverage{Frequency: 868.8, DataRate: DataRate{FSK: 50000}, Size: 20}
+	if err := c.Annotate(plan); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.Modulation != ModulationFSK || c.BitRate != 50000 || c.DataRateIndex != 7 || c.Channel < 0 ||
+		c.Sensitivity != -108 || c.Airtime != 4960*time.Microsecond {
+		t.Errorf("wrong annotation: %+v", c)
+	}
+}
@@ -0,0 +1,174 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package model
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TxPacket is a packet the network server asks a gateway to send (PULL_RESP: TXPK of
+// the lora-logger tool).
+type TxPacket struct {
+	GatewayMac            MacAddress `json:"gateway mac"`
+	Token                 uint16     `json:"token"`
+	Immediate             bool       `json:"immediate"`
+	Timestamp             uint32     `json:"timestamp"`
+	Frequency             float64    `json:"frequency"`
+	RFChain               uint8      `json:"RF chain"`
+	Power                 int8       `json:"power"`
+	Modulation            string     `json:"modulation"`
+	DataR                 DataRate   `json:"data rate"`
+	CodingRate            string     `json:"coding rate"`
+	PolarizationInversion bool       `json:"polarization inversion"`
+	Size                  uint16     `json:"size"`
+	Data                  string     `json:"data"`
+}
+
+// TxAck is the acknowledgement of a gateway for a PULL_RESP (TX_ACK of the lora-logger
+// tool), the error is empty or NONE when the packet was scheduled.
+type TxAck struct {
+	GatewayMac MacAddress `json:"gateway mac"`
+	Token      uint16     `json:"token"`
+	Error      string     `json:"error"`
+}
+
+// TxAckOK is the TX_ACK error of a packet that was scheduled.
+const TxAckOK = "NONE"
+
+// Downlink is a data frame sent to a device.
+type Downlink struct {
+	GatewayMac MacAddress
+	DeviceAddr string
+	// Time is the time the gateway was asked to send the frame.
+	Time      time.Time
+	Token     uint16
+	Immediate bool
+	Timestamp uint32
+	Frequency float64
+	DataRate  DataRate
+	Power     int8
+	Size      uint16
+	Data      string
+	MType     string
+	FCnt      uint32
+	FPort     *uint8
+	ACK       bool
+	// FOpts are the MAC commands in the frame header (hex), encrypted for LoRaWAN 1.1.
+	FOpts string
+	// MACCommands are the MAC commands in FOpts and in a FRMPayload on port 0, see
+	// SetMACCommands.
+	MACCommands []MACCommand
+	// TxAck is the error of the TX_ACK, empty when the gateway did not acknowledge
+	// the packet (yet).
+	TxAck string
+	// Raw is the record as it was read.
+	Raw json.RawMessage
+}
+
+// FromTxPacket fills the downlink with a packet sent at the given time,
+// NotDataFrameError is returned for join accepts.
+func (d *Downlink) FromTxPacket(packet *TxPacket, sent time.Time) error {
+	phy, err := base64.StdEncoding.DecodeString(packet.Data)
+	if err != nil {
+		return errors.Wrap(err, "invalid PHYPayload")
+	}
+
+	header, err := ParseFrameHeader(phy)
+	if err != nil {
+		return err
+	}
+	if header.Uplink() {
+		return errors.Errorf("txpk contains an uplink: %s", header.MType)
+	}
+
+	d.GatewayMac = packet.GatewayMac
+	d.DeviceAddr = header.DevAddr
+	d.Time = sent.UTC()
+	d.Token = packet.Token
+	d.Immediate = packet.Immediate
+	d.Timestamp = packet.Timestamp
+	d.Frequency = packet.Frequency
+	d.DataRate = packet.DataR
+	d.Power = packet.Power
+	d.Size = packet.Size
+	d.Data = packet.Data
+	d.MType = header.MType
+	d.FCnt = uint32(header.FCnt)
+	d.FPort = header.FPort
+	d.ACK = header.FCtrl.ACK
+	d.FOpts = hex.EncodeToString(header.FOpts)
+
+	return nil
+}
+
+// SetMACCommands decodes the MAC commands of the downlink, eg. the margin and gateway
+// count of a LinkCheckAns. The FOpts of LoRaWAN 1.1 and the FRMPayload on port 0 are
+// encrypted, without keys only the FOpts are decoded as those of LoRaWAN 1.0. The
+// frame counter of the header is used to decrypt, the 16 most significant bits of the
+// downlink counter are taken to be 0. The commands before an invalid one are kept.
+func (d *Downlink) SetMACCommands(keys *DeviceKeys) error {
+	phy, err := base64.StdEncoding.DecodeString(d.Data)
+	if err != nil {
+		return errors.Wrap(err, "invalid PHYPayload")
+	}
+
+	header, err := ParseFrameHeader(phy)
+	if err != nil {
+		return err
+	}
+
+	devAddr := phy[1:5]
+
+	fOpts := header.FOpts
+	if keys != nil && keys.Version == LoRaWAN11 {
+		fOpts, err = EncryptFRMPayload(keys.NwkSEncKey, false, devAddr, d.FCnt, fOpts)
+		if err != nil {
+			return err
+		}
+	}
+
+	d.MACCommands, err = ParseMACCommands(fOpts, false)
+	if err != nil || header.FPort == nil || *header.FPort != 0 || keys == nil {
+		return err
+	}
+
+	start := 8 + len(header.FOpts) + 1
+	payload, err := EncryptFRMPayload(keys.NwkSEncKey, false, devAddr, d.FCnt, phy[start:len(phy)-4])
+	if err != nil {
+		return err
+	}
+
+	commands, err := ParseMACCommands(payload, false)
+	d.MACCommands = append(d.MACCommands, commands...)
+
+	return err
+}
+
+// Scheduled tells whether the gateway acknowledged the downlink without error.
+func (d *Downlink) Scheduled() bool {
+	return d.TxAck == TxAckOK
+}
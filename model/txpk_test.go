@@ -0,0 +1,90 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package model
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestDownlinkFromTxPacket(t *testing.T) {
+	// unconfirmed data down with ACK, FCnt 7, no FPort
+	phy := []byte{0x60, 0x2c, 0x1b, 0x01, 0x26, 0x20, 0x07, 0x00, 0x01, 0x02, 0x03, 0x04}
+	packet := TxPacket{Token: 42, Frequency: 869.525, DataR: DataRate{LoRa: "SF9BW125"}, Size: 12,
+		Data: base64.StdEncoding.EncodeToString(phy)}
+	sent := time.Date(2018, 4, 5, 21, 46, 5, 0, time.UTC)
+
+	var d Downlink
+	if err := d.FromTxPacket(&packet, sent); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if d.DeviceAddr != "26011b2c" || d.MType != MTypeUnconfirmedDataDown || d.FCnt != 7 || !d.ACK || d.FPort != nil {
+		t.Errorf("wrong downlink: %+v", d)
+	}
+	if !d.Time.Equal(sent) || d.Token != 42 {
+		t.Errorf("wrong time or token: %s %d", d.Time, d.Token)
+	}
+
+	// join accept
+	packet.Data = base64.StdEncoding.EncodeToString([]byte{0x20, 0x01, 0x02})
+	if err := d.FromTxPacket(&packet, sent); err != NotDataFrameError {
+		t.Errorf("expected NotDataFrameError, got %v", err)
+	}
+}
+
+func TestDownlinkMACCommands(t *testing.T) {
+	// unconfirmed data down, FCnt 7, LinkCheckAns (margin 20, 3 gateways) in FOpts
+	phy := []byte{0x60, 0x2c, 0x1b, 0x01, 0x26, 0x03, 0x07, 0x00, 0x02, 0x14, 0x03, 0x01, 0x02, 0x03, 0x04}
+	d := Downlink{Data: base64.StdEncoding.EncodeToString(phy), FCnt: 7}
+
+	if err := d.SetMACCommands(nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(d.MACCommands) != 1 || d.MACCommands[0].Name != "LinkCheckAns" || *d.MACCommands[0].Margin != 20 ||
+		*d.MACCommands[0].GatewayCount != 3 || d.MACCommands[0].Uplink {
+		t.Errorf("wrong mac commands: %+v", d.MACCommands)
+	}
+
+	// FCnt 8, LinkCheckAns and DevStatusReq in the encrypted FRMPayload on port 0
+	payload, err := EncryptFRMPayload(testKey, false, phy[1:5], 8, []byte{0x02, 0x0a, 0x02, 0x06})
+	if err != nil {
+		t.Fatal(err)
+	}
+	phy = append([]byte{0x60, 0x2c, 0x1b, 0x01, 0x26, 0x00, 0x08, 0x00, 0x00}, payload...)
+	phy = append(phy, 0x01, 0x02, 0x03, 0x04)
+	d = Downlink{Data: base64.StdEncoding.EncodeToString(phy), FCnt: 8}
+
+	if err := d.SetMACCommands(nil); err != nil || len(d.MACCommands) != 0 {
+		t.Errorf("expected no mac commands without keys: %v %+v", err, d.MACCommands)
+	}
+
+	keys := &DeviceKeys{Version: LoRaWAN10, FNwkSIntKey: testKey, SNwkSIntKey: testKey, NwkSEncKey: testKey}
+	if err := d.SetMACCommands(keys); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(d.MACCommands) != 2 || *d.MACCommands[0].Margin != 10 || *d.MACCommands[0].GatewayCount != 2 ||
+		d.MACCommands[1].Name != "DevStatusReq" {
+		t.Errorf("wrong mac commands: %+v", d.MACCommands)
+	}
+}
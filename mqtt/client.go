@@ -0,0 +1,462 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package mqtt implements the small part of an MQTT 3.1.1 client that is needed to
+// subscribe to the uplink events of a network server.
+package mqtt
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	packetConnect     byte = 1
+	packetConnack     byte = 2
+	packetPublish     byte = 3
+	packetPuback      byte = 4
+	packetPubrec      byte = 5
+	packetPubrel      byte = 6
+	packetPubcomp     byte = 7
+	packetSubscribe   byte = 8
+	packetSuback      byte = 9
+	packetPingreq     byte = 12
+	packetPingresp    byte = 13
+	packetDisconnect  byte = 14
+	maxRemainingBytes      = 268435455
+)
+
+var connackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// Options configure the connection to the broker.
+type Options struct {
+	// Broker is the address of the broker, eg. tcp://localhost:1883 or ssl://host:8883.
+	Broker    string
+	ClientID  string
+	Username  string
+	Password  string
+	KeepAlive time.Duration
+	TLSConfig *tls.Config
+}
+
+// Message is an application message received on a subscribed topic.
+type Message struct {
+	Topic   string
+	Payload []byte
+}
+
+// Client is a connection to an MQTT broker.
+type Client struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	keepAlive time.Duration
+
+	writeLock sync.Mutex
+	packetID  uint16
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Dial connects to the broker and waits until the connection is accepted.
+func Dial(opts Options) (*Client, error) {
+	// MQTT 3.1.1 does not allow a password without a user name
+	if len(opts.Password) > 0 && len(opts.Username) == 0 {
+		return nil, errors.New("password without username")
+	}
+
+	address, useTLS, err := parseBroker(opts.Broker)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn net.Conn
+	if useTLS {
+		config := opts.TLSConfig
+		if config == nil {
+			config = &tls.Config{}
+		}
+		conn, err = tls.Dial("tcp", address, config)
+	} else {
+		conn, err = net.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error connecting to broker: %s", opts.Broker)
+	}
+
+	if opts.KeepAlive <= 0 {
+		opts.KeepAlive = time.Minute
+	}
+
+	if len(opts.ClientID) == 0 {
+		opts.ClientID = randomClientID()
+	}
+
+	c := &Client{
+		conn:      conn,
+		reader:    bufio.NewReader(conn),
+		keepAlive: opts.KeepAlive,
+		done:      make(chan struct{}),
+	}
+
+	if err := c.connect(opts); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Client) connect(opts Options) error {
+	var flags byte = 0x02 // clean session
+
+	body := appendString(nil, "MQTT")
+	body = append(body, 4) // protocol level 3.1.1
+
+	if len(opts.Username) > 0 {
+		flags |= 0x80
+		if len(opts.Password) > 0 {
+			flags |= 0x40
+		}
+	}
+
+	body = append(body, flags)
+	body = appendUint16(body, uint16(opts.KeepAlive/time.Second))
+	body = appendString(body, opts.ClientID)
+
+	if flags&0x80 != 0 {
+		body = appendString(body, opts.Username)
+	}
+	if flags&0x40 != 0 {
+		body = appendString(body, opts.Password)
+	}
+
+	if err := c.write(packetConnect<<4, body); err != nil {
+		return err
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(c.keepAlive))
+	packetType, _, body, err := readPacket(c.reader)
+	if err != nil {
+		return errors.Wrap(err, "error reading connack")
+	}
+
+	if packetType != packetConnack || len(body) != 2 {
+		return errors.Errorf("unexpected packet while connecting: %d", packetType)
+	}
+
+	if code := body[1]; code != 0 {
+		if reason, ok := connackErrors[code]; ok {
+			return errors.Errorf("connection refused: %s", reason)
+		}
+		return errors.Errorf("connection refused: code %d", code)
+	}
+
+	return nil
+}
+
+// Subscribe subscribes to the topic filters and waits for the broker to acknowledge
+// the subscription. It has to be called before Run.
+func (c *Client) Subscribe(qos byte, topics ...string) error {
+	if qos > 1 {
+		qos = 1
+	}
+
+	id := c.nextPacketID()
+	body := appendUint16(nil, id)
+	for _, topic := range topics {
+		body = appendString(body, topic)
+		body = append(body, qos)
+	}
+
+	if err := c.write(packetSubscribe<<4|0x02, body); err != nil {
+		return err
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(c.keepAlive))
+	packetType, _, body, err := readPacket(c.reader)
+	if err != nil {
+		return errors.Wrap(err, "error reading suback")
+	}
+
+	if packetType != packetSuback || len(body) != 2+len(topics) || binary.BigEndian.Uint16(body) != id {
+		return errors.Errorf("unexpected packet while subscribing: %d", packetType)
+	}
+
+	for i, code := range body[2:] {
+		if code == 0x80 {
+			return errors.Errorf("subscription refused: %s", topics[i])
+		}
+	}
+
+	return nil
+}
+
+// Run reads messages from the broker and passes them to the handler until the
+// connection fails or the client is closed. It returns nil after Close.
+func (c *Client) Run(handler func(Message)) error {
+	go c.ping()
+
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(c.keepAlive * 3 / 2))
+		packetType, flags, body, err := readPacket(c.reader)
+		if err != nil {
+			select {
+			case <-c.done:
+				return nil
+			default:
+			}
+			return errors.Wrap(err, "error reading from broker")
+		}
+
+		switch packetType {
+		case packetPublish:
+			message, id, err := parsePublish(flags, body)
+			if err != nil {
+				return err
+			}
+
+			handler(message)
+
+			switch flags >> 1 & 0x03 {
+			case 1:
+				err = c.write(packetPuback<<4, appendUint16(nil, id))
+			case 2:
+				err = c.write(packetPubrec<<4, appendUint16(nil, id))
+			}
+			if err != nil {
+				return err
+			}
+		case packetPubrel:
+			if len(body) != 2 {
+				return errors.New("invalid pubrel packet")
+			}
+			if err := c.write(packetPubcomp<<4, body); err != nil {
+				return err
+			}
+		case packetPingresp:
+		default:
+			return errors.Errorf("unexpected packet: %d", packetType)
+		}
+	}
+}
+
+// Close disconnects from the broker.
+func (c *Client) Close() error {
+	var err error
+
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.write(packetDisconnect<<4, nil)
+		err = c.conn.Close()
+	})
+
+	return err
+}
+
+func (c *Client) ping() {
+	ticker := time.NewTicker(c.keepAlive * 3 / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if err := c.write(packetPingreq<<4, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *Client) write(header byte, body []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(c.keepAlive))
+	if err := writePacket(c.conn, header, body); err != nil {
+		return errors.Wrap(err, "error writing to broker")
+	}
+
+	return nil
+}
+
+func (c *Client) nextPacketID() uint16 {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	c.packetID++
+	if c.packetID == 0 {
+		c.packetID = 1
+	}
+
+	return c.packetID
+}
+
+func parsePublish(flags byte, body []byte) (Message, uint16, error) {
+	var id uint16
+
+	topic, rest, err := readString(body)
+	if err != nil {
+		return Message{}, 0, errors.Wrap(err, "invalid publish packet")
+	}
+
+	if flags>>1&0x03 > 0 {
+		if len(rest) < 2 {
+			return Message{}, 0, errors.New("invalid publish packet: missing packet identifier")
+		}
+		id = binary.BigEndian.Uint16(rest)
+		rest = rest[2:]
+	}
+
+	return Message{Topic: topic, Payload: rest}, id, nil
+}
+
+func parseBroker(broker string) (string, bool, error) {
+	if !strings.Contains(broker, "://") {
+		broker = "tcp://" + broker
+	}
+
+	u, err := url.Parse(broker)
+	if err != nil {
+		return "", false, errors.Wrapf(err, "invalid broker address: %s", broker)
+	}
+
+	var useTLS bool
+	port := "1883"
+
+	switch u.Scheme {
+	case "tcp", "mqtt":
+	case "ssl", "tls", "mqtts":
+		useTLS = true
+		port = "8883"
+	default:
+		return "", false, errors.Errorf("unsupported broker scheme: %s", u.Scheme)
+	}
+
+	if len(u.Port()) > 0 {
+		port = u.Port()
+	}
+
+	return net.JoinHostPort(u.Hostname(), port), useTLS, nil
+}
+
+func randomClientID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "lora-coverage-" + hex.EncodeToString(b)
+}
+
+func readPacket(r *bufio.Reader) (byte, byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	var length, multiplier int = 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, 0, nil, errors.New("malformed remaining length")
+		}
+
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+
+		length += int(b&0x7f) * multiplier
+		multiplier *= 128
+
+		if b&0x80 == 0 {
+			break
+		}
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, nil, err
+	}
+
+	return header >> 4, header & 0x0f, body, nil
+}
+
+func writePacket(w io.Writer, header byte, body []byte) error {
+	if len(body) > maxRemainingBytes {
+		return errors.New("packet too large")
+	}
+
+	packet := []byte{header}
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if length == 0 {
+			break
+		}
+	}
+
+	_, err := w.Write(append(packet, body...))
+	return err
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(appendUint16(b, uint16(len(s))), s...)
+}
+
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errors.New("string too short")
+	}
+
+	length := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+length {
+		return "", nil, errors.New("string too short")
+	}
+
+	return string(b[2 : 2+length]), b[2+length:], nil
+}
@@ -0,0 +1,313 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	testTopic   = "application/1/device/0102030405060708/event/up"
+	testPayload = `{"fCnt":1}`
+)
+
+func TestClient(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("error listening:", err)
+	}
+	defer listener.Close()
+
+	brokerErr := make(chan error, 1)
+	go func() {
+		brokerErr <- runTestBroker(listener)
+	}()
+
+	client, err := Dial(Options{
+		Broker:    listener.Addr().String(),
+		Username:  "user",
+		Password:  "secret",
+		KeepAlive: 10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal("error connecting:", err)
+	}
+	defer client.Close()
+
+	if err := client.Subscribe(1, "application/+/device/+/event/up"); err != nil {
+		t.Fatal("error subscribing:", err)
+	}
+
+	messages := make(chan Message, 1)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- client.Run(func(m Message) {
+			messages <- m
+		})
+	}()
+
+	select {
+	case m := <-messages:
+		if m.Topic != testTopic {
+			t.Errorf("wrong topic: %s", m.Topic)
+		}
+		if string(m.Payload) != testPayload {
+			t.Errorf("wrong payload: %s", m.Payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+
+	client.Close()
+	if err := <-runErr; err != nil {
+		t.Error("run returned error after close:", err)
+	}
+
+	select {
+	case err := <-brokerErr:
+		if err != nil {
+			t.Fatal("broker:", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("broker did not finish")
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		password string
+		err      string
+	}{
+		{"username and password", "user", "secret", ""},
+		{"username", "user", "", ""},
+		{"none", "", "", ""},
+		{"password without username", "", "secret", "password without username"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal("error listening:", err)
+			}
+			defer listener.Close()
+
+			type credentials struct{ username, password string }
+			received := make(chan credentials, 1)
+			brokerErr := make(chan error, 1)
+			go func() {
+				conn, err := listener.Accept()
+				if err != nil {
+					brokerErr <- err
+					return
+				}
+				defer conn.Close()
+
+				reader := bufio.NewReader(conn)
+				username, password, err := readConnect(reader)
+				if err != nil {
+					brokerErr <- err
+					return
+				}
+				received <- credentials{username, password}
+				if err := writePacket(conn, packetConnack<<4, []byte{0, 0}); err != nil {
+					brokerErr <- err
+					return
+				}
+				brokerErr <- waitDisconnect(reader)
+			}()
+
+			client, err := Dial(Options{
+				Broker:    listener.Addr().String(),
+				Username:  test.username,
+				Password:  test.password,
+				KeepAlive: 10 * time.Second,
+			})
+			if len(test.err) > 0 {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				// nothing was sent to the broker
+				listener.Close()
+				if err := <-brokerErr; err == nil {
+					t.Error("expected the broker not to receive a connection")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("error connecting:", err)
+			}
+
+			if c := <-received; c.username != test.username || c.password != test.password {
+				t.Errorf("broker received %q %q, expected %q %q", c.username, c.password, test.username,
+					test.password)
+			}
+
+			client.Close()
+			if err := <-brokerErr; err != nil {
+				t.Fatal("broker:", err)
+			}
+		})
+	}
+}
+
+func TestParseBroker(t *testing.T) {
+	tests := []struct {
+		broker  string
+		address string
+		tls     bool
+	}{
+		{"localhost", "localhost:1883", false},
+		{"localhost:1884", "localhost:1884", false},
+		{"tcp://broker:1883", "broker:1883", false},
+		{"ssl://broker", "broker:8883", true},
+		{"mqtts://broker:443", "broker:443", true},
+	}
+
+	for _, test := range tests {
+		address, useTLS, err := parseBroker(test.broker)
+		if err != nil {
+			t.Errorf("%s: %s", test.broker, err)
+			continue
+		}
+		if address != test.address || useTLS != test.tls {
+			t.Errorf("%s: got %s (tls %v), expected %s (tls %v)", test.broker, address, useTLS, test.address, test.tls)
+		}
+	}
+}
+
+// runTestBroker accepts a single client, acknowledges its connection and subscription,
+// publishes one QoS 1 message and waits for the acknowledgement. The connection is
+// kept open until the client disconnects.
+func runTestBroker(listener net.Listener) error {
+	conn, err := listener.Accept()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+
+	username, password, err := readConnect(reader)
+	if err != nil {
+		return err
+	}
+	if username != "user" || password != "secret" {
+		return errors.Errorf("wrong credentials: %q %q", username, password)
+	}
+	if err := writePacket(conn, packetConnack<<4, []byte{0, 0}); err != nil {
+		return err
+	}
+
+	packetType, flags, body, err := readPacket(reader)
+	if err != nil {
+		return err
+	}
+	if packetType != packetSubscribe || flags != 0x02 {
+		return errors.Errorf("expected subscribe, got %d", packetType)
+	}
+	if err := writePacket(conn, packetSuback<<4, append(body[:2:2], 1)); err != nil {
+		return err
+	}
+
+	publish := appendString(nil, testTopic)
+	publish = appendUint16(publish, 7)
+	publish = append(publish, testPayload...)
+	if err := writePacket(conn, packetPublish<<4|1<<1, publish); err != nil {
+		return err
+	}
+
+	packetType, _, body, err = readPacket(reader)
+	if err != nil {
+		return err
+	}
+	if packetType != packetPuback || binary.BigEndian.Uint16(body) != 7 {
+		return errors.Errorf("expected puback for packet 7, got %d", packetType)
+	}
+
+	return waitDisconnect(reader)
+}
+
+// readConnect reads a CONNECT packet and returns the user name and password. Like a
+// compliant broker it rejects a password without a user name (MQTT 3.1.1 3.1.2.9).
+func readConnect(reader *bufio.Reader) (string, string, error) {
+	packetType, _, body, err := readPacket(reader)
+	if err != nil {
+		return "", "", err
+	}
+	if packetType != packetConnect {
+		return "", "", errors.Errorf("expected connect, got %d", packetType)
+	}
+	if !bytes.HasPrefix(body, appendString(nil, "MQTT")) || len(body) < 10 {
+		return "", "", errors.Errorf("invalid connect packet: %x", body)
+	}
+
+	flags := body[7]
+	if flags&0xc0 == 0x40 {
+		return "", "", errors.New("password flag without user name flag")
+	}
+
+	// client identifier, user name and password are length prefixed strings
+	var fields []string
+	for payload := body[10:]; len(payload) > 0; {
+		if len(payload) < 2 || len(payload) < 2+int(binary.BigEndian.Uint16(payload)) {
+			return "", "", errors.Errorf("invalid connect payload: %x", body[10:])
+		}
+		length := int(binary.BigEndian.Uint16(payload))
+		fields = append(fields, string(payload[2:2+length]))
+		payload = payload[2+length:]
+	}
+
+	var username, password string
+	if flags&0x80 != 0 && len(fields) > 1 {
+		username = fields[1]
+	}
+	if flags&0x40 != 0 && len(fields) > 2 {
+		password = fields[2]
+	}
+
+	return username, password, nil
+}
+
+// waitDisconnect reads packets until the client disconnects.
+func waitDisconnect(reader *bufio.Reader) error {
+	for {
+		packetType, _, _, err := readPacket(reader)
+		if err == io.EOF || (err == nil && packetType == packetDisconnect) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
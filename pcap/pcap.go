@@ -0,0 +1,339 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package pcap reads network captures in the pcap and pcapng formats and extracts
+// the UDP datagrams from them.
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	magicMicroseconds = 0xa1b2c3d4
+	magicNanoseconds  = 0xa1b23c4d
+
+	blockSectionHeader     = 0x0a0d0d0a
+	blockInterfaceDesc     = 0x00000001
+	blockPacket            = 0x00000002
+	blockSimplePacket      = 0x00000003
+	blockEnhancedPacket    = 0x00000006
+	byteOrderMagic         = 0x1a2b3c4d
+	optionEndOfOpt         = 0
+	optionInterfaceTSResol = 9
+	maxBlockSize           = 16 << 20
+)
+
+var UnknownFormatError = errors.New("unknown capture file format")
+
+// Packet is a captured link layer frame.
+type Packet struct {
+	Time      time.Time
+	LinkType  uint16
+	Data      []byte
+	Truncated bool
+}
+
+type captureInterface struct {
+	linkType       uint16
+	ticksPerSecond uint64
+}
+
+// Reader reads the packets of a pcap or pcapng file.
+type Reader struct {
+	reader     *bufio.Reader
+	order      binary.ByteOrder
+	ng         bool
+	interfaces []captureInterface
+	// lastTime is the time of the last pcapng packet with a timestamp, simple packet
+	// blocks have none.
+	lastTime time.Time
+}
+
+// NewReader detects the format of the capture and reads its header.
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{reader: bufio.NewReader(r)}
+
+	header, err := reader.reader.Peek(4)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading capture header")
+	}
+
+	if binary.BigEndian.Uint32(header) == blockSectionHeader {
+		reader.ng = true
+		return reader, nil
+	}
+
+	if err := reader.readPcapHeader(); err != nil {
+		return nil, err
+	}
+
+	return reader, nil
+}
+
+// Next returns the next packet, io.EOF at the end of the capture.
+func (r *Reader) Next() (*Packet, error) {
+	if r.ng {
+		return r.nextBlock()
+	}
+	return r.nextRecord()
+}
+
+func (r *Reader) readPcapHeader() error {
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(r.reader, header); err != nil {
+		return errors.Wrap(err, "error reading pcap header")
+	}
+
+	var ticksPerSecond uint64 = 1e6
+	switch {
+	case binary.LittleEndian.Uint32(header) == magicMicroseconds:
+		r.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(header) == magicMicroseconds:
+		r.order = binary.BigEndian
+	case binary.LittleEndian.Uint32(header) == magicNanoseconds:
+		r.order = binary.LittleEndian
+		ticksPerSecond = 1e9
+	case binary.BigEndian.Uint32(header) == magicNanoseconds:
+		r.order = binary.BigEndian
+		ticksPerSecond = 1e9
+	default:
+		return UnknownFormatError
+	}
+
+	r.interfaces = []captureInterface{{
+		linkType:       uint16(r.order.Uint32(header[20:24])),
+		ticksPerSecond: ticksPerSecond,
+	}}
+
+	return nil
+}
+
+func (r *Reader) nextRecord() (*Packet, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r.reader, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.Wrap(err, "truncated pcap record")
+		}
+		return nil, err
+	}
+
+	seconds := r.order.Uint32(header[0:4])
+	fraction := r.order.Uint32(header[4:8])
+	captured := r.order.Uint32(header[8:12])
+	original := r.order.Uint32(header[12:16])
+
+	if captured > maxBlockSize {
+		return nil, errors.Errorf("pcap record too large: %d bytes", captured)
+	}
+
+	data := make([]byte, captured)
+	if _, err := io.ReadFull(r.reader, data); err != nil {
+		return nil, errors.Wrap(err, "truncated pcap record")
+	}
+
+	iface := r.interfaces[0]
+
+	return &Packet{
+		Time:      time.Unix(int64(seconds), 0).Add(iface.duration(uint64(fraction))).UTC(),
+		LinkType:  iface.linkType,
+		Data:      data,
+		Truncated: captured < original,
+	}, nil
+}
+
+func (r *Reader) nextBlock() (*Packet, error) {
+	for {
+		blockType, body, err := r.readBlock()
+		if err != nil {
+			return nil, err
+		}
+
+		switch blockType {
+		case blockSectionHeader:
+			r.interfaces = nil
+		case blockInterfaceDesc:
+			if err := r.addInterface(body); err != nil {
+				return nil, err
+			}
+		case blockEnhancedPacket:
+			return r.enhancedPacket(body)
+		case blockPacket:
+			return r.obsoletePacket(body)
+		case blockSimplePacket:
+			return r.simplePacket(body)
+		}
+	}
+}
+
+// readBlock reads a pcapng block and returns its type and body. The byte order is
+// taken from every section header block.
+func (r *Reader) readBlock() (uint32, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r.reader, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, nil, errors.Wrap(err, "truncated pcapng block")
+		}
+		return 0, nil, err
+	}
+
+	if binary.BigEndian.Uint32(header) == blockSectionHeader {
+		magic, err := r.reader.Peek(4)
+		if err != nil {
+			return 0, nil, errors.Wrap(err, "truncated pcapng section header")
+		}
+		switch {
+		case binary.LittleEndian.Uint32(magic) == byteOrderMagic:
+			r.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(magic) == byteOrderMagic:
+			r.order = binary.BigEndian
+		default:
+			return 0, nil, UnknownFormatError
+		}
+	}
+
+	if r.order == nil {
+		return 0, nil, UnknownFormatError
+	}
+
+	blockType := r.order.Uint32(header[0:4])
+	length := r.order.Uint32(header[4:8])
+	if length < 12 || length > maxBlockSize || length%4 != 0 {
+		return 0, nil, errors.Errorf("invalid pcapng block length: %d", length)
+	}
+
+	body := make([]byte, length-8)
+	if _, err := io.ReadFull(r.reader, body); err != nil {
+		return 0, nil, errors.Wrap(err, "truncated pcapng block")
+	}
+
+	// the body ends with a repetition of the block length
+	return blockType, body[:len(body)-4], nil
+}
+
+func (r *Reader) addInterface(body []byte) error {
+	if len(body) < 8 {
+		return errors.New("invalid pcapng interface description block")
+	}
+
+	iface := captureInterface{
+		linkType:       r.order.Uint16(body[0:2]),
+		ticksPerSecond: 1e6,
+	}
+
+	for options := body[8:]; len(options) >= 4; {
+		code := r.order.Uint16(options[0:2])
+		length := int(r.order.Uint16(options[2:4]))
+		if code == optionEndOfOpt || 4+length > len(options) {
+			break
+		}
+
+		if code == optionInterfaceTSResol && length >= 1 {
+			resolution := options[4]
+			if resolution&0x80 == 0 && resolution <= 19 {
+				iface.ticksPerSecond = uint64(math.Pow10(int(resolution)))
+			} else if resolution&0x80 != 0 && resolution&0x7f < 64 {
+				iface.ticksPerSecond = 1 << (resolution & 0x7f)
+			} else {
+				return errors.Errorf("unsupported timestamp resolution: %d", resolution)
+			}
+		}
+
+		options = options[4+(length+3)/4*4:]
+	}
+
+	r.interfaces = append(r.interfaces, iface)
+	return nil
+}
+
+func (r *Reader) enhancedPacket(body []byte) (*Packet, error) {
+	if len(body) < 20 {
+		return nil, errors.New("invalid pcapng enhanced packet block")
+	}
+
+	return r.packet(r.order.Uint32(body[0:4]), r.order.Uint32(body[4:8]), r.order.Uint32(body[8:12]),
+		r.order.Uint32(body[12:16]), r.order.Uint32(body[16:20]), body[20:])
+}
+
+func (r *Reader) obsoletePacket(body []byte) (*Packet, error) {
+	if len(body) < 20 {
+		return nil, errors.New("invalid pcapng packet block")
+	}
+
+	return r.packet(uint32(r.order.Uint16(body[0:2])), r.order.Uint32(body[4:8]), r.order.Uint32(body[8:12]),
+		r.order.Uint32(body[12:16]), r.order.Uint32(body[16:20]), body[20:])
+}
+
+// simplePacket returns the packet of a simple packet block. These have no timestamp,
+// they get the time of the previous packet (zero when there is none).
+func (r *Reader) simplePacket(body []byte) (*Packet, error) {
+	if len(body) < 4 || len(r.interfaces) == 0 {
+		return nil, errors.New("invalid pcapng simple packet block")
+	}
+
+	original := r.order.Uint32(body[0:4])
+	data := body[4:]
+	if uint32(len(data)) > original {
+		data = data[:original]
+	}
+
+	return &Packet{
+		Time:      r.lastTime,
+		LinkType:  r.interfaces[0].linkType,
+		Data:      data,
+		Truncated: uint32(len(data)) < original,
+	}, nil
+}
+
+func (r *Reader) packet(ifaceID, high, low, captured, original uint32, data []byte) (*Packet, error) {
+	if int(ifaceID) >= len(r.interfaces) {
+		return nil, errors.Errorf("packet for unknown interface: %d", ifaceID)
+	}
+	if captured > uint32(len(data)) {
+		return nil, errors.New("packet data exceeds block")
+	}
+
+	iface := r.interfaces[ifaceID]
+	ticks := uint64(high)<<32 | uint64(low)
+	r.lastTime = time.Unix(int64(ticks/iface.ticksPerSecond), 0).Add(iface.duration(ticks % iface.ticksPerSecond)).UTC()
+
+	return &Packet{
+		Time:      r.lastTime,
+		LinkType:  iface.linkType,
+		Data:      data[:captured],
+		Truncated: captured < original,
+	}, nil
+}
+
+// duration converts a number of timestamp ticks (less than a second) to a duration.
+func (i captureInterface) duration(ticks uint64) time.Duration {
+	if i.ticksPerSecond <= 1e9 {
+		return time.Duration(ticks * 1e9 / i.ticksPerSecond)
+	}
+	return time.Duration(float64(ticks) / float64(i.ticksPerSecond) * 1e9)
+}
@@ -0,0 +1,294 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+var captureTime = time.Date(2018, 4, 5, 21, 46, 4, 123456000, time.UTC)
+
+func udpSegment(payload []byte) []byte {
+	segment := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint16(segment[0:2], 40000)
+	binary.BigEndian.PutUint16(segment[2:4], 1700)
+	binary.BigEndian.PutUint16(segment[4:6], uint16(8+len(payload)))
+	return append(segment, payload...)
+}
+
+func ipv4Packet(id uint16, offset int, more bool, payload []byte) []byte {
+	packet := make([]byte, 20, 20+len(payload))
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[2:4], uint16(20+len(payload)))
+	binary.BigEndian.PutUint16(packet[4:6], id)
+	flags := uint16(offset / 8)
+	if more {
+		flags |= 0x2000
+	}
+	binary.BigEndian.PutUint16(packet[6:8], flags)
+	packet[8] = 64
+	packet[9] = protocolUDP
+	copy(packet[12:16], net.IPv4(192, 168, 1, 10).To4())
+	copy(packet[16:20], net.IPv4(10, 0, 0, 1).To4())
+	return append(packet, payload...)
+}
+
+func ipv6Fragment(id uint32, offset int, more bool, payload []byte) []byte {
+	packet := make([]byte, 48, 48+len(payload))
+	packet[0] = 0x60
+	binary.BigEndian.PutUint16(packet[4:6], uint16(8+len(payload)))
+	packet[6] = protocolFragment
+	packet[7] = 64
+	copy(packet[8:24], net.ParseIP("2001:db8::10"))
+	copy(packet[24:40], net.ParseIP("2001:db8::1"))
+	packet[40] = protocolUDP
+	fragmentOffset := uint16(offset/8) << 3
+	if more {
+		fragmentOffset |= 1
+	}
+	binary.BigEndian.PutUint16(packet[42:44], fragmentOffset)
+	binary.BigEndian.PutUint32(packet[44:48], id)
+	return append(packet, payload...)
+}
+
+func ethernetFrame(etherType uint16, payload []byte) []byte {
+	frame := make([]byte, 14, 18+len(payload))
+	// VLAN tag
+	binary.BigEndian.PutUint16(frame[12:14], etherTypeVLAN)
+	frame = append(frame, 0x00, 0x0a, byte(etherType>>8), byte(etherType))
+	return append(frame, payload...)
+}
+
+func classicCapture(linkType uint32, frames ...[]byte) []byte {
+	var buf bytes.Buffer
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:4], magicMicroseconds)
+	binary.LittleEndian.PutUint16(header[4:6], 2)
+	binary.LittleEndian.PutUint16(header[6:8], 4)
+	binary.LittleEndian.PutUint32(header[16:20], 65535)
+	binary.LittleEndian.PutUint32(header[20:24], linkType)
+	buf.Write(header)
+
+	for i, frame := range frames {
+		t := captureTime.Add(time.Duration(i) * time.Millisecond)
+		record := make([]byte, 16)
+		binary.LittleEndian.PutUint32(record[0:4], uint32(t.Unix()))
+		binary.LittleEndian.PutUint32(record[4:8], uint32(t.Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(record[8:12], uint32(len(frame)))
+		binary.LittleEndian.PutUint32(record[12:16], uint32(len(frame)))
+		buf.Write(record)
+		buf.Write(frame)
+	}
+
+	return buf.Bytes()
+}
+
+func block(order binary.ByteOrder, blockType uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	b := make([]byte, 8, 12+len(body))
+	order.PutUint32(b[0:4], blockType)
+	order.PutUint32(b[4:8], uint32(12+len(body)))
+	b = append(b, body...)
+	trailer := make([]byte, 4)
+	order.PutUint32(trailer, uint32(12+len(body)))
+	return append(b, trailer...)
+}
+
+func ngCapture(order binary.ByteOrder, linkType uint16, frames ...[]byte) []byte {
+	var buf bytes.Buffer
+
+	shb := make([]byte, 16)
+	order.PutUint32(shb[0:4], byteOrderMagic)
+	order.PutUint16(shb[4:6], 1)
+	binary.LittleEndian.PutUint64(shb[8:16], 0xffffffffffffffff)
+	buf.Write(block(order, blockSectionHeader, shb))
+
+	// nanosecond resolution
+	idb := make([]byte, 8)
+	order.PutUint16(idb[0:2], linkType)
+	option := make([]byte, 4)
+	order.PutUint16(option[0:2], optionInterfaceTSResol)
+	order.PutUint16(option[2:4], 1)
+	idb = append(idb, option...)
+	idb = append(idb, 9, 0, 0, 0, 0, 0, 0, 0)
+	buf.Write(block(order, blockInterfaceDesc, idb))
+
+	for i, frame := range frames {
+		ticks := uint64(captureTime.Add(time.Duration(i) * time.Millisecond).UnixNano())
+		epb := make([]byte, 20)
+		order.PutUint32(epb[4:8], uint32(ticks>>32))
+		order.PutUint32(epb[8:12], uint32(ticks))
+		order.PutUint32(epb[12:16], uint32(len(frame)))
+		order.PutUint32(epb[16:20], uint32(len(frame)))
+		buf.Write(block(order, blockEnhancedPacket, append(epb, frame...)))
+	}
+
+	return buf.Bytes()
+}
+
+func readDatagrams(t *testing.T, capture []byte) []*Datagram {
+	reader, err := NewReader(bytes.NewReader(capture))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var datagrams []*Datagram
+	udpReader := NewUDPReader(reader)
+	for {
+		datagram, err := udpReader.Next()
+		if err == io.EOF {
+			return datagrams
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		datagrams = append(datagrams, datagram)
+	}
+}
+
+func TestClassicCapture(t *testing.T) {
+	payload := []byte("hello gateway")
+	capture := classicCapture(linkTypeEthernet, ethernetFrame(etherTypeIPv4, ipv4Packet(1, 0, false, udpSegment(payload))))
+
+	datagrams := readDatagrams(t, capture)
+	if len(datagrams) != 1 {
+		t.Fatalf("expected 1 datagram, got %d", len(datagrams))
+	}
+
+	d := datagrams[0]
+	if !bytes.Equal(d.Payload, payload) {
+		t.Errorf("wrong payload: %q", d.Payload)
+	}
+	if d.DestinationPort != 1700 || d.SourcePort != 40000 {
+		t.Errorf("wrong ports: %d -> %d", d.SourcePort, d.DestinationPort)
+	}
+	if !d.Source.Equal(net.IPv4(192, 168, 1, 10)) {
+		t.Errorf("wrong source: %s", d.Source)
+	}
+	if !d.Time.Equal(captureTime) {
+		t.Errorf("wrong time: %s", d.Time)
+	}
+}
+
+func TestNgCapture(t *testing.T) {
+	payload := []byte("hello gateway")
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		capture := ngCapture(order, linkTypeRaw, ipv4Packet(1, 0, false, udpSegment(payload)))
+
+		datagrams := readDatagrams(t, capture)
+		if len(datagrams) != 1 {
+			t.Fatalf("%s: expected 1 datagram, got %d", order, len(datagrams))
+		}
+		if !bytes.Equal(datagrams[0].Payload, payload) {
+			t.Errorf("%s: wrong payload: %q", order, datagrams[0].Payload)
+		}
+		if !datagrams[0].Time.Equal(captureTime) {
+			t.Errorf("%s: wrong time: %s", order, datagrams[0].Time)
+		}
+	}
+}
+
+func simplePacketBlock(order binary.ByteOrder, frame []byte) []byte {
+	spb := make([]byte, 4)
+	order.PutUint32(spb, uint32(len(frame)))
+	return block(order, blockSimplePacket, append(spb, frame...))
+}
+
+func TestSimplePacket(t *testing.T) {
+	order := binary.LittleEndian
+	first := ipv4Packet(1, 0, false, udpSegment([]byte("first")))
+	simple := ipv4Packet(2, 0, false, udpSegment([]byte("simple")))
+
+	// a simple packet block gets the time of the previous packet
+	capture := append(ngCapture(order, linkTypeRaw, first), simplePacketBlock(order, simple)...)
+	datagrams := readDatagrams(t, capture)
+	if len(datagrams) != 2 {
+		t.Fatalf("expected 2 datagrams, got %d", len(datagrams))
+	}
+	if string(datagrams[1].Payload) != "simple" {
+		t.Errorf("wrong payload: %q", datagrams[1].Payload)
+	}
+	if !datagrams[1].Time.Equal(captureTime) {
+		t.Errorf("wrong time: %s", datagrams[1].Time)
+	}
+
+	// without a previous packet there is no time and the datagram is skipped
+	capture = append(ngCapture(order, linkTypeRaw), simplePacketBlock(order, simple)...)
+	if datagrams := readDatagrams(t, capture); len(datagrams) != 0 {
+		t.Errorf("expected no datagrams, got %d", len(datagrams))
+	}
+}
+
+func TestIPv4Reassembly(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 30)
+	segment := udpSegment(payload)
+
+	// out of order fragments
+	capture := classicCapture(linkTypeEthernet,
+		ethernetFrame(etherTypeIPv4, ipv4Packet(7, 160, false, segment[160:])),
+		ethernetFrame(etherTypeIPv4, ipv4Packet(7, 0, true, segment[:80])),
+		ethernetFrame(etherTypeIPv4, ipv4Packet(7, 80, true, segment[80:160])),
+	)
+
+	datagrams := readDatagrams(t, capture)
+	if len(datagrams) != 1 {
+		t.Fatalf("expected 1 datagram, got %d", len(datagrams))
+	}
+	if !bytes.Equal(datagrams[0].Payload, payload) {
+		t.Errorf("wrong payload: %q", datagrams[0].Payload)
+	}
+}
+
+func TestIPv6Reassembly(t *testing.T) {
+	payload := bytes.Repeat([]byte("abcdefghij"), 20)
+	segment := udpSegment(payload)
+
+	capture := ngCapture(binary.LittleEndian, linkTypeEthernet,
+		ethernetFrame(etherTypeIPv6, ipv6Fragment(42, 0, true, segment[:104])),
+		ethernetFrame(etherTypeIPv6, ipv6Fragment(42, 104, false, segment[104:])),
+	)
+
+	datagrams := readDatagrams(t, capture)
+	if len(datagrams) != 1 {
+		t.Fatalf("expected 1 datagram, got %d", len(datagrams))
+	}
+	if !bytes.Equal(datagrams[0].Payload, payload) {
+		t.Errorf("wrong payload: %q", datagrams[0].Payload)
+	}
+	if !datagrams[0].Destination.Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("wrong destination: %s", datagrams[0].Destination)
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if _, err := NewReader(bytes.NewReader([]byte("not a capture file at all"))); err == nil {
+		t.Error("expected an error")
+	}
+}
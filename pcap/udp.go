@@ -0,0 +1,362 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pcap
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/pkg/errors"
+)
+
+// Link types, see http://www.tcpdump.org/linktypes.html
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRawAlt   = 12
+	linkTypeRaw      = 101
+	linkTypeLoop     = 108
+	linkTypeLinuxSLL = 113
+	linkTypeIPv4     = 228
+	linkTypeIPv6     = 229
+	linkTypeSLL2     = 276
+)
+
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	etherTypeVLAN = 0x8100
+	etherTypeQinQ = 0x88a8
+
+	protocolUDP      = 17
+	protocolFragment = 44
+
+	// fragmentTimeout is the capture time after which incomplete datagrams are dropped.
+	fragmentTimeout = 60 * time.Second
+)
+
+// Datagram is a (reassembled) UDP datagram.
+type Datagram struct {
+	Time            time.Time
+	Source          net.IP
+	Destination     net.IP
+	SourcePort      uint16
+	DestinationPort uint16
+	Payload         []byte
+}
+
+// UDPReader returns the UDP datagrams of a capture, fragmented IP packets are
+// reassembled.
+type UDPReader struct {
+	reader    *Reader
+	fragments map[fragmentKey]*fragmentedPacket
+}
+
+type fragmentKey struct {
+	source      string
+	destination string
+	id          uint32
+}
+
+type fragmentedPacket struct {
+	first     time.Time
+	fragments []fragment
+	// length is the total length, known once the last fragment arrived.
+	length int
+}
+
+type fragment struct {
+	offset int
+	data   []byte
+}
+
+// ipPacket is the part of an IP packet we need.
+type ipPacket struct {
+	source      net.IP
+	destination net.IP
+	protocol    uint8
+	id          uint32
+	offset      int
+	more        bool
+	payload     []byte
+}
+
+func NewUDPReader(reader *Reader) *UDPReader {
+	return &UDPReader{
+		reader:    reader,
+		fragments: make(map[fragmentKey]*fragmentedPacket),
+	}
+}
+
+// Next returns the next UDP datagram, io.EOF at the end of the capture. Packets
+// that can not be decoded are skipped.
+func (u *UDPReader) Next() (*Datagram, error) {
+	for {
+		packet, err := u.reader.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if packet.Time.IsZero() {
+			log.Warn("skipping packet without timestamp")
+			continue
+		}
+
+		if packet.Truncated {
+			log.WithField("time", packet.Time).Debug("skipping truncated packet")
+			continue
+		}
+
+		ip, err := decodeLinkLayer(packet.LinkType, packet.Data)
+		if err != nil {
+			log.WithError(err).WithField("time", packet.Time).Debug("skipping packet")
+			continue
+		}
+		if ip == nil {
+			continue
+		}
+
+		payload := u.reassemble(packet.Time, ip)
+		if payload == nil || ip.protocol != protocolUDP {
+			continue
+		}
+
+		datagram, err := decodeUDP(payload)
+		if err != nil {
+			log.WithError(err).WithField("time", packet.Time).Debug("skipping packet")
+			continue
+		}
+
+		datagram.Time = packet.Time
+		datagram.Source = ip.source
+		datagram.Destination = ip.destination
+
+		return datagram, nil
+	}
+}
+
+// reassemble returns the complete payload of the packet or nil when fragments are
+// still missing.
+func (u *UDPReader) reassemble(t time.Time, ip *ipPacket) []byte {
+	if ip.offset == 0 && !ip.more {
+		return ip.payload
+	}
+
+	for key, p := range u.fragments {
+		if t.Sub(p.first) > fragmentTimeout {
+			delete(u.fragments, key)
+		}
+	}
+
+	key := fragmentKey{source: ip.source.String(), destination: ip.destination.String(), id: ip.id}
+	p, ok := u.fragments[key]
+	if !ok {
+		p = &fragmentedPacket{first: t}
+		u.fragments[key] = p
+	}
+
+	p.fragments = append(p.fragments, fragment{offset: ip.offset, data: ip.payload})
+	if !ip.more {
+		p.length = ip.offset + len(ip.payload)
+	}
+
+	if p.length == 0 {
+		return nil
+	}
+
+	sort.Slice(p.fragments, func(i, j int) bool {
+		return p.fragments[i].offset < p.fragments[j].offset
+	})
+
+	payload := make([]byte, 0, p.length)
+	for _, f := range p.fragments {
+		if f.offset > len(payload) {
+			return nil
+		}
+		if end := f.offset + len(f.data); end > len(payload) {
+			payload = append(payload, f.data[len(payload)-f.offset:]...)
+		}
+	}
+
+	if len(payload) < p.length {
+		return nil
+	}
+
+	delete(u.fragments, key)
+	return payload[:p.length]
+}
+
+// decodeLinkLayer returns the IP packet in a frame, nil for frames without one.
+func decodeLinkLayer(linkType uint16, data []byte) (*ipPacket, error) {
+	switch linkType {
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return nil, errors.New("ethernet frame too short")
+		}
+		etherType := binary.BigEndian.Uint16(data[12:14])
+		data = data[14:]
+		for etherType == etherTypeVLAN || etherType == etherTypeQinQ {
+			if len(data) < 4 {
+				return nil, errors.New("vlan tag too short")
+			}
+			etherType = binary.BigEndian.Uint16(data[2:4])
+			data = data[4:]
+		}
+		return decodeEtherType(etherType, data)
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return nil, errors.New("linux cooked frame too short")
+		}
+		return decodeEtherType(binary.BigEndian.Uint16(data[14:16]), data[16:])
+	case linkTypeSLL2:
+		if len(data) < 20 {
+			return nil, errors.New("linux cooked v2 frame too short")
+		}
+		return decodeEtherType(binary.BigEndian.Uint16(data[0:2]), data[20:])
+	case linkTypeNull, linkTypeLoop:
+		if len(data) < 4 {
+			return nil, errors.New("loopback frame too short")
+		}
+		return decodeIP(data[4:])
+	case linkTypeRaw, linkTypeRawAlt, linkTypeIPv4, linkTypeIPv6:
+		return decodeIP(data)
+	}
+
+	return nil, errors.Errorf("unsupported link type: %d", linkType)
+}
+
+func decodeEtherType(etherType uint16, data []byte) (*ipPacket, error) {
+	switch etherType {
+	case etherTypeIPv4, etherTypeIPv6:
+		return decodeIP(data)
+	}
+	return nil, nil
+}
+
+func decodeIP(data []byte) (*ipPacket, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty ip packet")
+	}
+
+	switch data[0] >> 4 {
+	case 4:
+		return decodeIPv4(data)
+	case 6:
+		return decodeIPv6(data)
+	}
+
+	return nil, errors.Errorf("unknown ip version: %d", data[0]>>4)
+}
+
+func decodeIPv4(data []byte) (*ipPacket, error) {
+	if len(data) < 20 {
+		return nil, errors.New("ipv4 packet too short")
+	}
+
+	headerLength := int(data[0]&0x0f) * 4
+	totalLength := int(binary.BigEndian.Uint16(data[2:4]))
+	if headerLength < 20 || totalLength < headerLength || totalLength > len(data) {
+		return nil, errors.New("invalid ipv4 length")
+	}
+
+	flags := binary.BigEndian.Uint16(data[6:8])
+
+	return &ipPacket{
+		source:      net.IP(data[12:16]),
+		destination: net.IP(data[16:20]),
+		protocol:    data[9],
+		id:          uint32(binary.BigEndian.Uint16(data[4:6])),
+		offset:      int(flags&0x1fff) * 8,
+		more:        flags&0x2000 != 0,
+		payload:     data[headerLength:totalLength],
+	}, nil
+}
+
+func decodeIPv6(data []byte) (*ipPacket, error) {
+	if len(data) < 40 {
+		return nil, errors.New("ipv6 packet too short")
+	}
+
+	payloadLength := int(binary.BigEndian.Uint16(data[4:6]))
+	if 40+payloadLength > len(data) {
+		return nil, errors.New("invalid ipv6 length")
+	}
+
+	packet := &ipPacket{
+		source:      net.IP(data[8:24]),
+		destination: net.IP(data[24:40]),
+	}
+
+	next := data[6]
+	payload := data[40 : 40+payloadLength]
+
+	// skip the extension headers
+	for {
+		switch next {
+		case 0, 43, 60:
+			if len(payload) < 8 {
+				return nil, errors.New("ipv6 extension header too short")
+			}
+			length := (int(payload[1]) + 1) * 8
+			if length > len(payload) {
+				return nil, errors.New("invalid ipv6 extension header length")
+			}
+			next = payload[0]
+			payload = payload[length:]
+		case protocolFragment:
+			if len(payload) < 8 {
+				return nil, errors.New("ipv6 fragment header too short")
+			}
+			offset := binary.BigEndian.Uint16(payload[2:4])
+			packet.offset = int(offset>>3) * 8
+			packet.more = offset&0x01 != 0
+			packet.id = binary.BigEndian.Uint32(payload[4:8])
+			next = payload[0]
+			payload = payload[8:]
+		default:
+			packet.protocol = next
+			packet.payload = payload
+			return packet, nil
+		}
+	}
+}
+
+func decodeUDP(data []byte) (*Datagram, error) {
+	if len(data) < 8 {
+		return nil, errors.New("udp header too short")
+	}
+
+	length := int(binary.BigEndian.Uint16(data[4:6]))
+	if length < 8 || length > len(data) {
+		return nil, errors.New("invalid udp length")
+	}
+
+	return &Datagram{
+		SourcePort:      binary.BigEndian.Uint16(data[0:2]),
+		DestinationPort: binary.BigEndian.Uint16(data[2:4]),
+		Payload:         data[8:length],
+	}, nil
+}
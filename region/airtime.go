@@ -0,0 +1,107 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package region
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// fskOverhead is the number of bytes an FSK frame adds to the payload: preamble (5),
+// sync word (3), length (1) and crc (2).
+const fskOverhead = 5 + 3 + 1 + 2
+
+// CodingRate45 is the coding rate of LoRaWAN uplinks (4/5).
+const CodingRate45 = 1
+
+// FrameSettings are the radio settings of a frame that determine its time on air.
+type FrameSettings struct {
+	// CodingRate is 1 for 4/5 up to 4 for 4/8.
+	CodingRate      int
+	PreambleSymbols int
+	ImplicitHeader  bool
+	NoCRC           bool
+}
+
+// UplinkFrame are the settings of LoRaWAN uplinks: coding rate 4/5, an 8 symbol
+// preamble, an explicit header and a CRC.
+var UplinkFrame = FrameSettings{
+	CodingRate:      CodingRate45,
+	PreambleSymbols: 8,
+}
+
+// ParseCodingRate parses a coding rate like 4/5 (or CR_4_5) into 1 up to 4 for 4/8.
+func ParseCodingRate(s string) (int, error) {
+	var k, n int
+	s = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "CR_")
+	if _, err := fmt.Sscanf(strings.Replace(s, "_", "/", 1), "%d/%d", &k, &n); err != nil || k != 4 || n < 5 || n > 8 {
+		return 0, errors.Errorf("invalid coding rate: %s", s)
+	}
+	return n - 4, nil
+}
+
+// TimeOnAir returns the time on air of a frame with a PHY payload of the given size (in
+// bytes). Low data rate optimization is used when a symbol takes 16 ms or longer.
+func (d DataRate) TimeOnAir(size int, frame FrameSettings) time.Duration {
+	if d.Modulation == FSK {
+		if d.BitRate <= 0 {
+			return 0
+		}
+		return time.Duration(float64((size+fskOverhead)*8) / float64(d.BitRate) * float64(time.Second))
+	}
+
+	if d.SpreadingFactor <= 0 || d.Bandwidth <= 0 {
+		return 0
+	}
+
+	codingRate := frame.CodingRate
+	if codingRate < 1 || codingRate > 4 {
+		codingRate = CodingRate45
+	}
+
+	sf := float64(d.SpreadingFactor)
+	symbol := math.Pow(2, sf) / float64(d.Bandwidth*1000)
+
+	lowDataRate := 0.0
+	if symbol >= 0.016 {
+		lowDataRate = 1
+	}
+
+	crc, header := 1.0, 0.0
+	if frame.NoCRC {
+		crc = 0
+	}
+	if frame.ImplicitHeader {
+		header = 1
+	}
+
+	payloadSymbols := 8 + math.Max(math.Ceil((8*float64(size)-4*sf+28+16*crc-20*header)/(4*(sf-2*lowDataRate)))*
+		float64(codingRate+4), 0)
+
+	seconds := (float64(frame.PreambleSymbols)+4.25)*symbol + payloadSymbols*symbol
+	return time.Duration(math.Round(seconds * float64(time.Second)))
+}
@@ -0,0 +1,433 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package region contains the LoRaWAN regional parameters (data rates, channels and
+// transmit power) of the supported frequency plans.
+package region
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Modulation is the modulation of a data rate.
+type Modulation int
+
+const (
+	LoRa Modulation = iota
+	FSK
+)
+
+// DataRate describes a LoRaWAN data rate.
+type DataRate struct {
+	Modulation      Modulation
+	SpreadingFactor int
+	Bandwidth       int // kHz
+	BitRate         int // bit/s, FSK only
+	DownlinkOnly    bool
+}
+
+// Channel is an uplink channel, the frequency is in Hz.
+type Channel struct {
+	Frequency int
+	MinDR     int
+	MaxDR     int
+}
+
+// Plan is the channel plan of a region.
+type Plan struct {
+	Name string
+	// StationRegion is the name LoRa Basics Station uses for the region.
+	StationRegion string
+	MinFrequency  int
+	MaxFrequency  int
+	// MaxEIRP is the maximum uplink EIRP in dBm.
+	MaxEIRP float64
+	// MaxTXPowerIndex is the highest TXPower index, every index lowers the EIRP by 2 dB.
+	MaxTXPowerIndex int
+	// MaxADRDataRate is the highest data rate index ADR assigns.
+	MaxADRDataRate int
+	DataRates      []DataRate
+	// Channels are the channels a gateway is configured with: the default channels
+	// or, for the regions with fixed channels, the commonly used sub-band.
+	Channels []Channel
+	// UplinkChannels are all the uplink channels in the order of their index, nil
+	// when they are the same as Channels.
+	UplinkChannels []Channel
+	// SubBands are the bands with a duty-cycle limit.
+	SubBands []SubBand
+}
+
+// SubBand is a frequency band (Hz) with a duty-cycle limit, eg. 0.01 for 1%.
+type SubBand struct {
+	Name         string
+	MinFrequency int
+	MaxFrequency int
+	DutyCycle    float64
+}
+
+// Annotation is the regional information of a received uplink.
+type Annotation struct {
+	Region string
+	// Channel is the index of the uplink channel, -1 for frequencies outside the plan.
+	Channel       int
+	DataRateIndex int
+	DataRate      DataRate
+	// Sensitivity is the receiver sensitivity of the data rate in dBm.
+	Sensitivity float64
+	MaxEIRP     float64
+}
+
+const (
+	// noiseFloor is the thermal noise in dBm/Hz.
+	noiseFloor = -174
+	// noiseFigure is the noise figure in dB of a typical gateway receiver.
+	noiseFigure = 6
+	// fskRequiredSNR is the SNR in dB needed to demodulate FSK.
+	fskRequiredSNR = 10
+)
+
+var (
+	UnknownRegionError   = errors.New("unknown region")
+	UnknownDataRateError = errors.New("unknown data rate")
+	OutOfPlanError       = errors.New("frequency is not an uplink channel of the region")
+)
+
+var (
+	loRaDataRates125 = []DataRate{
+		{Modulation: LoRa, SpreadingFactor: 12, Bandwidth: 125},
+		{Modulation: LoRa, SpreadingFactor: 11, Bandwidth: 125},
+		{Modulation: LoRa, SpreadingFactor: 10, Bandwidth: 125},
+		{Modulation: LoRa, SpreadingFactor: 9, Bandwidth: 125},
+		{Modulation: LoRa, SpreadingFactor: 8, Bandwidth: 125},
+		{Modulation: LoRa, SpreadingFactor: 7, Bandwidth: 125},
+	}
+	downlinkDataRates500 = []DataRate{
+		{Modulation: LoRa, SpreadingFactor: 12, Bandwidth: 500, DownlinkOnly: true},
+		{Modulation: LoRa, SpreadingFactor: 11, Bandwidth: 500, DownlinkOnly: true},
+		{Modulation: LoRa, SpreadingFactor: 10, Bandwidth: 500, DownlinkOnly: true},
+		{Modulation: LoRa, SpreadingFactor: 9, Bandwidth: 500, DownlinkOnly: true},
+		{Modulation: LoRa, SpreadingFactor: 8, Bandwidth: 500, DownlinkOnly: true},
+		{Modulation: LoRa, SpreadingFactor: 7, Bandwidth: 500, DownlinkOnly: true},
+	}
+	sf7BW250 = DataRate{Modulation: LoRa, SpreadingFactor: 7, Bandwidth: 250}
+	fsk50    = DataRate{Modulation: FSK, BitRate: 50000}
+)
+
+var plans = map[string]*Plan{
+	"EU868": {
+		Name:            "EU868",
+		StationRegion:   "EU863",
+		MinFrequency:    863000000,
+		MaxFrequency:    870000000,
+		MaxEIRP:         16,
+		MaxTXPowerIndex: 7,
+		MaxADRDataRate:  5,
+		DataRates:       dataRates(loRaDataRates125, []DataRate{sf7BW250, fsk50}),
+		Channels: []Channel{
+			{868100000, 0, 5}, {868300000, 0, 5}, {868500000, 0, 5},
+			{867100000, 0, 5}, {867300000, 0, 5}, {867500000, 0, 5}, {867700000, 0, 5}, {867900000, 0, 5},
+			{868300000, 6, 6},
+			{868800000, 7, 7},
+		},
+		// ETSI EN 300 220 bands, as named in the LoRaWAN regional parameters
+		SubBands: []SubBand{
+			{"g", 863000000, 868000000, 0.01},
+			{"g1", 868000000, 868600000, 0.01},
+			{"g2", 868700000, 869200000, 0.001},
+			{"g3", 869400000, 869650000, 0.1},
+			{"g4", 869700000, 870000000, 0.01},
+		},
+	},
+	"US915": {
+		Name:            "US915",
+		StationRegion:   "US902",
+		MinFrequency:    902000000,
+		MaxFrequency:    928000000,
+		MaxEIRP:         30,
+		MaxTXPowerIndex: 14,
+		MaxADRDataRate:  3,
+		DataRates: dataRates(loRaDataRates125[2:], []DataRate{
+			{Modulation: LoRa, SpreadingFactor: 8, Bandwidth: 500},
+			{}, {}, {},
+		}, downlinkDataRates500),
+		// sub-band 2, which is used by most networks
+		Channels: []Channel{
+			{903900000, 0, 3}, {904100000, 0, 3}, {904300000, 0, 3}, {904500000, 0, 3},
+			{904700000, 0, 3}, {904900000, 0, 3}, {905100000, 0, 3}, {905300000, 0, 3},
+			{904600000, 4, 4},
+		},
+		UplinkChannels: append(channelGrid(902300000, 200000, 64, 0, 3), channelGrid(903000000, 1600000, 8, 4, 4)...),
+	},
+	"AU915": {
+		Name:            "AU915",
+		StationRegion:   "AU915",
+		MinFrequency:    915000000,
+		MaxFrequency:    928000000,
+		MaxEIRP:         30,
+		MaxTXPowerIndex: 14,
+		MaxADRDataRate:  5,
+		DataRates: dataRates(loRaDataRates125, []DataRate{
+			{Modulation: LoRa, SpreadingFactor: 8, Bandwidth: 500},
+			{},
+		}, downlinkDataRates500),
+		// sub-band 2, which is used by most networks
+		Channels: []Channel{
+			{916800000, 0, 5}, {917000000, 0, 5}, {917200000, 0, 5}, {917400000, 0, 5},
+			{917600000, 0, 5}, {917800000, 0, 5}, {918000000, 0, 5}, {918200000, 0, 5},
+			{917500000, 6, 6},
+		},
+		UplinkChannels: append(channelGrid(915200000, 200000, 64, 0, 5), channelGrid(915900000, 1600000, 8, 6, 6)...),
+	},
+	"AS923": {
+		Name:            "AS923",
+		StationRegion:   "AS923",
+		MinFrequency:    915000000,
+		MaxFrequency:    928000000,
+		MaxEIRP:         16,
+		MaxTXPowerIndex: 7,
+		MaxADRDataRate:  5,
+		DataRates:       dataRates(loRaDataRates125, []DataRate{sf7BW250, fsk50}),
+		Channels: []Channel{
+			{923200000, 0, 5}, {923400000, 0, 5},
+		},
+	},
+	"IN865": {
+		Name:            "IN865",
+		StationRegion:   "IN865",
+		MinFrequency:    865000000,
+		MaxFrequency:    867000000,
+		MaxEIRP:         30,
+		MaxTXPowerIndex: 10,
+		MaxADRDataRate:  5,
+		DataRates:       dataRates(loRaDataRates125, []DataRate{{}, fsk50}),
+		Channels: []Channel{
+			{865062500, 0, 5}, {865402500, 0, 5}, {865985000, 0, 5},
+		},
+	},
+	"KR920": {
+		Name:            "KR920",
+		StationRegion:   "KR920",
+		MinFrequency:    920900000,
+		MaxFrequency:    923300000,
+		MaxEIRP:         14,
+		MaxTXPowerIndex: 7,
+		MaxADRDataRate:  5,
+		DataRates:       dataRates(loRaDataRates125),
+		Channels: []Channel{
+			{922100000, 0, 5}, {922300000, 0, 5}, {922500000, 0, 5},
+		},
+	},
+}
+
+func dataRates(lists ...[]DataRate) []DataRate {
+	var all []DataRate
+	for _, list := range lists {
+		all = append(all, list...)
+	}
+	return all
+}
+
+func channelGrid(first, step, count, minDR, maxDR int) []Channel {
+	channels := make([]Channel, count)
+	for i := range channels {
+		channels[i] = Channel{Frequency: first + i*step, MinDR: minDR, MaxDR: maxDR}
+	}
+	return channels
+}
+
+// Get returns the plan of a region, eg. EU868.
+func Get(name string) (*Plan, error) {
+	plan, ok := plans[strings.ToUpper(name)]
+	if !ok {
+		return nil, errors.Wrap(UnknownRegionError, name)
+	}
+	return plan, nil
+}
+
+// Names returns the names of the supported regions.
+func Names() []string {
+	var names []string
+	for name := range plans {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DataRate returns the data rate with the given index.
+func (p *Plan) DataRate(index int) (DataRate, error) {
+	if index < 0 || index >= len(p.DataRates) || !p.DataRates[index].valid() {
+		return DataRate{}, errors.Errorf("%s has no data rate %d", p.Name, index)
+	}
+	return p.DataRates[index], nil
+}
+
+// DataRateIndex returns the index of an uplink data rate, -1 when the region does not
+// have the data rate.
+func (p *Plan) DataRateIndex(dr DataRate) int {
+	for i, d := range p.DataRates {
+		if d.valid() && !d.DownlinkOnly && d.Modulation == dr.Modulation && d.SpreadingFactor == dr.SpreadingFactor &&
+			d.Bandwidth == dr.Bandwidth && d.BitRate == dr.BitRate {
+			return i
+		}
+	}
+	return -1
+}
+
+// ChannelIndex returns the index of the uplink channel with the given frequency (Hz) that
+// allows the data rate index, -1 when there is none. With a negative data rate index
+// only the frequency is matched.
+func (p *Plan) ChannelIndex(frequency int, dataRateIndex int) int {
+	channels := p.UplinkChannels
+	if channels == nil {
+		channels = p.Channels
+	}
+
+	for i, ch := range channels {
+		if ch.Frequency != frequency {
+			continue
+		}
+		if dataRateIndex < 0 || (dataRateIndex >= ch.MinDR && dataRateIndex <= ch.MaxDR) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Annotate returns the regional information of an uplink received on the frequency
+// (Hz) with the data rate. OutOfPlanError is returned together with the annotation
+// when the frequency is not an uplink channel of the region.
+func (p *Plan) Annotate(frequency int, dr DataRate) (Annotation, error) {
+	annotation := Annotation{
+		Region:        p.Name,
+		DataRateIndex: p.DataRateIndex(dr),
+		DataRate:      dr,
+		Sensitivity:   dr.Sensitivity(),
+		MaxEIRP:       p.MaxEIRP,
+	}
+
+	annotation.Channel = p.ChannelIndex(frequency, annotation.DataRateIndex)
+	if annotation.Channel < 0 {
+		return annotation, errors.Wrapf(OutOfPlanError, "%s %.4f MHz %s", p.Name, float64(frequency)/1e6, dr)
+	}
+
+	return annotation, nil
+}
+
+// SubBand returns the duty-cycle band of a frequency (Hz), false when the frequency has
+// no duty-cycle limit.
+func (p *Plan) SubBand(frequency int) (SubBand, bool) {
+	for _, band := range p.SubBands {
+		if frequency >= band.MinFrequency && frequency < band.MaxFrequency {
+			return band, true
+		}
+	}
+	return SubBand{}, false
+}
+
+// TXPower returns the EIRP in dBm of a TXPower index.
+func (p *Plan) TXPower(index int) float64 {
+	return p.MaxEIRP - 2*float64(index)
+}
+
+// TXPowerIndex returns the TXPower index closest to an EIRP in dBm.
+func (p *Plan) TXPowerIndex(eirp float64) int {
+	index := int(math.Round((p.MaxEIRP - eirp) / 2))
+	if index < 0 {
+		return 0
+	}
+	if index > p.MaxTXPowerIndex {
+		return p.MaxTXPowerIndex
+	}
+	return index
+}
+
+// ParseDataRate parses a data rate as the packet forwarder reports it, eg. SF7BW125 or
+// 50000 for FSK.
+func ParseDataRate(s string) (DataRate, error) {
+	if bitRate, err := strconv.Atoi(s); err == nil && bitRate > 0 {
+		return DataRate{Modulation: FSK, BitRate: bitRate}, nil
+	}
+
+	var dr DataRate
+	if _, err := fmt.Sscanf(strings.ToUpper(s), "SF%dBW%d", &dr.SpreadingFactor, &dr.Bandwidth); err != nil ||
+		dr.SpreadingFactor < 5 || dr.SpreadingFactor > 12 || dr.Bandwidth <= 0 {
+		return DataRate{}, errors.Wrap(UnknownDataRateError, s)
+	}
+
+	return dr, nil
+}
+
+// String returns the data rate as the packet forwarder does, eg. SF7BW125 or 50000 for FSK.
+func (d DataRate) String() string {
+	if d.Modulation == FSK {
+		return fmt.Sprintf("%d", d.BitRate)
+	}
+	return fmt.Sprintf("SF%dBW%d", d.SpreadingFactor, d.Bandwidth)
+}
+
+// RequiredSNR returns the lowest SNR in dB at which the data rate can still be
+// demodulated, eg. -7.5 dB for SF7 and -20 dB for SF12.
+func (d DataRate) RequiredSNR() float64 {
+	if d.Modulation == FSK {
+		return fskRequiredSNR
+	}
+	return -5 - 2.5*float64(d.SpreadingFactor-6)
+}
+
+// Sensitivity returns the receiver sensitivity in dBm: the noise in the bandwidth of
+// the data rate plus the noise figure of the receiver and the required SNR. FSK is
+// taken to occupy twice its bit rate.
+func (d DataRate) Sensitivity() float64 {
+	bandwidth := float64(d.Bandwidth) * 1000
+	if d.Modulation == FSK {
+		bandwidth = 2 * float64(d.BitRate)
+	}
+	if bandwidth <= 0 {
+		return 0
+	}
+
+	sensitivity := noiseFloor + 10*math.Log10(bandwidth) + noiseFigure + d.RequiredSNR()
+	return math.Round(sensitivity*10) / 10
+}
+
+// Faster returns the LoRa data rate with the next lower spreading factor and the same
+// bandwidth, false for SF7 and FSK.
+func (d DataRate) Faster() (DataRate, bool) {
+	if d.Modulation != LoRa || d.SpreadingFactor <= 7 {
+		return DataRate{}, false
+	}
+
+	faster := d
+	faster.SpreadingFactor--
+	return faster, true
+}
+
+func (d DataRate) valid() bool {
+	return d.SpreadingFactor > 0 || d.BitRate > 0
+}
@@ -0,0 +1,171 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package region
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestGet(t *testing.T) {
+	for _, name := range []string{"EU868", "US915", "AS923", "AU915", "IN865", "KR920", "eu868"} {
+		if _, err := Get(name); err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		}
+	}
+
+	if _, err := Get("XX123"); errors.Cause(err) != UnknownRegionError {
+		t.Errorf("expected UnknownRegionError, got %v", err)
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	tests := []struct {
+		region      string
+		frequency   int
+		dataRate    string
+		channel     int
+		index       int
+		sensitivity float64
+		maxEIRP     float64
+		outOfPlan   bool
+	}{
+		{"EU868", 868100000, "SF7BW125", 0, 5, -124.5, 16, false},
+		{"EU868", 868300000, "SF12BW125", 1, 0, -137, 16, false},
+		{"EU868", 868300000, "SF7BW250", 8, 6, -121.5, 16, false},
+		{"EU868", 868800000, "50000", 9, 7, -108, 16, false},
+		{"EU868", 869525000, "SF9BW125", -1, 3, -129.5, 16, true},
+		{"US915", 902300000, "SF10BW125", 0, 0, -132, 30, false},
+		{"US915", 904600000, "SF8BW500", 65, 4, -121, 30, false},
+		{"AU915", 917500000, "SF8BW500", 65, 6, -121, 30, false},
+		{"AS923", 923400000, "SF7BW125", 1, 5, -124.5, 16, false},
+		{"IN865", 865985000, "SF12BW125", 2, 0, -137, 30, false},
+		{"KR920", 922500000, "SF8BW125", 2, 4, -127, 14, false},
+		{"KR920", 868100000, "SF8BW125", -1, 4, -127, 14, true},
+	}
+
+	for _, test := range tests {
+		plan, err := Get(test.region)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dr, err := ParseDataRate(test.dataRate)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		annotation, err := plan.Annotate(test.frequency, dr)
+		if test.outOfPlan != (errors.Cause(err) == OutOfPlanError) {
+			t.Errorf("%s %d %s: unexpected error: %v", test.region, test.frequency, test.dataRate, err)
+		}
+
+		if annotation.Region != test.region || annotation.Channel != test.channel || annotation.DataRateIndex != test.index ||
+			annotation.Sensitivity != test.sensitivity || annotation.MaxEIRP != test.maxEIRP {
+			t.Errorf("%s %d %s: wrong annotation %+v", test.region, test.frequency, test.dataRate, annotation)
+		}
+	}
+}
+
+func TestParseDataRate(t *testing.T) {
+	for _, s := range []string{"SF7BW125", "SF12BW500", "50000"} {
+		dr, err := ParseDataRate(s)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", s, err)
+			continue
+		}
+		if dr.String() != s {
+			t.Errorf("%s: parsed as %s", s, dr)
+		}
+	}
+
+	for _, s := range []string{"", "SF13BW125", "BW125", "fast"} {
+		if _, err := ParseDataRate(s); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
+
+func TestTimeOnAir(t *testing.T) {
+	tests := []struct {
+		dataRate   string
+		size       int
+		codingRate string
+		expected   time.Duration
+	}{
+		{"SF7BW125", 13, "4/5", 46336 * time.Microsecond},
+		{"SF12BW125", 13, "4/5", 1155072 * time.Microsecond},
+		{"SF7BW250", 13, "4/5", 23168 * time.Microsecond},
+		{"SF7BW125", 13, "4/8", 61696 * time.Microsecond},
+		{"SF7BW125", 13, "CR_4_6", 51456 * time.Microsecond},
+		{"50000", 13, "4/5", 3840 * time.Microsecond},
+	}
+
+	for _, test := range tests {
+		dr, err := ParseDataRate(test.dataRate)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		codingRate, err := ParseCodingRate(test.codingRate)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		frame := UplinkFrame
+		frame.CodingRate = codingRate
+		if airtime := dr.TimeOnAir(test.size, frame); airtime != test.expected {
+			t.Errorf("%s %d bytes %s: expected %s, got %s", test.dataRate, test.size, test.codingRate,
+				test.expected, airtime)
+		}
+	}
+}
+
+func TestSubBand(t *testing.T) {
+	eu868, _ := Get("EU868")
+	us915, _ := Get("US915")
+
+	tests := []struct {
+		plan      *Plan
+		frequency int
+		name      string
+		dutyCycle float64
+		ok        bool
+	}{
+		{eu868, 868100000, "g1", 0.01, true},
+		{eu868, 867500000, "g", 0.01, true},
+		{eu868, 868800000, "g2", 0.001, true},
+		{eu868, 869525000, "g3", 0.1, true},
+		{eu868, 868650000, "", 0, false},
+		{us915, 904600000, "", 0, false},
+	}
+
+	for _, test := range tests {
+		band, ok := test.plan.SubBand(test.frequency)
+		if ok != test.ok || band.Name != test.name || band.DutyCycle != test.dutyCycle {
+			t.Errorf("%s %d: unexpected sub-band %+v (%v)", test.plan.Name, test.frequency, band, ok)
+		}
+	}
+}
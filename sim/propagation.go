@@ -0,0 +1,99 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sim
+
+import (
+	"math"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// earthRadius is the mean radius of the earth in meter.
+const earthRadius = 6371000
+
+var UnknownPropagationError = errors.New("unknown propagation model (use freespace, logdistance or hata)")
+
+// Propagation is a model of the path loss between a device and a gateway.
+type Propagation interface {
+	// PathLoss returns the path loss in dB over a distance in meter at a frequency
+	// in MHz, the height is the height of the gateway antenna in meter.
+	PathLoss(distance, frequency, height float64) float64
+}
+
+// FreeSpace is the path loss without any obstacles.
+type FreeSpace struct{}
+
+// LogDistance is the free space path loss at 1 meter increased by 10*Exponent dB per
+// decade of distance, eg. 2.7 to 3.5 in urban areas.
+type LogDistance struct {
+	Exponent float64
+}
+
+// Hata is the Okumura-Hata model for urban areas in a small or medium sized city,
+// it is never lower than the free space path loss.
+type Hata struct {
+	// DeviceHeight is the height of the device antenna in meter.
+	DeviceHeight float64
+}
+
+// GetPropagation returns a propagation model by name with its usual parameters.
+func GetPropagation(name string) (Propagation, error) {
+	switch strings.ToLower(name) {
+	case "freespace":
+		return FreeSpace{}, nil
+	case "logdistance":
+		return LogDistance{Exponent: 2.7}, nil
+	case "hata":
+		return Hata{DeviceHeight: 1.5}, nil
+	}
+
+	return nil, errors.Wrap(UnknownPropagationError, name)
+}
+
+func (FreeSpace) PathLoss(distance, frequency, height float64) float64 {
+	return 20*math.Log10(math.Max(distance, 1)/1000) + 20*math.Log10(frequency) + 32.44
+}
+
+func (m LogDistance) PathLoss(distance, frequency, height float64) float64 {
+	return FreeSpace{}.PathLoss(1, frequency, height) + 10*m.Exponent*math.Log10(math.Max(distance, 1))
+}
+
+func (m Hata) PathLoss(distance, frequency, height float64) float64 {
+	f := math.Log10(frequency)
+	correction := (1.1*f-0.7)*m.DeviceHeight - (1.56*f - 0.8)
+	loss := 69.55 + 26.16*f - 13.82*math.Log10(height) - correction +
+		(44.9-6.55*math.Log10(height))*math.Log10(math.Max(distance, 1)/1000)
+
+	return math.Max(loss, FreeSpace{}.PathLoss(distance, frequency, height))
+}
+
+// Distance returns the great-circle distance in meter between two positions.
+func Distance(latitude1, longitude1, latitude2, longitude2 float64) float64 {
+	lat1, lat2 := latitude1*math.Pi/180, latitude2*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (longitude2 - longitude1) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
@@ -0,0 +1,238 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package sim simulates drive tests: a device sends its location in uplinks while it
+// moves along a route and the gateways in range receive them, with path loss, noise,
+// lost packets and crc errors.
+package sim
+
+import (
+	"encoding/base64"
+	"io"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/brocaar/lorawan"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/bullettime/lora-coverage/region"
+	"github.com/bullettime/lora-coverage/track"
+	"github.com/pkg/errors"
+)
+
+// Gateway is a simulated gateway.
+type Gateway struct {
+	Mac       model.MacAddress
+	Latitude  float64
+	Longitude float64
+	// Height of the antenna above the ground in meter.
+	Height float64
+}
+
+// Device is the simulated device that sends the uplinks.
+type Device struct {
+	DevAddr lorawan.DevAddr
+	Keys    *model.DeviceKeys
+	// Power is the EIRP in dBm, it is sent in the payload.
+	Power    int8
+	DataRate region.DataRate
+}
+
+// Config is the setup of a simulation.
+type Config struct {
+	Plan        *region.Plan
+	Gateways    []Gateway
+	Device      Device
+	Propagation Propagation
+	// Interval is the time between the uplinks.
+	Interval time.Duration
+	// Shadowing is the standard deviation in dB of the (log-normal) shadow fading
+	// added to the path loss of every reception.
+	Shadowing float64
+	// Loss is the probability that a gateway misses an uplink it could receive, eg.
+	// by a collision.
+	Loss float64
+	// CRCMargin is the link margin in dB below which receptions can have a crc
+	// error, the probability rises from 0 at the margin to 1 at the sensitivity.
+	CRCMargin float64
+	Rand      *rand.Rand
+}
+
+// Uplink is a simulated uplink with the receptions of the gateways.
+type Uplink struct {
+	Time       time.Time
+	Latitude   float64
+	Longitude  float64
+	FCnt       uint32
+	Receptions []*model.RxPacket
+}
+
+// Simulate sends an uplink every interval from the start to the end of the route, the
+// frame counter starts at 0.
+func Simulate(route track.Track, config *Config) ([]*Uplink, error) {
+	if len(route) == 0 {
+		return nil, track.EmptyTrackError
+	}
+
+	drIndex := config.Plan.DataRateIndex(config.Device.DataRate)
+	if drIndex < 0 {
+		return nil, errors.Wrap(region.UnknownDataRateError, config.Device.DataRate.String())
+	}
+
+	var channels []region.Channel
+	for _, channel := range config.Plan.Channels {
+		if drIndex >= channel.MinDR && drIndex <= channel.MaxDR {
+			channels = append(channels, channel)
+		}
+	}
+	if len(channels) == 0 {
+		return nil, errors.Errorf("no channel for data rate %s", config.Device.DataRate)
+	}
+
+	var uplinks []*Uplink
+
+	end := route[len(route)-1].Time
+	for t := route[0].Time; !t.After(end); t = t.Add(config.Interval) {
+		latitude, longitude, ok := route.Position(t, track.Options{Interpolate: true})
+		if !ok {
+			continue
+		}
+
+		uplink := &Uplink{Time: t, Latitude: latitude, Longitude: longitude, FCnt: uint32(len(uplinks))}
+		channel := channels[config.Rand.Intn(len(channels))]
+
+		phy, err := encodeUplink(uplink, config, drIndex, config.Plan.ChannelIndex(channel.Frequency, drIndex))
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range config.Gateways {
+			if packet := config.receive(&config.Gateways[i], uplink, channel, phy); packet != nil {
+				uplink.Receptions = append(uplink.Receptions, packet)
+			}
+		}
+
+		uplinks = append(uplinks, uplink)
+		if config.Interval <= 0 {
+			break
+		}
+	}
+
+	return uplinks, nil
+}
+
+// RandomWalk returns a route from the start point with a point every step, the
+// heading changes by up to 30 degrees at every point. The speed is in m/s.
+func RandomWalk(start track.Point, duration, step time.Duration, speed float64, rnd *rand.Rand) track.Track {
+	route := track.Track{start}
+	heading := rnd.Float64() * 2 * math.Pi
+
+	for p := start; p.Time.Sub(start.Time) < duration; {
+		heading += (rnd.Float64() - 0.5) * math.Pi / 3
+		distance := speed * step.Seconds()
+
+		p.Time = p.Time.Add(step)
+		p.Latitude += distance * math.Cos(heading) / earthRadius * 180 / math.Pi
+		p.Longitude += distance * math.Sin(heading) / (earthRadius * math.Cos(p.Latitude*math.Pi/180)) * 180 / math.Pi
+		route = append(route, p)
+	}
+
+	return route
+}
+
+// WriteLog writes the receptions of the uplinks as the lines of a lora-logger file.
+func WriteLog(w io.Writer, uplinks []*Uplink) error {
+	for _, uplink := range uplinks {
+		for _, packet := range uplink.Receptions {
+			line, err := packet.LogLine()
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(append(line, '\n')); err != nil {
+				return errors.Wrap(err, "error writing log line")
+			}
+		}
+	}
+
+	return nil
+}
+
+func encodeUplink(uplink *Uplink, config *Config, drIndex, chIndex int) ([]byte, error) {
+	payload, err := model.EncodePayload(uplink.Latitude, uplink.Longitude, config.Device.Power)
+	if err != nil {
+		return nil, err
+	}
+
+	frame := &model.UplinkFrame{DevAddr: config.Device.DevAddr, FCnt: uplink.FCnt, FPort: 1, Payload: payload}
+	ctx := model.MICContext{FCnt: uplink.FCnt, TxDR: drIndex, TxCh: chIndex}
+
+	return model.EncodeUplink(frame, config.Device.Keys, ctx)
+}
+
+// receive returns the reception of the uplink by the gateway, nil when the gateway
+// did not receive it.
+func (config *Config) receive(gateway *Gateway, uplink *Uplink, channel region.Channel, phy []byte) *model.RxPacket {
+	dr := config.Device.DataRate
+	frequency := float64(channel.Frequency) / 1e6
+
+	distance := Distance(gateway.Latitude, gateway.Longitude, uplink.Latitude, uplink.Longitude)
+	rssi := float64(config.Device.Power) - config.Propagation.PathLoss(distance, frequency, gateway.Height) +
+		config.Rand.NormFloat64()*config.Shadowing
+	snr := rssi - (dr.Sensitivity() - dr.RequiredSNR())
+
+	margin := snr - dr.RequiredSNR()
+	if margin < 0 || config.Rand.Float64() < config.Loss {
+		return nil
+	}
+
+	packet := &model.RxPacket{
+		GatewayMac: gateway.Mac,
+		Time:       model.CompactTime(uplink.Time),
+		Frequency:  frequency,
+		Crc:        1,
+		RSSI:       int16(math.Round(rssi)),
+		Size:       uint16(len(phy)),
+		Timestamp:  uint32(uplink.Time.UnixNano() / int64(time.Microsecond)),
+		Plan:       config.Plan,
+	}
+
+	// the packet forwarder reports no SNR and coding rate for FSK
+	if dr.Modulation == region.FSK {
+		packet.Modulation = model.ModulationFSK
+		packet.DataR = model.DataRate{FSK: uint32(dr.BitRate)}
+	} else {
+		packet.Modulation = model.ModulationLoRa
+		packet.DataR = model.DataRate{LoRa: dr.String()}
+		packet.CodingRate = "4/5"
+		packet.SNR = math.Round(snr*10) / 10
+	}
+
+	data := phy
+	if margin < config.CRCMargin && config.Rand.Float64() > margin/config.CRCMargin {
+		data = append([]byte(nil), phy...)
+		data[config.Rand.Intn(len(data))] ^= byte(1 + config.Rand.Intn(255))
+		packet.Crc = -1
+	}
+	packet.Data = base64.StdEncoding.EncodeToString(data)
+
+	return packet
+}
@@ -0,0 +1,198 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sim
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brocaar/lorawan"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/bullettime/lora-coverage/region"
+	"github.com/bullettime/lora-coverage/track"
+)
+
+var testKey = lorawan.AES128Key{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+func testConfig(gateways ...Gateway) *Config {
+	plan, _ := region.Get("EU868")
+	dr, _ := region.ParseDataRate("SF7BW125")
+
+	return &Config{
+		Plan:     plan,
+		Gateways: gateways,
+		Device: Device{
+			DevAddr: lorawan.DevAddr{0x26, 0x01, 0x1b, 0x2c},
+			Keys: &model.DeviceKeys{Version: model.LoRaWAN10, FNwkSIntKey: testKey, SNwkSIntKey: testKey,
+				NwkSEncKey: testKey, AppSKey: testKey},
+			Power:    14,
+			DataRate: dr,
+		},
+		Propagation: LogDistance{Exponent: 2.7},
+		Interval:    time.Minute,
+		Rand:        rand.New(rand.NewSource(1)),
+	}
+}
+
+func TestDistance(t *testing.T) {
+	// one degree of latitude is about 111 km
+	if d := Distance(50, 4, 51, 4); math.Abs(d-111195) > 100 {
+		t.Errorf("wrong distance: %f", d)
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	for _, name := range []string{"freespace", "logdistance", "hata"} {
+		model, err := GetPropagation(name)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if near, far := model.PathLoss(100, 868, 30), model.PathLoss(10000, 868, 30); near >= far {
+			t.Errorf("%s: path loss should increase with distance: %f %f", name, near, far)
+		}
+	}
+
+	// 20 dB per decade in free space
+	if loss := (FreeSpace{}).PathLoss(1000, 868, 30); math.Abs(loss-91.2) > 0.1 {
+		t.Errorf("wrong free space path loss: %f", loss)
+	}
+
+	if _, err := GetPropagation("nope"); err == nil {
+		t.Error("expected an error for an unknown model")
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	start := time.Date(2018, 4, 5, 21, 0, 0, 0, time.UTC)
+	route := track.Track{
+		{Time: start, Latitude: 50.9643, Longitude: 1.088},
+		{Time: start.Add(10 * time.Minute), Latitude: 50.9743, Longitude: 1.088},
+	}
+
+	near := Gateway{Mac: model.MacAddress{0xaa, 0x55, 0x5a, 0, 0, 0, 1, 1}, Latitude: 50.97, Longitude: 1.09, Height: 30}
+	far := Gateway{Mac: model.MacAddress{0xaa, 0x55, 0x5a, 0, 0, 0, 1, 2}, Latitude: 52, Longitude: 4, Height: 30}
+
+	uplinks, err := Simulate(route, testConfig(near, far))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(uplinks) != 11 {
+		t.Fatalf("expected 11 uplinks, got %d", len(uplinks))
+	}
+
+	for i, uplink := range uplinks {
+		if len(uplink.Receptions) != 1 || uplink.Receptions[0].GatewayMac != near.Mac {
+			t.Fatalf("uplink %d: expected a reception by the near gateway only: %+v", i, uplink.Receptions)
+		}
+
+		var c model.Coverage
+		packet := uplink.Receptions[0]
+		packet.Keys = testConfig().Device.Keys
+		if err := c.FromRxPacket(packet); err != nil {
+			t.Fatalf("uplink %d: unexpected error: %v", i, err)
+		}
+		if *c.FCnt != uint32(i) || c.Power != 14 || math.Abs(c.Latitude-uplink.Latitude) > 0.0001 {
+			t.Errorf("uplink %d: wrong row: %+v", i, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := WriteLog(&buf, uplinks); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 11 {
+		t.Fatalf("expected 11 lines, got %d", len(lines))
+	}
+
+	var message struct {
+		Message string
+		Fields  model.RxPacket
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &message); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if message.Message != "PUSH_DATA: RXPK" || message.Fields.Data != uplinks[0].Receptions[0].Data {
+		t.Errorf("wrong line: %s", lines[0])
+	}
+}
+
+func TestSimulateCrcErrors(t *testing.T) {
+	start := time.Date(2018, 4, 5, 21, 0, 0, 0, time.UTC)
+	route := track.Track{{Time: start, Latitude: 50.9643, Longitude: 1.088}, {Time: start.Add(time.Hour)}}
+	route[1].Latitude, route[1].Longitude = route[0].Latitude, route[0].Longitude
+
+	config := testConfig(Gateway{Latitude: 50.97, Longitude: 1.09, Height: 30})
+	config.CRCMargin = 1000
+
+	uplinks, err := Simulate(route, config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	crcErrors := 0
+	for _, uplink := range uplinks {
+		for _, packet := range uplink.Receptions {
+			if packet.Crc < 0 {
+				crcErrors++
+				phy, _ := base64.StdEncoding.DecodeString(packet.Data)
+				if ok, _ := validMIC(phy); ok {
+					t.Error("expected a corrupted payload")
+				}
+			}
+		}
+	}
+	if crcErrors == 0 {
+		t.Error("expected crc errors")
+	}
+}
+
+func TestRandomWalk(t *testing.T) {
+	start := track.Point{Time: time.Date(2018, 4, 5, 21, 0, 0, 0, time.UTC), Latitude: 50.9643, Longitude: 1.088}
+	route := RandomWalk(start, time.Minute, 10*time.Second, 10, rand.New(rand.NewSource(1)))
+
+	if len(route) != 7 {
+		t.Fatalf("expected 7 points, got %d", len(route))
+	}
+	for i := 1; i < len(route); i++ {
+		d := Distance(route[i-1].Latitude, route[i-1].Longitude, route[i].Latitude, route[i].Longitude)
+		if math.Abs(d-100) > 0.5 {
+			t.Errorf("point %d: expected a step of 100 m, got %f", i, d)
+		}
+	}
+}
+
+func validMIC(phy []byte) (bool, error) {
+	var payload lorawan.PHYPayload
+	if err := payload.UnmarshalBinary(phy); err != nil {
+		return false, err
+	}
+	return payload.ValidateMIC(testKey)
+}
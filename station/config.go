@@ -0,0 +1,222 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package station
+
+import (
+	"sort"
+
+	"github.com/bullettime/lora-coverage/region"
+	"github.com/pkg/errors"
+)
+
+const (
+	maxMultiSFChannels = 8
+	fskBandwidth       = 125
+)
+
+// maxIF is the maximum offset (in Hz) of a channel from the center frequency of its
+// radio, it depends on the bandwidth of the channel (in kHz).
+var maxIF = map[int]int{
+	125: 400000,
+	250: 375000,
+	500: 300000,
+}
+
+// routerConfig is the router_config message that configures the channels and data
+// rates of a Basics Station gateway.
+type routerConfig struct {
+	MsgType    string                   `json:"msgtype"`
+	NetID      []int                    `json:"NetID"`
+	JoinEUI    [][2]uint64              `json:"JoinEui"`
+	Region     string                   `json:"region"`
+	HWSpec     string                   `json:"hwspec"`
+	FreqRange  [2]int                   `json:"freq_range"`
+	DRs        [16][3]int               `json:"DRs"`
+	SX1301Conf []map[string]interface{} `json:"sx1301_conf"`
+	NoCCA      bool                     `json:"nocca"`
+	NoDC       bool                     `json:"nodc"`
+	NoDwell    bool                     `json:"nodwell"`
+}
+
+type sx1301Channel struct {
+	key       string
+	frequency int
+	bandwidth int
+	extra     map[string]interface{}
+}
+
+func newRouterConfig(plan *region.Plan) (*routerConfig, error) {
+	conf, err := sx1301Config(plan)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &routerConfig{
+		MsgType:    "router_config",
+		Region:     plan.StationRegion,
+		HWSpec:     "sx1301/1",
+		FreqRange:  [2]int{plan.MinFrequency, plan.MaxFrequency},
+		SX1301Conf: []map[string]interface{}{conf},
+		// the gateway is only used to receive, there is no need to enforce these
+		NoCCA:   true,
+		NoDC:    true,
+		NoDwell: true,
+	}
+
+	for i := range config.DRs {
+		config.DRs[i] = [3]int{-1, 0, 0}
+
+		dr, err := plan.DataRate(i)
+		if err != nil {
+			continue
+		}
+
+		var downlinkOnly int
+		if dr.DownlinkOnly {
+			downlinkOnly = 1
+		}
+
+		if dr.Modulation == region.FSK {
+			config.DRs[i] = [3]int{0, 0, downlinkOnly}
+		} else {
+			config.DRs[i] = [3]int{dr.SpreadingFactor, dr.Bandwidth, downlinkOnly}
+		}
+	}
+
+	return config, nil
+}
+
+// sx1301Config assigns the uplink channels of the plan to the two radios of an SX1301
+// concentrator: up to eight multi-SF channels, one single-SF (LoRa standard) channel
+// and one FSK channel.
+func sx1301Config(plan *region.Plan) (map[string]interface{}, error) {
+	var channels []sx1301Channel
+	var multiSF int
+	var std, fsk bool
+
+	for _, ch := range plan.Channels {
+		minDR, err := plan.DataRate(ch.MinDR)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case minDR.Modulation == region.FSK && !fsk:
+			fsk = true
+			channels = append(channels, sx1301Channel{
+				key:       "chan_FSK",
+				frequency: ch.Frequency,
+				bandwidth: fskBandwidth,
+				extra:     map[string]interface{}{"bandwidth": fskBandwidth * 1000, "datarate": minDR.BitRate},
+			})
+		case minDR.Modulation == region.LoRa && ch.MinDR == ch.MaxDR && minDR.Bandwidth > 125 && !std:
+			std = true
+			channels = append(channels, sx1301Channel{
+				key:       "chan_Lora_std",
+				frequency: ch.Frequency,
+				bandwidth: minDR.Bandwidth,
+				extra:     map[string]interface{}{"bandwidth": minDR.Bandwidth * 1000, "spread_factor": minDR.SpreadingFactor},
+			})
+		case minDR.Modulation == region.LoRa && minDR.Bandwidth == 125 && multiSF < maxMultiSFChannels:
+			channels = append(channels, sx1301Channel{
+				frequency: ch.Frequency,
+				bandwidth: minDR.Bandwidth,
+			})
+			multiSF++
+		}
+	}
+
+	sort.SliceStable(channels, func(i, j int) bool {
+		return channels[i].frequency < channels[j].frequency
+	})
+
+	multiSF = 0
+	for i := range channels {
+		if len(channels[i].key) == 0 {
+			channels[i].key = "chan_multiSF_" + string('0'+byte(multiSF))
+			multiSF++
+		}
+	}
+
+	// try to split the sorted channels over the two radios
+	for split := 0; split <= len(channels); split++ {
+		center0, ok0 := radioCenter(channels[:split])
+		center1, ok1 := radioCenter(channels[split:])
+		if !ok0 || !ok1 {
+			continue
+		}
+
+		if split == 0 {
+			center0 = center1
+		}
+		if split == len(channels) {
+			center1 = center0
+		}
+
+		conf := map[string]interface{}{
+			"radio_0": map[string]interface{}{"enable": true, "freq": center0},
+			"radio_1": map[string]interface{}{"enable": true, "freq": center1},
+		}
+
+		for i, ch := range channels {
+			radio, center := 0, center0
+			if i >= split {
+				radio, center = 1, center1
+			}
+
+			channel := map[string]interface{}{"enable": true, "radio": radio, "if": ch.frequency - center}
+			for k, v := range ch.extra {
+				channel[k] = v
+			}
+			conf[ch.key] = channel
+		}
+
+		return conf, nil
+	}
+
+	return nil, errors.Errorf("the channels of %s do not fit the radios of an sx1301", plan.Name)
+}
+
+// radioCenter returns a center frequency for a radio that can receive all channels.
+func radioCenter(channels []sx1301Channel) (int, bool) {
+	if len(channels) == 0 {
+		return 0, true
+	}
+
+	low, high := 0, int(^uint(0)>>1)
+	for _, ch := range channels {
+		offset := maxIF[ch.bandwidth]
+		if ch.frequency-offset > low {
+			low = ch.frequency - offset
+		}
+		if ch.frequency+offset < high {
+			high = ch.frequency + offset
+		}
+	}
+
+	if low > high {
+		return 0, false
+	}
+
+	return (low + high) / 2, true
+}
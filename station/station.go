@@ -0,0 +1,445 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package station implements the LNS side of the LoRa Basics Station protocol: the
+// router-info discovery and the traffic endpoint that receives the uplinks.
+package station
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-coverage/coverage"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/bullettime/lora-coverage/region"
+	"github.com/bullettime/lora-coverage/websocket"
+	"github.com/pkg/errors"
+)
+
+const (
+	routerInfoPath = "/router-info"
+	trafficPath    = "/traffic/"
+	// gpsLeapSeconds is the difference between GPS time and UTC.
+	gpsLeapSeconds = 18
+	// trafficPingInterval is how often the gateways are pinged, a connection is
+	// closed when nothing was received for trafficIdleTimeout.
+	trafficPingInterval = 30 * time.Second
+	trafficIdleTimeout  = 3 * trafficPingInterval
+)
+
+var gpsEpoch = time.Date(1980, time.January, 6, 0, 0, 0, 0, time.UTC)
+
+// Server is an http.Handler for the Basics Station endpoints, the uplinks that are
+// received are added to the model like the rx packets of the lora-logger (see
+// coverage.AddRxPacket). The coverage rows are annotated with the channel plan of the
+// model, the plan of the server configures the gateways.
+type Server struct {
+	Plan  *region.Plan
+	Model *model.Model
+}
+
+type routerInfoRequest struct {
+	Router json.RawMessage `json:"router"`
+}
+
+type routerInfoResponse struct {
+	Router string `json:"router"`
+	Muxs   string `json:"muxs,omitempty"`
+	URI    string `json:"uri,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type message struct {
+	MsgType string `json:"msgtype"`
+}
+
+type timeSync struct {
+	MsgType string  `json:"msgtype"`
+	TxTime  float64 `json:"txtime"`
+	GPSTime int64   `json:"gpstime,omitempty"`
+}
+
+// uplinkDataFrame is an updf message, a data frame split in its fields.
+type uplinkDataFrame struct {
+	MHdr       uint8  `json:"MHdr"`
+	DevAddr    int32  `json:"DevAddr"`
+	FCtrl      uint8  `json:"FCtrl"`
+	FCnt       uint16 `json:"FCnt"`
+	FOpts      string `json:"FOpts"`
+	FPort      int    `json:"FPort"`
+	FRMPayload string `json:"FRMPayload"`
+	MIC        int32  `json:"MIC"`
+	DR         int    `json:"DR"`
+	Freq       int    `json:"Freq"`
+	UpInfo     struct {
+		GPSTime int64   `json:"gpstime"`
+		RSSI    float64 `json:"rssi"`
+		SNR     float64 `json:"snr"`
+		RxTime  float64 `json:"rxtime"`
+		XTime   int64   `json:"xtime"`
+		FTS     *int64  `json:"fts"`
+	} `json:"upinfo"`
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == routerInfoPath:
+		s.serveRouterInfo(w, r)
+	case strings.HasPrefix(r.URL.Path, trafficPath):
+		s.serveTraffic(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveRouterInfo tells a gateway which traffic endpoint to connect to.
+func (s *Server) serveRouterInfo(w http.ResponseWriter, r *http.Request) {
+	ctx := log.WithField("remote", r.RemoteAddr)
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		ctx.WithError(err).Error("upgrading router-info connection")
+		return
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		ctx.WithError(err).Error("reading router-info request")
+		return
+	}
+
+	var request routerInfoRequest
+	var response routerInfoResponse
+
+	gateway, err := parseRouterInfo(data, &request)
+	if err != nil {
+		response.Router = string(request.Router)
+		response.Error = err.Error()
+	} else {
+		scheme := "ws"
+		if r.TLS != nil {
+			scheme = "wss"
+		}
+
+		response.Router = id6(gateway)
+		response.Muxs = "muxs-::0"
+		response.URI = fmt.Sprintf("%s://%s%s%s", scheme, r.Host, trafficPath, gateway)
+
+		ctx.WithField("gateway", gateway).Info("router-info")
+	}
+
+	s.writeJSON(conn, response)
+}
+
+func parseRouterInfo(data []byte, request *routerInfoRequest) (model.MacAddress, error) {
+	if err := json.Unmarshal(data, request); err != nil {
+		return model.MacAddress{}, errors.Wrap(err, "invalid router-info request")
+	}
+	return parseRouterID(request.Router)
+}
+
+// serveTraffic handles the messages of a connected gateway.
+func (s *Server) serveTraffic(w http.ResponseWriter, r *http.Request) {
+	gateway, err := parseRouterID(json.RawMessage(strconv.Quote(strings.TrimPrefix(r.URL.Path, trafficPath))))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	ctx := log.WithFields(log.Fields{"gateway": gateway, "remote": r.RemoteAddr})
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		ctx.WithError(err).Error("upgrading traffic connection")
+		return
+	}
+	defer conn.Close()
+
+	ctx.Info("gateway connected")
+
+	// pings detect half-open connections, the gateway answers them with pongs
+	conn.SetIdleTimeout(trafficIdleTimeout)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(trafficPingInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := conn.Ping(nil); err != nil {
+					ctx.WithError(err).Debug("sending ping")
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err == websocket.ClosedError {
+			ctx.Info("gateway disconnected")
+			return
+		}
+		if err != nil {
+			ctx.WithError(err).Error("reading gateway message")
+			return
+		}
+
+		var msg message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			ctx.WithError(err).WithField("message", string(data)).Error("unmarshalling message")
+			continue
+		}
+
+		switch msg.MsgType {
+		case "version":
+			ctx.WithField("version", string(data)).Debug("gateway version")
+
+			config, err := newRouterConfig(s.Plan)
+			if err != nil {
+				ctx.WithError(err).Error("creating router config")
+				return
+			}
+			if err := s.writeJSON(conn, config); err != nil {
+				ctx.WithError(err).Error("sending router config")
+				return
+			}
+		case "updf":
+			s.handleUplink(ctx, gateway, data)
+		case "timesync":
+			var request timeSync
+			if err := json.Unmarshal(data, &request); err != nil {
+				ctx.WithError(err).Error("unmarshalling timesync")
+				continue
+			}
+
+			request.GPSTime = int64(time.Since(gpsEpoch)/time.Microsecond) + gpsLeapSeconds*1e6
+			if err := s.writeJSON(conn, request); err != nil {
+				ctx.WithError(err).Error("sending timesync")
+				return
+			}
+		default:
+			ctx.WithField("msgtype", msg.MsgType).Debug("ignoring message")
+		}
+	}
+}
+
+func (s *Server) handleUplink(ctx log.Interface, gateway model.MacAddress, data []byte) {
+	var frame uplinkDataFrame
+	if err := json.Unmarshal(data, &frame); err != nil {
+		ctx.WithError(err).WithField("message", string(data)).Error("unmarshalling updf")
+		return
+	}
+
+	packet, err := frame.rxPacket(gateway, s.Plan)
+	if err != nil {
+		ctx.WithError(err).WithField("message", string(data)).Error("converting updf")
+		return
+	}
+	packet.Raw = data
+
+	if err := coverage.AddRxPacket(s.Model, packet); err != nil {
+		ctx.WithError(err).WithField("message", string(data)).Error("error processing updf")
+	}
+}
+
+// rxPacket rebuilds the PHYPayload of the frame and converts it to the packet the
+// lora-logger would have produced.
+func (f *uplinkDataFrame) rxPacket(gateway model.MacAddress, plan *region.Plan) (*model.RxPacket, error) {
+	dr, err := plan.DataRate(f.DR)
+	if err != nil {
+		return nil, err
+	}
+
+	fOpts, err := hex.DecodeString(f.FOpts)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid FOpts")
+	}
+
+	frmPayload, err := hex.DecodeString(f.FRMPayload)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid FRMPayload")
+	}
+
+	phy := []byte{f.MHdr}
+	phy = appendUint32(phy, uint32(f.DevAddr))
+	phy = append(phy, f.FCtrl, byte(f.FCnt), byte(f.FCnt>>8))
+	phy = append(phy, fOpts...)
+	if f.FPort >= 0 {
+		phy = append(phy, byte(f.FPort))
+	}
+	phy = append(phy, frmPayload...)
+	phy = appendUint32(phy, uint32(f.MIC))
+
+	packet := &model.RxPacket{
+		GatewayMac: gateway,
+		Time:       model.CompactTime(f.time()),
+		Frequency:  float64(f.Freq) / 1e6,
+		Crc:        1,
+		RSSI:       int16(math.Round(f.UpInfo.RSSI)),
+		SNR:        f.UpInfo.SNR,
+		Plan:       plan,
+		Size:       uint16(len(phy)),
+		Data:       base64.StdEncoding.EncodeToString(phy),
+		// the lower 32 bits of xtime are the counter of the concentrator
+		Timestamp: uint32(f.UpInfo.XTime),
+	}
+
+	// fts is -1 when the gateway has no fine timestamp
+	if f.UpInfo.FTS != nil && *f.UpInfo.FTS >= 0 {
+		fts := uint32(*f.UpInfo.FTS)
+		packet.FineTimestamp = &fts
+	}
+
+	if dr.Modulation == region.FSK {
+		packet.Modulation = model.ModulationFSK
+		packet.DataR = model.DataRate{FSK: uint32(dr.BitRate)}
+	} else {
+		packet.Modulation = model.ModulationLoRa
+		packet.DataR = model.DataRate{LoRa: dr.String()}
+		// the coding rate is not reported, LoRaWAN uplinks use 4/5
+		packet.CodingRate = "4/5"
+	}
+
+	return packet, nil
+}
+
+// time returns the reception time, the GPS time when the gateway has one.
+func (f *uplinkDataFrame) time() time.Time {
+	if f.UpInfo.GPSTime > 0 {
+		return gpsEpoch.Add(time.Duration(f.UpInfo.GPSTime)*time.Microsecond - gpsLeapSeconds*time.Second)
+	}
+
+	if f.UpInfo.RxTime > 0 {
+		sec, frac := math.Modf(f.UpInfo.RxTime)
+		return time.Unix(int64(sec), int64(frac*1e9)).UTC()
+	}
+
+	return time.Now().UTC()
+}
+
+func (s *Server) writeJSON(conn *websocket.Conn, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "error marshalling message")
+	}
+	return conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// parseRouterID parses the id of a gateway, which is either an integer, an ID6 string
+// (eg. b827:ebff:fe61:0cd6) or a hex eui (optionally prefixed with eui-).
+func parseRouterID(raw json.RawMessage) (model.MacAddress, error) {
+	var mac model.MacAddress
+
+	if id, err := strconv.ParseUint(string(raw), 10, 64); err == nil {
+		binary.BigEndian.PutUint64(mac[:], id)
+		return mac, nil
+	}
+
+	var id string
+	if err := json.Unmarshal(raw, &id); err != nil {
+		return mac, errors.Errorf("invalid router id: %s", raw)
+	}
+
+	id = strings.TrimPrefix(strings.ToLower(id), "eui-")
+	if strings.Contains(id, ":") {
+		return parseID6(id)
+	}
+
+	b, err := hex.DecodeString(strings.Replace(id, "-", "", -1))
+	if err != nil || len(b) != len(mac) {
+		return mac, errors.Errorf("invalid router id: %s", id)
+	}
+
+	copy(mac[:], b)
+	return mac, nil
+}
+
+// parseID6 parses an eui in the IPv6 like ID6 notation, eg. 1::2 or b827:ebff:fe61:cd6.
+func parseID6(id string) (model.MacAddress, error) {
+	var mac model.MacAddress
+
+	var groups []string
+	if parts := strings.Split(id, "::"); len(parts) == 2 {
+		head := splitGroups(parts[0])
+		tail := splitGroups(parts[1])
+		if len(head)+len(tail) > 3 {
+			return mac, errors.Errorf("invalid router id: %s", id)
+		}
+		groups = append(head, make([]string, 4-len(head)-len(tail))...)
+		groups = append(groups, tail...)
+	} else if len(parts) == 1 {
+		groups = splitGroups(id)
+	}
+
+	if len(groups) != 4 {
+		return mac, errors.Errorf("invalid router id: %s", id)
+	}
+
+	for i, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		v, err := strconv.ParseUint(group, 16, 16)
+		if err != nil {
+			return mac, errors.Errorf("invalid router id: %s", id)
+		}
+		binary.BigEndian.PutUint16(mac[i*2:], uint16(v))
+	}
+
+	return mac, nil
+}
+
+func splitGroups(s string) []string {
+	if len(s) == 0 {
+		return nil
+	}
+	return strings.Split(s, ":")
+}
+
+// id6 formats an eui in the ID6 notation.
+func id6(mac model.MacAddress) string {
+	groups := make([]string, 4)
+	for i := range groups {
+		groups[i] = fmt.Sprintf("%x", binary.BigEndian.Uint16(mac[i*2:]))
+	}
+	return strings.Join(groups, ":")
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
@@ -0,0 +1,271 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package station
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/brocaar/lorawan"
+	"github.com/bullettime/lora-coverage/coverage"
+	"github.com/bullettime/lora-coverage/model"
+	"github.com/bullettime/lora-coverage/region"
+)
+
+func TestParseRouterID(t *testing.T) {
+	tests := map[string]string{
+		`"b827:ebff:fe61:cd6"`:      "b827ebfffe610cd6",
+		`"::1"`:                     "0000000000000001",
+		`"1::"`:                     "0001000000000000",
+		`"eui-B827EBFFFE610CD6"`:    "b827ebfffe610cd6",
+		`"b8-27-eb-ff-fe-61-0c-d6"`: "b827ebfffe610cd6",
+		`1`:                         "0000000000000001",
+	}
+
+	for id, expected := range tests {
+		mac, err := parseRouterID(json.RawMessage(id))
+		if err != nil {
+			t.Errorf("%s: %s", id, err)
+			continue
+		}
+		if mac.String() != expected {
+			t.Errorf("%s: got %s, expected %s", id, mac, expected)
+		}
+	}
+
+	if _, err := parseRouterID(json.RawMessage(`"1:2:3:4:5"`)); err == nil {
+		t.Error("expected an error for an invalid id")
+	}
+}
+
+func TestRouterConfig(t *testing.T) {
+	for _, name := range []string{"EU868", "US915"} {
+		plan, err := region.Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config, err := newRouterConfig(plan)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		conf := config.SX1301Conf[0]
+		for _, ch := range plan.Channels {
+			found := false
+			for key, value := range conf {
+				channel, ok := value.(map[string]interface{})
+				if !ok || !strings.HasPrefix(key, "chan_") {
+					continue
+				}
+				radio := conf[fmt.Sprintf("radio_%d", channel["radio"])].(map[string]interface{})
+				if radio["freq"].(int)+channel["if"].(int) == ch.Frequency {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("%s: channel %d not configured", name, ch.Frequency)
+			}
+		}
+
+		for key, value := range conf {
+			if channel, ok := value.(map[string]interface{}); ok && strings.HasPrefix(key, "chan_") {
+				if offset := channel["if"].(int); offset < -400000 || offset > 400000 {
+					t.Errorf("%s: %s too far from its radio: %d", name, key, offset)
+				}
+			}
+		}
+	}
+}
+
+func TestUplinkDataFrame(t *testing.T) {
+	phy, _ := hex.DecodeString("40da1b0126000100" + "01" + "aabbcc" + "11223344")
+
+	data := `{"msgtype":"updf","MHdr":64,"DevAddr":637606874,"FCtrl":0,"FCnt":1,"FOpts":"","FPort":1,
+"FRMPayload":"aabbcc","MIC":1144201745,"RefTime":0,"DR":5,"Freq":868100000,
+"upinfo":{"rctx":0,"xtime":0,"gpstime":1207000000000000,"rssi":-61.4,"snr":8.25,"rxtime":0}}`
+
+	var frame uplinkDataFrame
+	if err := json.Unmarshal([]byte(data), &frame); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, _ := region.Get("EU868")
+	packet, err := frame.rxPacket(model.MacAddress{1, 2, 3, 4, 5, 6, 7, 8}, plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if packet.Data != base64.StdEncoding.EncodeToString(phy) {
+		t.Errorf("wrong phy payload: %s", packet.Data)
+	}
+	if packet.DataR.String() != "SF7BW125" || packet.Frequency != 868.1 {
+		t.Errorf("wrong data rate or frequency: %s %f", packet.DataR, packet.Frequency)
+	}
+	if packet.RSSI != -61 || packet.SNR != 8.25 {
+		t.Errorf("wrong rssi or snr: %d %f", packet.RSSI, packet.SNR)
+	}
+	if packet.Time.String() != "2018-04-05T21:46:22Z" {
+		t.Errorf("wrong time: %s", packet.Time)
+	}
+}
+
+func TestRouterInfo(t *testing.T) {
+	plan, _ := region.Get("EU868")
+	server := httptest.NewServer(&Server{Plan: plan})
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "GET /router-info HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n", server.Listener.Addr())
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("unexpected status: %s", response.Status)
+	}
+	if accept := response.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("wrong accept key: %s", accept)
+	}
+
+	// send a masked text frame
+	request := []byte(`{"router":"b827:ebff:fe61:cd6"}`)
+	mask := []byte{1, 2, 3, 4}
+	frame := append([]byte{0x81, 0x80 | byte(len(request))}, mask...)
+	for i, b := range request {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, header[1]&0x7f)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		t.Fatal(err)
+	}
+
+	var info routerInfoResponse
+	if err := json.Unmarshal(body, &info); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedURI := fmt.Sprintf("ws://%s/traffic/b827ebfffe610cd6", server.Listener.Addr())
+	if info.Router != "b827:ebff:fe61:cd6" || info.URI != expectedURI || len(info.Error) > 0 {
+		t.Errorf("unexpected router-info response: %+v", info)
+	}
+}
+
+// TestUplinkConfFCnt sends a LoRaWAN 1.1 uplink that acknowledges a confirmed
+// downlink, its MIC covers the frame counter of the downlink.
+func TestUplinkConfFCnt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lora-coverage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	plan, _ := region.Get("EU868")
+	key := "000102030405060708090a0b0c0d0e0f"
+	store := &model.KeyStore{Devices: map[string]model.KeySettings{"26011b2c": {Version: model.LoRaWAN11,
+		FNwkSIntKey: key, SNwkSIntKey: key, NwkSEncKey: key, AppSKey: key}}}
+
+	db, err := coverage.Open(filepath.Join(dir, "coverage.db"), model.Config{Plan: plan, Keys: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// confirmed data down with FCnt 5
+	downlink := []byte{0xa0, 0x2c, 0x1b, 0x01, 0x26, 0x00, 0x05, 0x00, 0x01, 0x02, 0x03, 0x04}
+	line := `{"fields":{"gateway mac":"0102030405060708","token":1,"data":"` +
+		base64.StdEncoding.EncodeToString(downlink) + `"},"timestamp":"2018-04-05T21:00:00Z","message":"PULL_RESP: TXPK"}`
+	if err := coverage.AddLine(db.Model(), []byte(line)); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := store.DeviceKeys("26011b2c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := model.EncodePayload(50.9643, 1.088, 14)
+	if err != nil {
+		t.Fatal(err)
+	}
+	confFCnt := uint16(5)
+	// SF7BW125 (DR5) on 868.1 MHz (channel 0)
+	phy, err := model.EncodeUplink(&model.UplinkFrame{DevAddr: lorawan.DevAddr{0x26, 0x01, 0x1b, 0x2c}, FCnt: 3,
+		FPort: 1, ACK: true, Payload: payload}, keys, model.MICContext{FCnt: 3, ConfFCnt: &confFCnt, TxDR: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rxTime := time.Date(2018, 4, 5, 21, 0, 1, 0, time.UTC)
+	data := fmt.Sprintf(`{"msgtype":"updf","MHdr":%d,"DevAddr":%d,"FCtrl":%d,"FCnt":3,"FOpts":"","FPort":%d,`+
+		`"FRMPayload":"%x","MIC":%d,"DR":5,"Freq":868100000,"upinfo":{"rssi":-61,"snr":8.25,"rxtime":%d}}`,
+		phy[0], int32(binary.LittleEndian.Uint32(phy[1:5])), phy[5], phy[8], phy[9:len(phy)-4],
+		int32(binary.LittleEndian.Uint32(phy[len(phy)-4:])), rxTime.Unix())
+
+	server := &Server{Plan: plan, Model: db.Model()}
+	server.handleUplink(log.Log, model.MacAddress{1, 2, 3, 4, 5, 6, 7, 8}, []byte(data))
+
+	rows, err := db.Rows(context.Background(), coverage.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		t.Fatalf("expected a coverage row: %v", rows.Err())
+	}
+	if row := rows.Row(); *row.FCnt != 3 || !row.FCtrl.ACK || row.Power != 14 {
+		t.Errorf("wrong row: %+v", row)
+	}
+}
@@ -0,0 +1,307 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package track reads externally logged GPS tracks and looks up the position at a
+// given time.
+package track
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Point is a position of the track.
+type Point struct {
+	Time      time.Time
+	Latitude  float64
+	Longitude float64
+}
+
+// Track is a list of points sorted by time.
+type Track []Point
+
+// Options control how a position is looked up.
+type Options struct {
+	// Offset is added to the times of the track to align its clock with the clock
+	// of the gateways.
+	Offset time.Duration
+	// Interpolate between the surrounding points instead of taking the nearest one.
+	Interpolate bool
+	// MaxGap is the maximum time between the looked up time and the nearest point,
+	// when interpolating it is the maximum time between the surrounding points.
+	MaxGap time.Duration
+}
+
+var (
+	UnknownFormatError = errors.New("unknown track format (use gpx or csv)")
+	EmptyTrackError    = errors.New("track contains no points")
+)
+
+// Read reads a track in the given format ("gpx" or "csv"), with an empty format it
+// is taken from the extension of the name.
+func Read(r io.Reader, format, name string) (Track, error) {
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(name), ".")
+	}
+
+	switch strings.ToLower(format) {
+	case "gpx":
+		return ReadGPX(r)
+	case "csv", "txt":
+		return ReadCSV(r)
+	}
+
+	return nil, UnknownFormatError
+}
+
+type gpxPoint struct {
+	Latitude  float64 `xml:"lat,attr"`
+	Longitude float64 `xml:"lon,attr"`
+	Time      string  `xml:"time"`
+}
+
+type gpxFile struct {
+	WayPoints   []gpxPoint `xml:"wpt"`
+	RoutePoints []gpxPoint `xml:"rte>rtept"`
+	TrackPoints []gpxPoint `xml:"trk>trkseg>trkpt"`
+}
+
+// ReadGPX reads the track, route and way points with a time of a GPX file.
+func ReadGPX(r io.Reader) (Track, error) {
+	var gpx gpxFile
+
+	if err := xml.NewDecoder(r).Decode(&gpx); err != nil {
+		return nil, errors.Wrap(err, "error decoding gpx")
+	}
+
+	var track Track
+
+	for _, points := range [][]gpxPoint{gpx.TrackPoints, gpx.RoutePoints, gpx.WayPoints} {
+		for _, p := range points {
+			if p.Time == "" {
+				continue
+			}
+
+			t, err := parseTime(p.Time)
+			if err != nil {
+				return nil, err
+			}
+
+			track = append(track, Point{Time: t, Latitude: p.Latitude, Longitude: p.Longitude})
+		}
+	}
+
+	return track.sorted()
+}
+
+// ReadCSV reads a CSV file with a header row. The columns are found by name: time
+// (or timestamp, date), lat (or latitude) and lon (or lng, long, longitude). Times
+// are RFC 3339 or unix timestamps in (milli)seconds. Besides commas, semicolons and
+// tabs are accepted as separator.
+func ReadCSV(r io.Reader) (Track, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading csv")
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = separator(data)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, EmptyTrackError
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading csv header")
+	}
+
+	timeColumn := column(header, "time", "timestamp", "date", "datetime")
+	latColumn := column(header, "lat", "latitude")
+	lonColumn := column(header, "lon", "lng", "long", "longitude")
+	if timeColumn < 0 || latColumn < 0 || lonColumn < 0 {
+		return nil, errors.Errorf("csv header needs time, lat and lon columns: %s", strings.Join(header, ","))
+	}
+
+	var track Track
+
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading csv line %d", line)
+		}
+
+		if len(record) <= timeColumn || len(record) <= latColumn || len(record) <= lonColumn {
+			return nil, errors.Errorf("missing columns on csv line %d", line)
+		}
+
+		t, err := parseTime(record[timeColumn])
+		if err != nil {
+			return nil, errors.Wrapf(err, "csv line %d", line)
+		}
+
+		lat, err := strconv.ParseFloat(strings.TrimSpace(record[latColumn]), 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid latitude on csv line %d", line)
+		}
+
+		lon, err := strconv.ParseFloat(strings.TrimSpace(record[lonColumn]), 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid longitude on csv line %d", line)
+		}
+
+		track = append(track, Point{Time: t, Latitude: lat, Longitude: lon})
+	}
+
+	return track.sorted()
+}
+
+// Position returns the position at the given time, false when the track has no
+// point close enough.
+func (t Track) Position(at time.Time, options Options) (float64, float64, bool) {
+	at = at.Add(-options.Offset)
+
+	// first point at or after the time
+	i := sort.Search(len(t), func(i int) bool {
+		return !t[i].Time.Before(at)
+	})
+
+	if i < len(t) && t[i].Time.Equal(at) {
+		return t[i].Latitude, t[i].Longitude, true
+	}
+
+	if options.Interpolate {
+		if i == 0 || i == len(t) {
+			return 0, 0, false
+		}
+
+		before, after := t[i-1], t[i]
+		gap := after.Time.Sub(before.Time)
+		if options.MaxGap > 0 && gap > options.MaxGap {
+			return 0, 0, false
+		}
+
+		fraction := float64(at.Sub(before.Time)) / float64(gap)
+		return before.Latitude + (after.Latitude-before.Latitude)*fraction,
+			before.Longitude + (after.Longitude-before.Longitude)*fraction, true
+	}
+
+	nearest := -1
+	var distance time.Duration
+	for _, j := range []int{i - 1, i} {
+		if j < 0 || j >= len(t) {
+			continue
+		}
+		d := absDuration(at.Sub(t[j].Time))
+		if nearest < 0 || d < distance {
+			nearest, distance = j, d
+		}
+	}
+
+	if nearest < 0 || (options.MaxGap > 0 && distance > options.MaxGap) {
+		return 0, 0, false
+	}
+
+	return t[nearest].Latitude, t[nearest].Longitude, true
+}
+
+func (t Track) sorted() (Track, error) {
+	if len(t) == 0 {
+		return nil, EmptyTrackError
+	}
+
+	sort.SliceStable(t, func(i, j int) bool {
+		return t[i].Time.Before(t[j].Time)
+	})
+
+	return t, nil
+}
+
+// separator guesses the separator from the header row.
+func separator(data []byte) rune {
+	header := data
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		header = data[:i]
+	}
+
+	for _, sep := range []rune{',', ';', '\t'} {
+		if bytes.ContainsRune(header, sep) {
+			return sep
+		}
+	}
+
+	return ','
+}
+
+func column(header []string, names ...string) int {
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(h))
+		for _, name := range names {
+			if h == name {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func parseTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999Z07:00", "2006-01-02 15:04:05.999999999"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		// unix time in milliseconds (anything after 2001-09-09 in seconds)
+		if f > 1e12 {
+			return time.Unix(0, int64(f*1e6)).UTC(), nil
+		}
+		sec := int64(f)
+		return time.Unix(sec, int64((f-float64(sec))*1e9)).UTC(), nil
+	}
+
+	return time.Time{}, errors.Errorf("invalid time: %s", value)
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,139 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package track
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const gpx = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <trk><trkseg>
+    <trkpt lat="51.2" lon="4.2"><time>2018-04-05T21:46:10Z</time></trkpt>
+    <trkpt lat="51.0" lon="4.0"><time>2018-04-05T21:45:50Z</time></trkpt>
+    <trkpt lat="51.1" lon="4.1"><time>2018-04-05T21:46:00Z</time></trkpt>
+  </trkseg></trk>
+</gpx>`
+
+func at(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestReadGPX(t *testing.T) {
+	track, err := Read(strings.NewReader(gpx), "", "drive.GPX")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(track) != 3 {
+		t.Fatalf("expected 3 points, got %d", len(track))
+	}
+	if !track[0].Time.Equal(at("2018-04-05T21:45:50Z")) || track[0].Latitude != 51.0 {
+		t.Errorf("track not sorted: %+v", track)
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"rfc3339", "Time,Latitude,Longitude\n2018-04-05T21:46:00Z,51.1,4.1\n2018-04-05T21:45:50Z,51.0,4.0\n"},
+		{"semicolon", "lat;lon;timestamp\n51.1;4.1;1522964760\n51.0;4.0;1522964750\n"},
+		{"milliseconds", "time\tlng\tlat\n1522964760000\t4.1\t51.1\n1522964750000\t4.0\t51.0\n"},
+	}
+
+	for _, test := range tests {
+		track, err := ReadCSV(strings.NewReader(test.data))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		want := Track{
+			{Time: at("2018-04-05T21:45:50Z"), Latitude: 51.0, Longitude: 4.0},
+			{Time: at("2018-04-05T21:46:00Z"), Latitude: 51.1, Longitude: 4.1},
+		}
+		if len(track) != len(want) {
+			t.Errorf("%s: expected %d points, got %d", test.name, len(want), len(track))
+			continue
+		}
+		for i := range want {
+			if !track[i].Time.Equal(want[i].Time) || track[i].Latitude != want[i].Latitude || track[i].Longitude != want[i].Longitude {
+				t.Errorf("%s: point %d: expected %+v, got %+v", test.name, i, want[i], track[i])
+			}
+		}
+	}
+
+	if _, err := ReadCSV(strings.NewReader("when,where\n")); err == nil {
+		t.Error("expected an error for missing columns")
+	}
+}
+
+func TestPosition(t *testing.T) {
+	track, err := ReadGPX(strings.NewReader(gpx))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		time    string
+		options Options
+		ok      bool
+		lat     float64
+		lon     float64
+	}{
+		{"exact", "2018-04-05T21:46:00Z", Options{}, true, 51.1, 4.1},
+		{"interpolated", "2018-04-05T21:46:05Z", Options{Interpolate: true}, true, 51.15, 4.15},
+		{"nearest", "2018-04-05T21:46:04Z", Options{}, true, 51.1, 4.1},
+		{"offset", "2018-04-05T21:46:07Z", Options{Offset: 2 * time.Second, Interpolate: true}, true, 51.15, 4.15},
+		{"gap too large", "2018-04-05T21:46:05Z", Options{Interpolate: true, MaxGap: 5 * time.Second}, false, 0, 0},
+		{"nearest too far", "2018-04-05T21:46:05Z", Options{MaxGap: 4 * time.Second}, false, 0, 0},
+		{"before track", "2018-04-05T21:45:00Z", Options{Interpolate: true}, false, 0, 0},
+		{"after track", "2018-04-05T21:46:12Z", Options{MaxGap: 5 * time.Second}, true, 51.2, 4.2},
+	}
+
+	for _, test := range tests {
+		lat, lon, ok := track.Position(at(test.time), test.options)
+		if ok != test.ok {
+			t.Errorf("%s: expected ok %v, got %v", test.name, test.ok, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if d := lat - test.lat; d > 1e-9 || d < -1e-9 {
+			t.Errorf("%s: expected lat %v, got %v", test.name, test.lat, lat)
+		}
+		if d := lon - test.lon; d > 1e-9 || d < -1e-9 {
+			t.Errorf("%s: expected lon %v, got %v", test.name, test.lon, lon)
+		}
+	}
+}
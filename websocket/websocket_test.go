@@ -0,0 +1,482 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeConn reads the frames of the client from in and collects the frames of the
+// server in out.
+type fakeConn struct {
+	net.Conn
+	in     *bytes.Reader
+	out    bytes.Buffer
+	closed bool
+}
+
+func (c *fakeConn) Read(b []byte) (int, error)       { return c.in.Read(b) }
+func (c *fakeConn) Write(b []byte) (int, error)      { return c.out.Write(b) }
+func (c *fakeConn) Close() error                     { c.closed = true; return nil }
+func (c *fakeConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *fakeConn) SetWriteDeadline(time.Time) error { return nil }
+
+type frame struct {
+	fin     bool
+	opcode  byte
+	payload string
+}
+
+// clientFrame encodes a frame like a client does, with a mask unless unmasked is set.
+func clientFrame(f frame, unmasked bool) []byte {
+	b := []byte{f.opcode}
+	if f.fin {
+		b[0] |= 0x80
+	}
+
+	maskBit := byte(0x80)
+	if unmasked {
+		maskBit = 0
+	}
+
+	switch length := len(f.payload); {
+	case length < 126:
+		b = append(b, maskBit|byte(length))
+	case length <= 0xffff:
+		b = append(b, maskBit|126, byte(length>>8), byte(length))
+	default:
+		var l [8]byte
+		binary.BigEndian.PutUint64(l[:], uint64(length))
+		b = append(append(b, maskBit|127), l[:]...)
+	}
+
+	if unmasked {
+		return append(b, f.payload...)
+	}
+
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	b = append(b, mask...)
+	for i := 0; i < len(f.payload); i++ {
+		b = append(b, f.payload[i]^mask[i%4])
+	}
+	return b
+}
+
+// serverFrames decodes the unmasked frames written by the server.
+func serverFrames(t *testing.T, data []byte) []frame {
+	var frames []frame
+	for len(data) > 0 {
+		if len(data) < 2 {
+			t.Fatalf("truncated frame: %x", data)
+		}
+		if data[1]&0x80 != 0 {
+			t.Fatal("server frame is masked")
+		}
+
+		f := frame{fin: data[0]&0x80 != 0, opcode: data[0] & 0x0f}
+		length := uint64(data[1] & 0x7f)
+		data = data[2:]
+
+		switch length {
+		case 126:
+			length = uint64(binary.BigEndian.Uint16(data))
+			data = data[2:]
+		case 127:
+			length = binary.BigEndian.Uint64(data)
+			data = data[8:]
+		}
+
+		f.payload = string(data[:length])
+		data = data[length:]
+		frames = append(frames, f)
+	}
+	return frames
+}
+
+func newFakeConn(frames []frame, unmasked bool) (*Conn, *fakeConn) {
+	var in []byte
+	for _, f := range frames {
+		in = append(in, clientFrame(f, unmasked)...)
+	}
+
+	fake := &fakeConn{in: bytes.NewReader(in)}
+	return &Conn{conn: fake, reader: bufio.NewReader(fake)}, fake
+}
+
+func TestReadMessage(t *testing.T) {
+	long := strings.Repeat("a", 300)
+	huge := strings.Repeat("b", 70000)
+
+	tests := []struct {
+		name        string
+		frames      []frame
+		unmasked    bool
+		messageType int
+		message     string
+		replies     []frame
+		err         string
+	}{
+		{
+			name:        "text",
+			frames:      []frame{{true, TextMessage, "hello"}},
+			messageType: TextMessage,
+			message:     "hello",
+		},
+		{
+			name:        "binary",
+			frames:      []frame{{true, BinaryMessage, "\x00\x01\x02"}},
+			messageType: BinaryMessage,
+			message:     "\x00\x01\x02",
+		},
+		{
+			name:        "empty",
+			frames:      []frame{{true, TextMessage, ""}},
+			messageType: TextMessage,
+			message:     "",
+		},
+		{
+			name:        "16 bit length",
+			frames:      []frame{{true, TextMessage, long}},
+			messageType: TextMessage,
+			message:     long,
+		},
+		{
+			name:        "64 bit length",
+			frames:      []frame{{true, TextMessage, huge}},
+			messageType: TextMessage,
+			message:     huge,
+		},
+		{
+			name:     "unmasked",
+			frames:   []frame{{true, TextMessage, "hello"}},
+			unmasked: true,
+			err:      "unmasked client frame",
+		},
+		{
+			name: "fragmented",
+			frames: []frame{
+				{false, TextMessage, "hel"},
+				{false, opContinuation, "lo "},
+				{true, opContinuation, "world"},
+			},
+			messageType: TextMessage,
+			message:     "hello world",
+		},
+		{
+			name:   "continuation without message",
+			frames: []frame{{true, opContinuation, "hello"}},
+			err:    "continuation frame without message",
+		},
+		{
+			name: "new message before the previous one was finished",
+			frames: []frame{
+				{false, TextMessage, "hel"},
+				{true, TextMessage, "lo"},
+			},
+			err: "new message before the previous one was finished",
+		},
+		{
+			name: "ping",
+			frames: []frame{
+				{true, opPing, "are you there"},
+				{true, TextMessage, "hello"},
+			},
+			messageType: TextMessage,
+			message:     "hello",
+			replies:     []frame{{true, opPong, "are you there"}},
+		},
+		{
+			name: "ping between fragments",
+			frames: []frame{
+				{false, TextMessage, "hel"},
+				{true, opPing, ""},
+				{true, opContinuation, "lo"},
+			},
+			messageType: TextMessage,
+			message:     "hello",
+			replies:     []frame{{true, opPong, ""}},
+		},
+		{
+			name: "pong",
+			frames: []frame{
+				{true, opPong, "unsolicited"},
+				{true, BinaryMessage, "hello"},
+			},
+			messageType: BinaryMessage,
+			message:     "hello",
+		},
+		{
+			name:   "fragmented control frame",
+			frames: []frame{{false, opPing, "ping"}},
+			err:    "invalid control frame: opcode 9",
+		},
+		{
+			name:   "control frame too large",
+			frames: []frame{{true, opPing, long}},
+			err:    "invalid control frame: opcode 9",
+		},
+		{
+			name:    "close",
+			frames:  []frame{{true, opClose, "\x03\xe8bye"}},
+			replies: []frame{{true, opClose, "\x03\xe8bye"}},
+			err:     ClosedError.Error(),
+		},
+		{
+			name:   "unknown opcode",
+			frames: []frame{{true, 3, "hello"}},
+			err:    "unknown opcode: 3",
+		},
+		{
+			name:   "eof",
+			frames: []frame{{false, TextMessage, "hel"}},
+			err:    "EOF",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn, fake := newFakeConn(test.frames, test.unmasked)
+
+			messageType, message, err := conn.ReadMessage()
+			if len(test.err) > 0 {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if messageType != test.messageType {
+					t.Errorf("expected message type %d, got %d", test.messageType, messageType)
+				}
+				if string(message) != test.message {
+					t.Errorf("expected message %q, got %q", test.message, message)
+				}
+			}
+
+			replies := serverFrames(t, fake.out.Bytes())
+			if len(replies) != len(test.replies) {
+				t.Fatalf("expected replies %v, got %v", test.replies, replies)
+			}
+			for i := range replies {
+				if replies[i] != test.replies[i] {
+					t.Errorf("expected reply %v, got %v", test.replies[i], replies[i])
+				}
+			}
+
+			if closed := test.err == ClosedError.Error(); fake.closed != closed {
+				t.Errorf("expected closed %v, got %v", closed, fake.closed)
+			}
+		})
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	defer func(size int) { MaxMessageSize = size }(MaxMessageSize)
+	MaxMessageSize = 10
+
+	tests := []struct {
+		name   string
+		frames []frame
+		err    string
+	}{
+		{"frame", []frame{{true, TextMessage, "hello world"}}, "frame too large"},
+		{"fragments", []frame{{false, TextMessage, "hello"}, {true, opContinuation, " world"}}, "message too large"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn, _ := newFakeConn(test.frames, false)
+			if _, _, err := conn.ReadMessage(); err == nil || err.Error() != test.err {
+				t.Fatalf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestWriteMessage(t *testing.T) {
+	tests := []struct {
+		name        string
+		messageType int
+		payload     string
+		header      []byte
+	}{
+		{"7 bit length", TextMessage, "hello", []byte{0x81, 5}},
+		{"16 bit length", BinaryMessage, strings.Repeat("a", 300), []byte{0x82, 126, 0x01, 0x2c}},
+		{"64 bit length", TextMessage, strings.Repeat("a", 70000), []byte{0x81, 127, 0, 0, 0, 0, 0, 0x01, 0x11, 0x70}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn, fake := newFakeConn(nil, false)
+			if err := conn.WriteMessage(test.messageType, []byte(test.payload)); err != nil {
+				t.Fatal(err)
+			}
+
+			out := fake.out.Bytes()
+			if !bytes.HasPrefix(out, test.header) {
+				t.Fatalf("expected header %x, got %x", test.header, out[:len(test.header)])
+			}
+			if payload := string(out[len(test.header):]); payload != test.payload {
+				t.Errorf("expected payload of %d bytes, got %d bytes", len(test.payload), len(payload))
+			}
+		})
+	}
+}
+
+func TestPing(t *testing.T) {
+	conn, fake := newFakeConn(nil, false)
+	if err := conn.Ping([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	frames := serverFrames(t, fake.out.Bytes())
+	if len(frames) != 1 || frames[0] != (frame{true, opPing, "ping"}) {
+		t.Errorf("expected ping frame, got %v", frames)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	conn := &Conn{conn: server, reader: bufio.NewReader(server)}
+	defer conn.Close()
+	conn.SetIdleTimeout(200 * time.Millisecond)
+
+	go func() {
+		// the pong keeps the connection alive past the first timeout
+		time.Sleep(120 * time.Millisecond)
+		client.Write(clientFrame(frame{true, opPong, ""}, false))
+		time.Sleep(120 * time.Millisecond)
+		client.Write(clientFrame(frame{true, TextMessage, "hello"}, false))
+	}()
+
+	if _, message, err := conn.ReadMessage(); err != nil || string(message) != "hello" {
+		t.Fatalf("expected hello, got %q: %v", message, err)
+	}
+
+	_, _, err := conn.ReadMessage()
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		if messageType, message, err := conn.ReadMessage(); err == nil {
+			conn.WriteMessage(messageType, message)
+		}
+	}))
+	defer server.Close()
+
+	t.Run("handshake", func(t *testing.T) {
+		client, err := net.Dial("tcp", server.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		// the example handshake of RFC 6455 section 1.3
+		request := "GET /traffic HTTP/1.1\r\n" +
+			"Host: " + server.Listener.Addr().String() + "\r\n" +
+			"Upgrade: websocket\r\n" +
+			"Connection: keep-alive, Upgrade\r\n" +
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+			"Sec-WebSocket-Version: 13\r\n\r\n"
+		if _, err := client.Write([]byte(request)); err != nil {
+			t.Fatal(err)
+		}
+
+		reader := bufio.NewReader(client)
+		response, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != http.StatusSwitchingProtocols {
+			t.Fatalf("expected status %d, got %d", http.StatusSwitchingProtocols, response.StatusCode)
+		}
+		if accept := response.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+			t.Errorf("unexpected accept key: %s", accept)
+		}
+
+		if _, err := client.Write(clientFrame(frame{true, TextMessage, "echo"}, false)); err != nil {
+			t.Fatal(err)
+		}
+
+		header := make([]byte, 6)
+		if _, err := io.ReadFull(reader, header); err != nil {
+			t.Fatal(err)
+		}
+		if frames := serverFrames(t, header); len(frames) != 1 || frames[0] != (frame{true, TextMessage, "echo"}) {
+			t.Errorf("expected echo, got %v", frames)
+		}
+	})
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{"no upgrade", map[string]string{"Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": "x"}, http.StatusBadRequest},
+		{"wrong version", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "8", "Sec-WebSocket-Key": "x"}, http.StatusUpgradeRequired},
+		{"no key", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13"}, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request, err := http.NewRequest(http.MethodGet, server.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, value := range test.headers {
+				request.Header.Set(name, value)
+			}
+
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+
+			if response.StatusCode != test.status {
+				t.Errorf("expected status %d, got %d", test.status, response.StatusCode)
+			}
+		})
+	}
+}